@@ -51,6 +51,30 @@ CREATE TYPE public.genre_load_status AS ENUM (
 );
 
 
+--
+-- Name: normalize_artist_name(text); Type: FUNCTION; Schema: public; Owner: -
+--
+
+CREATE FUNCTION public.normalize_artist_name(name text) RETURNS text
+    LANGUAGE sql IMMUTABLE
+    AS $$
+    SELECT regexp_replace(lower(btrim(regexp_replace(name, '\s+', ' ', 'g'))), '^the ', '');
+$$;
+
+
+--
+-- Name: normalize_track_title(text); Type: FUNCTION; Schema: public; Owner: -
+--
+
+CREATE FUNCTION public.normalize_track_title(title text) RETURNS text
+    LANGUAGE sql IMMUTABLE
+    AS $$
+    SELECT lower(btrim(regexp_replace(
+        regexp_replace(regexp_replace(title, '\s*[\(\[][^\)\]]*[\)\]]', '', 'g'), '\s+-\s+.*$', ''),
+        '\s+', ' ', 'g')));
+$$;
+
+
 --
 -- TOC entry 270 (class 1255 OID 16422)
 -- Name: notify_track_play_insert(); Type: FUNCTION; Schema: public; Owner: -
@@ -163,7 +187,8 @@ CREATE TABLE public.artists (
     id integer NOT NULL,
     name text NOT NULL,
     created_at timestamp with time zone DEFAULT now(),
-    genre_status public.genre_load_status DEFAULT 'none'::public.genre_load_status NOT NULL
+    genre_status public.genre_load_status DEFAULT 'none'::public.genre_load_status NOT NULL,
+    normalized_name text GENERATED ALWAYS AS (public.normalize_artist_name(name)) STORED
 );
 
 
@@ -190,6 +215,76 @@ CREATE SEQUENCE public.artists_id_seq
 ALTER SEQUENCE public.artists_id_seq OWNED BY public.artists.id;
 
 
+--
+-- Name: binge_sessions; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.binge_sessions (
+    id uuid DEFAULT gen_random_uuid() NOT NULL,
+    user_id bigint NOT NULL,
+    started_at timestamp with time zone NOT NULL,
+    ended_at timestamp with time zone NOT NULL,
+    detected_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: daily_artist_listening; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.daily_artist_listening (
+    day date NOT NULL,
+    artist_id integer NOT NULL,
+    plays integer NOT NULL,
+    duration_ms bigint NOT NULL
+);
+
+
+--
+-- Name: daily_genre_listening; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.daily_genre_listening (
+    day date NOT NULL,
+    genre_id integer NOT NULL,
+    plays integer NOT NULL,
+    weighted_plays double precision NOT NULL,
+    weighted_duration_ms double precision NOT NULL
+);
+
+
+--
+-- Name: daily_listening; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.daily_listening (
+    day date NOT NULL,
+    plays integer NOT NULL,
+    skips integer NOT NULL,
+    duration_ms bigint NOT NULL
+);
+
+
+--
+-- Name: external_tracks; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.external_tracks (
+    source text NOT NULL,
+    external_id text NOT NULL,
+    title text NOT NULL,
+    artist text,
+    album text,
+    duration_ms integer,
+    isrc text,
+    play_count integer NOT NULL,
+    last_played_at timestamp with time zone,
+    track_id integer,
+    imported_at timestamp with time zone DEFAULT now() NOT NULL,
+    CONSTRAINT external_tracks_source_check CHECK ((source = 'apple_music'::text))
+);
+
+
 --
 -- TOC entry 221 (class 1259 OID 16443)
 -- Name: genres; Type: TABLE; Schema: public; Owner: -
@@ -224,6 +319,108 @@ CREATE SEQUENCE public.genres_id_seq
 ALTER SEQUENCE public.genres_id_seq OWNED BY public.genres.id;
 
 
+--
+-- Name: milestones; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.milestones (
+    kind text NOT NULL,
+    subject_id integer DEFAULT 0 NOT NULL,
+    subject text NOT NULL,
+    count integer NOT NULL,
+    played_at timestamp with time zone NOT NULL,
+    track_play_id integer NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    CONSTRAINT milestones_kind_check CHECK ((kind = ANY (ARRAY['total_plays'::text, 'artist_plays'::text, 'track_plays'::text, 'new_artist'::text])))
+);
+
+
+--
+-- Name: monthly_discoveries; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.monthly_discoveries (
+    month date NOT NULL,
+    new_artists integer DEFAULT 0 NOT NULL,
+    new_tracks integer DEFAULT 0 NOT NULL,
+    new_genres integer DEFAULT 0 NOT NULL,
+    computed_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: rollup_state; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.rollup_state (
+    id boolean DEFAULT true NOT NULL,
+    refreshed_through date NOT NULL,
+    refreshed_at timestamp with time zone DEFAULT now() NOT NULL,
+    tz text,
+    CONSTRAINT rollup_state_id_check CHECK (id)
+);
+
+
+--
+-- Name: skip_events; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.skip_events (
+    track_play_id integer NOT NULL,
+    rule text NOT NULL,
+    expected_ms integer,
+    played_ms integer NOT NULL,
+    ratio real,
+    threshold real NOT NULL,
+    min_skip_ms integer NOT NULL,
+    skipped boolean NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    CONSTRAINT skip_events_rule_check CHECK ((rule = ANY (ARRAY['ratio'::text, 'remaining_ms'::text, 'unknown_duration'::text])))
+);
+
+
+--
+-- Name: rabbit_holes; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.rabbit_holes (
+    id uuid DEFAULT gen_random_uuid() NOT NULL,
+    user_id bigint NOT NULL,
+    artist_id integer NOT NULL,
+    started_at timestamp with time zone NOT NULL,
+    ended_at timestamp with time zone NOT NULL,
+    plays integer NOT NULL,
+    tracks integer NOT NULL,
+    still_active boolean DEFAULT true NOT NULL,
+    detected_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: scrobbles; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.scrobbles (
+    track_play_id integer NOT NULL,
+    status text NOT NULL,
+    attempts integer DEFAULT 0 NOT NULL,
+    error text,
+    updated_at timestamp with time zone DEFAULT now() NOT NULL,
+    CONSTRAINT scrobbles_status_check CHECK ((status = ANY (ARRAY['pending'::text, 'accepted'::text, 'ignored'::text, 'failed'::text])))
+);
+
+
+--
+-- Name: track_genres; Type: VIEW; Schema: public; Owner: -
+--
+
+CREATE VIEW public.track_genres AS
+ SELECT DISTINCT at.track_id,
+    ag.genre_id
+   FROM (public.artist_tracks at
+     JOIN public.artist_genres ag ON ((ag.artist_id = at.artist_id)));
+
+
 --
 -- TOC entry 223 (class 1259 OID 16449)
 -- Name: track_plays; Type: TABLE; Schema: public; Owner: -
@@ -235,7 +432,13 @@ CREATE TABLE public.track_plays (
     played_at timestamp with time zone NOT NULL,
     skipped boolean DEFAULT false,
     created_at timestamp with time zone DEFAULT now(),
-    user_id bigint
+    user_id bigint,
+    skip_score real,
+    device_name text,
+    days_since_last_play integer,
+    rediscovery boolean DEFAULT false NOT NULL,
+    binge_session_id uuid,
+    CONSTRAINT track_plays_skip_score_check CHECK (((skip_score >= (0)::double precision) AND (skip_score <= (1)::double precision)))
 );
 
 
@@ -315,6 +518,9 @@ CREATE TABLE public.tracks (
     downloaded_at timestamp with time zone,
     download_error text,
     mbid uuid,
+    isrc text,
+    is_local boolean DEFAULT false NOT NULL,
+    raw_json jsonb,
     CONSTRAINT tracks_download_status_check CHECK ((download_status = ANY (ARRAY['none'::text, 'pending'::text, 'queued'::text, 'downloading'::text, 'done'::text, 'error'::text])))
 );
 
@@ -495,6 +701,46 @@ ALTER TABLE ONLY public.artists
     ADD CONSTRAINT artists_pkey PRIMARY KEY (id);
 
 
+--
+-- Name: binge_sessions binge_sessions_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.binge_sessions
+    ADD CONSTRAINT binge_sessions_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: daily_artist_listening daily_artist_listening_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.daily_artist_listening
+    ADD CONSTRAINT daily_artist_listening_pkey PRIMARY KEY (day, artist_id);
+
+
+--
+-- Name: daily_genre_listening daily_genre_listening_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.daily_genre_listening
+    ADD CONSTRAINT daily_genre_listening_pkey PRIMARY KEY (day, genre_id);
+
+
+--
+-- Name: daily_listening daily_listening_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.daily_listening
+    ADD CONSTRAINT daily_listening_pkey PRIMARY KEY (day);
+
+
+--
+-- Name: external_tracks external_tracks_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.external_tracks
+    ADD CONSTRAINT external_tracks_pkey PRIMARY KEY (source, external_id);
+
+
 --
 -- TOC entry 3383 (class 2606 OID 16490)
 -- Name: genres genres_name_key; Type: CONSTRAINT; Schema: public; Owner: -
@@ -513,6 +759,54 @@ ALTER TABLE ONLY public.genres
     ADD CONSTRAINT genres_pkey PRIMARY KEY (id);
 
 
+--
+-- Name: milestones milestones_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.milestones
+    ADD CONSTRAINT milestones_pkey PRIMARY KEY (kind, subject_id, count);
+
+
+--
+-- Name: monthly_discoveries monthly_discoveries_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.monthly_discoveries
+    ADD CONSTRAINT monthly_discoveries_pkey PRIMARY KEY (month);
+
+
+--
+-- Name: rollup_state rollup_state_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.rollup_state
+    ADD CONSTRAINT rollup_state_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: skip_events skip_events_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.skip_events
+    ADD CONSTRAINT skip_events_pkey PRIMARY KEY (track_play_id);
+
+
+--
+-- Name: scrobbles scrobbles_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.scrobbles
+    ADD CONSTRAINT scrobbles_pkey PRIMARY KEY (track_play_id);
+
+
+--
+-- Name: rabbit_holes rabbit_holes_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.rabbit_holes
+    ADD CONSTRAINT rabbit_holes_pkey PRIMARY KEY (id);
+
+
 --
 -- TOC entry 3406 (class 2606 OID 25097)
 -- Name: track_plays_backup track_plays_backup_pkey; Type: CONSTRAINT; Schema: public; Owner: -
@@ -608,6 +902,41 @@ CREATE INDEX idx_artist_albums_album ON public.artist_albums USING btree (album_
 CREATE INDEX idx_artist_tracks_track ON public.artist_tracks USING btree (track_id);
 
 
+--
+-- Name: idx_artists_normalized_name; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_artists_normalized_name ON public.artists USING btree (normalized_name);
+
+
+--
+-- Name: idx_track_plays_binge_session; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_track_plays_binge_session ON public.track_plays USING btree (binge_session_id);
+
+
+--
+-- Name: idx_rabbit_holes_active; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_rabbit_holes_active ON public.rabbit_holes USING btree (user_id) WHERE still_active;
+
+
+--
+-- Name: idx_tracks_normalized_title; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_tracks_normalized_title ON public.tracks USING btree (public.normalize_track_title(title));
+
+
+--
+-- Name: idx_tracks_isrc; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_tracks_isrc ON public.tracks USING btree (isrc);
+
+
 --
 -- TOC entry 3374 (class 1259 OID 24920)
 -- Name: uniq_albums_mbid; Type: INDEX; Schema: public; Owner: -
@@ -730,6 +1059,70 @@ ALTER TABLE ONLY public.track_plays
     ADD CONSTRAINT track_plays_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
 
 
+--
+-- Name: binge_sessions binge_sessions_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.binge_sessions
+    ADD CONSTRAINT binge_sessions_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
+
+
+--
+-- Name: milestones milestones_track_play_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.milestones
+    ADD CONSTRAINT milestones_track_play_id_fkey FOREIGN KEY (track_play_id) REFERENCES public.track_plays(id) ON DELETE CASCADE;
+
+
+--
+-- Name: skip_events skip_events_track_play_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.skip_events
+    ADD CONSTRAINT skip_events_track_play_id_fkey FOREIGN KEY (track_play_id) REFERENCES public.track_plays(id) ON DELETE CASCADE;
+
+
+--
+-- Name: scrobbles scrobbles_track_play_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.scrobbles
+    ADD CONSTRAINT scrobbles_track_play_id_fkey FOREIGN KEY (track_play_id) REFERENCES public.track_plays(id) ON DELETE CASCADE;
+
+
+--
+-- Name: track_plays track_plays_binge_session_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.track_plays
+    ADD CONSTRAINT track_plays_binge_session_id_fkey FOREIGN KEY (binge_session_id) REFERENCES public.binge_sessions(id) ON DELETE SET NULL;
+
+
+--
+-- Name: external_tracks external_tracks_track_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.external_tracks
+    ADD CONSTRAINT external_tracks_track_id_fkey FOREIGN KEY (track_id) REFERENCES public.tracks(id) ON DELETE SET NULL;
+
+
+--
+-- Name: rabbit_holes rabbit_holes_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.rabbit_holes
+    ADD CONSTRAINT rabbit_holes_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
+
+
+--
+-- Name: rabbit_holes rabbit_holes_artist_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.rabbit_holes
+    ADD CONSTRAINT rabbit_holes_artist_id_fkey FOREIGN KEY (artist_id) REFERENCES public.artists(id) ON DELETE CASCADE;
+
+
 -- Completed on 2026-03-22 23:18:17
 
 --
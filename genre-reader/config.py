@@ -15,5 +15,13 @@ CHANNEL = os.getenv("POSTGRES_CHANNEL", "artists_inserted")
 
 LASTFM_API_KEY = os.getenv("LASTFM_API_KEY")
 LASTFM_BASE = os.getenv("LASTFM_BASE", "http://ws.audioscrobbler.com/2.0")
+# Last.fm requests per second, shared by the workers of one process. The
+# backfill (updater.py) runs as a separate process with a budget of its own.
+LASTFM_RATE_LIMIT = float(os.getenv("LASTFM_RATE_LIMIT", 4))
+
+# Lowest level that is logged (debug, info, warning, error) and the output
+# format: one JSON object per line, or "text" for plain key=value lines.
+LOG_LEVEL = os.getenv("LOG_LEVEL", "info").upper()
+LOG_FORMAT = os.getenv("LOG_FORMAT", "json").lower()
 
 ENVIRONMENT = os.getenv("ENVIRONMENT", "dev")
\ No newline at end of file
@@ -12,7 +12,7 @@ import psycopg2
 import requests
 from listener_framework import NotificationListener
 
-from config import DB_CONFIG, CHANNEL, LASTFM_API_KEY, LASTFM_BASE
+from config import DB_CONFIG, CHANNEL, LASTFM_API_KEY, LASTFM_BASE, LASTFM_RATE_LIMIT
 from logger import log
 
 WORKER_COUNT = 1
@@ -25,10 +25,42 @@ class ArtistPayload:
     artist_id: int
     artist_name: str
 
+# Rate Limiting
+
+class RateLimiter:
+    """
+    Spaces out calls so that at most `rate` start per second, across all
+    threads sharing the limiter.
+    """
+
+    def __init__(self, rate: float):
+        self.interval = 1.0 / rate if rate > 0 else 0.0
+        self._lock = threading.Lock()
+        self._next_slot = 0.0
+
+    def wait(self):
+        """
+        Block until the caller may issue its request. A rate of 0 or less
+        disables the limit.
+        """
+        if not self.interval:
+            return
+
+        with self._lock:
+            now = time.monotonic()
+            slot = max(now, self._next_slot)
+            self._next_slot = slot + self.interval
+
+        if slot > now:
+            time.sleep(slot - now)
+
 # Genre Reader
 
 class GenreReader:
     _request_session: Optional[requests.Session] = None
+    # Shared by all instances, so the workers of this process draw from the
+    # same budget; other processes, like the backfill, have their own.
+    _rate_limiter = RateLimiter(LASTFM_RATE_LIMIT)
 
     def _get_session(self) -> Optional[requests.Session]:
         """
@@ -68,6 +100,7 @@ class GenreReader:
         if not session:
             return None
 
+        self._rate_limiter.wait()
         try:
             response = session.get(LASTFM_BASE, params=params, timeout=10)
             response.raise_for_status()
@@ -125,7 +158,8 @@ class DatabaseWriter:
             return False
         
         if genres == []:
-            return True
+            # Nothing to write, but the lookup itself worked.
+            return self._finish_task(artist)
 
         if self._write_genres_to_db(artist, genres):
             return self._finish_task(artist)
@@ -272,12 +306,15 @@ def worker_loop(worker_id: int):
                 log.info(f"[worker-{worker_id}] processing artist {artist.artist_id}")
 
                 genres = GenreReader().fetch_genres(artist.artist_name)
-                if not genres:
+                if genres is None:
+                    # Left for updater.py to retry.
                     log.info(f"[worker-{worker_id}] fetching genres failed for {artist.artist_id}")
+                    writer.mark_error(artist)
                     time.sleep(POLL_INTERVAL)
                     continue
 
-                writer.process_artist_genres(artist, genres)
+                if not writer.process_artist_genres(artist, genres):
+                    writer.mark_error(artist)
                 log.info(f"[worker-{worker_id}] finished artist {artist.artist_id}")
 
             except Exception as e:
@@ -0,0 +1,43 @@
+import os
+import sys
+from contextlib import closing
+
+import psycopg2
+import pytest
+
+# The services import their modules by plain name from their own directory;
+# the Dockerfile copies the shared listener package next to them.
+ROOT = os.path.dirname(os.path.dirname(os.path.abspath(__file__)))
+sys.path.insert(0, ROOT)
+sys.path.insert(1, os.path.join(os.path.dirname(ROOT), "listener"))
+
+SCHEMA_FILE = os.path.join(os.path.dirname(ROOT), "db_init.sql")
+
+
+@pytest.fixture
+def database_url():
+    """
+    TEST_DATABASE_URL, after recreating its public schema from db_init.sql.
+    Tests using it are skipped when it is not set.
+    """
+    url = os.getenv("TEST_DATABASE_URL")
+    if not url:
+        pytest.skip("TEST_DATABASE_URL is not set")
+
+    with open(SCHEMA_FILE, encoding="utf-8") as f:
+        # psql meta-commands such as \restrict are not SQL.
+        schema = "".join(line for line in f if not line.startswith("\\"))
+    # The dump clears the search_path for its session, so it gets its own.
+    with closing(psycopg2.connect(url)) as conn:
+        conn.autocommit = True
+        with conn.cursor() as cur:
+            cur.execute("DROP SCHEMA IF EXISTS public CASCADE; CREATE SCHEMA public;")
+            cur.execute(schema)
+    return url
+
+
+@pytest.fixture
+def db(database_url):
+    """A connection to the freshly created test database."""
+    with closing(psycopg2.connect(database_url)) as conn:
+        yield conn
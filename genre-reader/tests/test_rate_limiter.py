@@ -0,0 +1,58 @@
+import threading
+
+import pytest
+
+import listener
+from listener import RateLimiter
+
+
+@pytest.fixture
+def clock(monkeypatch):
+    """A fake monotonic clock that sleeping advances."""
+    now = [100.0]
+    monkeypatch.setattr(listener.time, "monotonic", lambda: now[0])
+    monkeypatch.setattr(listener.time, "sleep", lambda seconds: now.__setitem__(0, now[0] + seconds))
+    return now
+
+
+def test_calls_are_spaced_by_rate(clock):
+    limiter = RateLimiter(4)
+    starts = []
+    for _ in range(5):
+        limiter.wait()
+        starts.append(clock[0])
+
+    assert starts == pytest.approx([100.0, 100.25, 100.5, 100.75, 101.0])
+
+
+def test_idle_time_is_not_saved_up(clock):
+    limiter = RateLimiter(4)
+    limiter.wait()
+    clock[0] += 10
+    limiter.wait()
+    limiter.wait()
+
+    assert clock[0] == pytest.approx(110.25)
+
+
+def test_zero_rate_disables_limit(clock):
+    limiter = RateLimiter(0)
+    for _ in range(100):
+        limiter.wait()
+
+    assert clock[0] == 100.0
+
+
+def test_threads_share_the_budget(monkeypatch):
+    monkeypatch.setattr(listener.time, "monotonic", lambda: 100.0)
+    monkeypatch.setattr(listener.time, "sleep", lambda seconds: None)
+    limiter = RateLimiter(4)
+
+    threads = [threading.Thread(target=limiter.wait) for _ in range(20)]
+    for thread in threads:
+        thread.start()
+    for thread in threads:
+        thread.join()
+
+    # Every call reserved a slot of its own.
+    assert limiter._next_slot == pytest.approx(105.0)
@@ -0,0 +1,108 @@
+from unittest import mock
+
+import pytest
+
+import listener
+import updater
+from listener import ArtistPayload, DatabaseWriter, GenreReader, RateLimiter
+
+# Last.fm artist.getTopTags answers by artist; only tags above a count of 50 count.
+TOP_TAGS = {
+    "Radiohead": {"toptags": {"tag": [{"name": "alternative", "count": 100},
+                                      {"name": "rock", "count": 80},
+                                      {"name": "seen live", "count": 10}]}},
+    "Unknown Pleasures Tribute": {"toptags": {"tag": []}},
+}
+
+
+def fake_get(url, params, timeout):
+    response = mock.Mock()
+    if params["artist"] not in TOP_TAGS:
+        response.raise_for_status.side_effect = listener.requests.HTTPError("503 Server Error")
+    response.json.return_value = TOP_TAGS.get(params["artist"])
+    return response
+
+
+@pytest.fixture
+def lastfm(monkeypatch):
+    """Answer Last.fm requests from TOP_TAGS, without rate limiting."""
+    session = mock.Mock()
+    session.get.side_effect = fake_get
+    monkeypatch.setattr(GenreReader, "_request_session", session)
+    monkeypatch.setattr(GenreReader, "_rate_limiter", RateLimiter(0))
+    return session
+
+
+def seed_artists(conn, artists: dict) -> dict:
+    ids = {}
+    with conn.cursor() as cur:
+        for name, status in artists.items():
+            cur.execute("INSERT INTO artists (name, genre_status) VALUES (%s, %s) RETURNING id;", (name, status))
+            ids[name] = cur.fetchone()[0]
+    conn.commit()
+    return ids
+
+
+def artist_state(conn, artist_id: int) -> tuple[str, list[str]]:
+    with conn.cursor() as cur:
+        cur.execute("SELECT genre_status FROM artists WHERE id = %s;", (artist_id,))
+        status = cur.fetchone()[0]
+        cur.execute(
+            """
+            SELECT g.name FROM artist_genres ag JOIN genres g ON g.id = ag.genre_id
+            WHERE ag.artist_id = %s ORDER BY g.name;
+            """,
+            (artist_id,),
+        )
+        return status, [row[0] for row in cur.fetchall()]
+
+
+def test_backfill_populates_failed_artists(db, database_url, lastfm, monkeypatch):
+    monkeypatch.setattr(updater, "DB_CONFIG", {"dsn": database_url})
+    ids = seed_artists(db, {
+        "Radiohead": "error",
+        "Unknown Pleasures Tribute": "error",
+        "Offline Band": "error",
+        "Being Looked Up": "loading",
+        "Not Yet Seen": "none",
+    })
+
+    updater.backfill_genres()
+    db.rollback()
+
+    assert artist_state(db, ids["Radiohead"]) == ("done", ["alternative", "rock"])
+    # Last.fm answered without tags: nothing to retry.
+    assert artist_state(db, ids["Unknown Pleasures Tribute"]) == ("done", [])
+    assert artist_state(db, ids["Offline Band"]) == ("error", [])
+    # A worker owns these.
+    assert artist_state(db, ids["Being Looked Up"]) == ("loading", [])
+    assert artist_state(db, ids["Not Yet Seen"]) == ("none", [])
+    looked_up = [call.kwargs["params"]["artist"] for call in lastfm.get.call_args_list]
+    assert looked_up == ["Radiohead", "Unknown Pleasures Tribute", "Offline Band"]
+
+
+def test_backfill_can_include_loading_artists(db, database_url, lastfm, monkeypatch):
+    monkeypatch.setattr(updater, "DB_CONFIG", {"dsn": database_url})
+    ids = seed_artists(db, {"Radiohead": "loading"})
+
+    updater.backfill_genres(include_loading=True)
+    db.rollback()
+
+    assert artist_state(db, ids["Radiohead"]) == ("done", ["alternative", "rock"])
+
+
+def test_empty_tags_mark_artist_done():
+    conn = mock.MagicMock()
+    conn.cursor.return_value.__enter__.return_value.rowcount = 1
+    writer = DatabaseWriter(conn)
+
+    assert writer.process_artist_genres(ArtistPayload(artist_id=7, artist_name="Quiet"), [])
+
+    sql, params = conn.cursor.return_value.__enter__.return_value.execute.call_args.args
+    assert "genre_status = 'done'" in sql
+    assert params == (7,)
+
+
+def test_failed_lookup_returns_none(lastfm):
+    assert GenreReader().fetch_genres("Offline Band") is None
+    assert GenreReader().fetch_genres("Unknown Pleasures Tribute") == []
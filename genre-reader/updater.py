@@ -1,29 +1,103 @@
+"""
+Backfill genres for artists whose lookup failed.
+
+The listener only picks up artists in genre_status 'none' and marks those
+whose Last.fm lookup failed as 'error'. This script walks the 'error'
+artists in id order and retries the lookup. Artists in 'loading' are being
+looked up by a worker right now and are left alone, unless --include-loading
+is given to recover ones a stopped worker left behind. Artists that Last.fm
+simply has no tags for are 'done' and not retried.
+
+Usage: python updater.py [--after-id ID] [--include-loading]
+"""
+import argparse
+from contextlib import closing
+
 import psycopg2
-import select
-import json
-import requests
 
+from config import DB_CONFIG
+from listener import ArtistPayload, DatabaseWriter, GenreReader
+from logger import log
 
-from config import DB_CONFIG, CHANNEL, LASTFM_API_KEY
-from listener import get_artist_genres, write_genres_to_db
+BATCH_SIZE = 100
 
-def update_old_entries():
-    conn = psycopg2.connect(**DB_CONFIG)
+
+def fetch_artists_without_genres(conn, after_id: int, include_loading: bool = False) -> list[ArtistPayload]:
     with conn.cursor() as cur:
         cur.execute(
             """
-            DELETE FROM artist_genres
-            """
-        )
-        cur.execute(
-            """
-            SELECT id, name
-            FROM artists
-            """
+            SELECT
+                a.id,
+                a.name
+            FROM artists a
+            WHERE (
+                a.genre_status = 'error'
+                OR (%s AND a.genre_status = 'loading')
+            )
+            AND a.id > %s
+            AND NOT EXISTS (
+                SELECT 1
+                FROM artist_genres ag
+                WHERE ag.artist_id = a.id
+            )
+            ORDER BY a.id ASC
+            LIMIT %s;
+            """,
+            (include_loading, after_id, BATCH_SIZE),
         )
-        artists = cur.fetchall()
-    for artist_id, artist_name in artists:
-        genres = get_artist_genres(artist_name)
-        write_genres_to_db(conn, artist_id, genres)
-    conn.commit()
-    conn.close()
\ No newline at end of file
+        rows = cur.fetchall()
+
+    return [ArtistPayload(artist_id=row[0], artist_name=row[1]) for row in rows]
+
+
+def backfill_genres(after_id: int = 0, include_loading: bool = False) -> None:
+    """
+    Retry the genre lookup of artists whose lookup failed.
+
+    Progress is keyed on the artist id, so an interrupted run can be resumed
+    with --after-id set to the last id that was logged.
+
+    :param after_id: Only process artists with an id greater than this
+    :type after_id: int
+    :param include_loading: Also retry artists left in 'loading'
+    :type include_loading: bool
+    """
+    reader = GenreReader()
+    filled = untagged = skipped = 0
+
+    with closing(psycopg2.connect(**DB_CONFIG)) as conn:
+        writer = DatabaseWriter(conn)
+
+        while True:
+            artists = fetch_artists_without_genres(conn, after_id, include_loading)
+            if not artists:
+                break
+
+            for artist in artists:
+                after_id = artist.artist_id
+                genres = reader.fetch_genres(artist.artist_name)
+
+                if genres is None or not writer.process_artist_genres(artist, genres):
+                    log.info("Could not resolve genres, skipping", artist_id=artist.artist_id)
+                    writer.mark_error(artist)
+                    skipped += 1
+                elif genres:
+                    filled += 1
+                else:
+                    # Last.fm answered but has no tags for the artist.
+                    untagged += 1
+
+            log.info("Backfill progress", last_artist_id=after_id, filled=filled, untagged=untagged, skipped=skipped)
+
+    log.info("Backfill finished", filled=filled, untagged=untagged, skipped=skipped)
+
+
+if __name__ == "__main__":
+    parser = argparse.ArgumentParser(description="Retry failed artist genre lookups")
+    parser.add_argument("--after-id", type=int, default=0,
+                        help="resume after this artist id")
+    parser.add_argument("--include-loading", action="store_true",
+                        help="also retry artists stuck in 'loading'; only while no genre-reader is running")
+    args = parser.parse_args()
+
+    backfill_genres(args.after_id, args.include_loading)
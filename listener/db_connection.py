@@ -0,0 +1,38 @@
+import time
+
+import psycopg2
+
+DB_CONNECT_ATTEMPTS = 10
+DB_CONNECT_TIMEOUT = 2  # seconds
+DB_MAX_RETRY_DELAY = 30  # seconds
+
+
+def wait_for_db(params: dict, *, logger, max_attempts: int = DB_CONNECT_ATTEMPTS, base_delay: float = 1.0):
+    """
+    Connect to the database, retrying with exponential backoff.
+
+    Postgres is often still starting when the container comes up, so
+    failed attempts are retried with a doubling delay capped at
+    DB_MAX_RETRY_DELAY seconds.
+
+    :param params: Keyword arguments for psycopg2.connect
+    :type params: dict
+    :param logger: The service's structlog logger
+    :param max_attempts: Number of connection attempts before giving up
+    :type max_attempts: int
+    :param base_delay: Delay in seconds after the first failed attempt
+    :type base_delay: float
+    :return: Open database connection
+    :raises psycopg2.OperationalError: If the last attempt fails
+    """
+    delay = base_delay
+    for attempt in range(1, max_attempts + 1):
+        try:
+            return psycopg2.connect(**params, connect_timeout=DB_CONNECT_TIMEOUT)
+        except psycopg2.OperationalError as e:
+            if attempt == max_attempts:
+                logger.error("Database unavailable, giving up", attempt=attempt, error=str(e))
+                raise
+            logger.warning("Database not ready, retrying", attempt=attempt, retry_in=delay, error=str(e))
+            time.sleep(delay)
+            delay = min(delay * 2, DB_MAX_RETRY_DELAY)
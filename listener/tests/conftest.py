@@ -0,0 +1,5 @@
+import os
+import sys
+
+# The services get these modules copied next to their own.
+sys.path.insert(0, os.path.dirname(os.path.dirname(os.path.abspath(__file__))))
@@ -0,0 +1,45 @@
+from unittest import mock
+
+import psycopg2
+import pytest
+
+import db_connection
+
+
+@pytest.fixture
+def sleeps(monkeypatch):
+    sleeps = []
+    monkeypatch.setattr(db_connection.time, "sleep", sleeps.append)
+    return sleeps
+
+
+def test_retries_with_doubling_delay(monkeypatch, sleeps):
+    conn = object()
+    connect = mock.Mock(side_effect=[psycopg2.OperationalError("starting up")] * 3 + [conn])
+    monkeypatch.setattr(db_connection.psycopg2, "connect", connect)
+
+    assert db_connection.wait_for_db({"dbname": "music"}, logger=mock.Mock()) is conn
+    assert sleeps == [1.0, 2.0, 4.0]
+    connect.assert_called_with(dbname="music", connect_timeout=db_connection.DB_CONNECT_TIMEOUT)
+
+
+def test_delay_is_capped(monkeypatch, sleeps):
+    monkeypatch.setattr(db_connection.psycopg2, "connect",
+                        mock.Mock(side_effect=[psycopg2.OperationalError("down")] * 7 + [object()]))
+
+    db_connection.wait_for_db({}, logger=mock.Mock(), base_delay=4)
+
+    assert sleeps == [4, 8, 16, 30, 30, 30, 30]
+
+
+def test_gives_up_after_last_attempt(monkeypatch, sleeps):
+    connect = mock.Mock(side_effect=psycopg2.OperationalError("down"))
+    monkeypatch.setattr(db_connection.psycopg2, "connect", connect)
+    logger = mock.Mock()
+
+    with pytest.raises(psycopg2.OperationalError):
+        db_connection.wait_for_db({}, logger=logger, max_attempts=3)
+
+    assert connect.call_count == 3
+    assert len(sleeps) == 2
+    logger.error.assert_called_once()
@@ -20,4 +20,9 @@ MATRIX_ROOM_ID = os.getenv("MATRIX_ROOM_ID", "!yourroomid:matrix.org")
 
 TOKEN_FILE = os.getenv("MATRIX_TOKEN_FILE", "/app/matrix_session/matrix_session.json")
 
+# Lowest level that is logged (debug, info, warning, error) and the output
+# format: one JSON object per line, or "text" for plain key=value lines.
+LOG_LEVEL = os.getenv("LOG_LEVEL", "info").upper()
+LOG_FORMAT = os.getenv("LOG_FORMAT", "json").lower()
+
 ENVIRONMENT = os.getenv("ENVIRONMENT", "dev")
\ No newline at end of file
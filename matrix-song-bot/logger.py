@@ -4,19 +4,21 @@ Logger setup for matrix-song-bot.
 import sys
 import logging
 import structlog
-from config import ENVIRONMENT
+from config import ENVIRONMENT, LOG_FORMAT, LOG_LEVEL
 
 logging.basicConfig(
     format="%(message)s",
     stream=sys.stdout,
-    level=logging.DEBUG,
+    level=LOG_LEVEL,
 )
 
 structlog.configure(
     processors=[
+        structlog.stdlib.filter_by_level,
         structlog.processors.TimeStamper(fmt="iso", key="ts"),
         structlog.processors.add_log_level,
-        structlog.processors.JSONRenderer(),
+        structlog.dev.ConsoleRenderer(colors=False) if LOG_FORMAT == "text"
+        else structlog.processors.JSONRenderer(),
     ],
     logger_factory=structlog.stdlib.LoggerFactory(),
 )
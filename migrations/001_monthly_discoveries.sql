@@ -0,0 +1,11 @@
+-- Per-month counts of artists, tracks and genres played for the first time.
+-- Filled by the tracker at the start of each month.
+
+CREATE TABLE IF NOT EXISTS public.monthly_discoveries (
+    month date NOT NULL,
+    new_artists integer DEFAULT 0 NOT NULL,
+    new_tracks integer DEFAULT 0 NOT NULL,
+    new_genres integer DEFAULT 0 NOT NULL,
+    computed_at timestamp with time zone DEFAULT now() NOT NULL,
+    CONSTRAINT monthly_discoveries_pkey PRIMARY KEY (month)
+);
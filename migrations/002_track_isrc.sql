@@ -0,0 +1,6 @@
+-- ISRC of the recording, used to match tracks across sources.
+-- Filled by the music-librarian for albums added after this migration.
+
+ALTER TABLE public.tracks ADD COLUMN IF NOT EXISTS isrc text;
+
+CREATE INDEX IF NOT EXISTS idx_tracks_isrc ON public.tracks USING btree (isrc);
@@ -0,0 +1,15 @@
+-- Share of the track that was not played: 0 = played fully, 1 = skipped
+-- right away. NULL for plays recorded before this column existed.
+
+ALTER TABLE public.track_plays ADD COLUMN IF NOT EXISTS skip_score real;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM pg_constraint WHERE conname = 'track_plays_skip_score_check'
+    ) THEN
+        ALTER TABLE public.track_plays
+            ADD CONSTRAINT track_plays_skip_score_check CHECK (skip_score >= 0 AND skip_score <= 1);
+    END IF;
+END
+$$;
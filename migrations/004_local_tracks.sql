@@ -0,0 +1,4 @@
+-- Tracks the tracker created itself for Navidrome entries without a
+-- MusicBrainz id (untagged local files). Their mbid is a synthetic UUID.
+
+ALTER TABLE public.tracks ADD COLUMN IF NOT EXISTS is_local boolean DEFAULT false NOT NULL;
@@ -0,0 +1,16 @@
+-- Play count milestones detected by the tracker. Run
+-- `python milestones.py recompute` in the tracker container afterwards to
+-- fill in milestones reached before this table existed.
+
+CREATE TABLE IF NOT EXISTS public.milestones (
+    kind text NOT NULL,
+    subject_id integer DEFAULT 0 NOT NULL,
+    subject text NOT NULL,
+    count integer NOT NULL,
+    played_at timestamp with time zone NOT NULL,
+    track_play_id integer NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    CONSTRAINT milestones_kind_check CHECK (kind = ANY (ARRAY['total_plays', 'artist_plays', 'track_plays', 'new_artist'])),
+    CONSTRAINT milestones_pkey PRIMARY KEY (kind, subject_id, count),
+    CONSTRAINT milestones_track_play_id_fkey FOREIGN KEY (track_play_id) REFERENCES public.track_plays(id) ON DELETE CASCADE
+);
@@ -0,0 +1,4 @@
+-- Navidrome player the track was played on (playerName of the now playing
+-- entry). NULL for plays recorded before this column existed.
+
+ALTER TABLE public.track_plays ADD COLUMN IF NOT EXISTS device_name text;
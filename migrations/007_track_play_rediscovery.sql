@@ -0,0 +1,6 @@
+-- Days since the same user last played the track, and whether that gap
+-- reached REDISCOVERY_DAYS. Plays recorded before these columns existed
+-- have no gap and are not marked as rediscoveries.
+
+ALTER TABLE public.track_plays ADD COLUMN IF NOT EXISTS days_since_last_play integer;
+ALTER TABLE public.track_plays ADD COLUMN IF NOT EXISTS rediscovery boolean DEFAULT false NOT NULL;
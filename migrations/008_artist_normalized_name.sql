@@ -0,0 +1,13 @@
+-- Case, whitespace and leading "the" insensitive artist name, so variants
+-- like "Beatles" and "The  Beatles" are counted as one artist in stats.
+
+CREATE OR REPLACE FUNCTION public.normalize_artist_name(name text) RETURNS text
+    LANGUAGE sql IMMUTABLE
+    AS $$
+    SELECT regexp_replace(lower(btrim(regexp_replace(name, '\s+', ' ', 'g'))), '^the ', '');
+$$;
+
+ALTER TABLE public.artists ADD COLUMN IF NOT EXISTS normalized_name text
+    GENERATED ALWAYS AS (public.normalize_artist_name(name)) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_artists_normalized_name ON public.artists USING btree (normalized_name);
@@ -0,0 +1,37 @@
+-- Per-day totals kept by the tracker so long-range stats do not have to scan
+-- every play. rollup_state holds the last local day that was rolled up; the
+-- stats-api reads later days from track_plays. Fill them with
+-- `docker-compose exec tracker python rollups.py refresh`.
+
+CREATE TABLE IF NOT EXISTS public.daily_listening (
+    day date NOT NULL,
+    plays integer NOT NULL,
+    skips integer NOT NULL,
+    duration_ms bigint NOT NULL,
+    CONSTRAINT daily_listening_pkey PRIMARY KEY (day)
+);
+
+CREATE TABLE IF NOT EXISTS public.daily_artist_listening (
+    day date NOT NULL,
+    artist_id integer NOT NULL,
+    plays integer NOT NULL,
+    duration_ms bigint NOT NULL,
+    CONSTRAINT daily_artist_listening_pkey PRIMARY KEY (day, artist_id)
+);
+
+CREATE TABLE IF NOT EXISTS public.daily_genre_listening (
+    day date NOT NULL,
+    genre_id integer NOT NULL,
+    plays integer NOT NULL,
+    weighted_plays double precision NOT NULL,
+    weighted_duration_ms double precision NOT NULL,
+    CONSTRAINT daily_genre_listening_pkey PRIMARY KEY (day, genre_id)
+);
+
+CREATE TABLE IF NOT EXISTS public.rollup_state (
+    id boolean DEFAULT true NOT NULL,
+    refreshed_through date NOT NULL,
+    refreshed_at timestamp with time zone DEFAULT now() NOT NULL,
+    CONSTRAINT rollup_state_pkey PRIMARY KEY (id),
+    CONSTRAINT rollup_state_id_check CHECK (id)
+);
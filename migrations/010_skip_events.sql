@@ -0,0 +1,18 @@
+-- Inputs of the tracker's skip decision for every play, to check the skip
+-- threshold against real listening. Plays recorded before this table existed
+-- have no event.
+
+CREATE TABLE IF NOT EXISTS public.skip_events (
+    track_play_id integer NOT NULL,
+    rule text NOT NULL,
+    expected_ms integer,
+    played_ms integer NOT NULL,
+    ratio real,
+    threshold real NOT NULL,
+    min_skip_ms integer NOT NULL,
+    skipped boolean NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    CONSTRAINT skip_events_rule_check CHECK (rule = ANY (ARRAY['ratio', 'remaining_ms', 'unknown_duration'])),
+    CONSTRAINT skip_events_pkey PRIMARY KEY (track_play_id),
+    CONSTRAINT skip_events_track_play_id_fkey FOREIGN KEY (track_play_id) REFERENCES public.track_plays(id) ON DELETE CASCADE
+);
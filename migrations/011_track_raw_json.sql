@@ -0,0 +1,5 @@
+-- The first Navidrome now-playing entry seen for each track, kept so fields
+-- the tracker does not parse yet can be backfilled without Navidrome. Tracks
+-- played before this column existed get it on their next play.
+
+ALTER TABLE public.tracks ADD COLUMN IF NOT EXISTS raw_json jsonb;
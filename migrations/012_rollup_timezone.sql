@@ -0,0 +1,6 @@
+-- Records the time zone the daily rollups were bucketed in. The stats-api
+-- only uses rollups that match its USER_TIMEZONE, and the tracker recomputes
+-- all of them when its USER_TIMEZONE changes. Rollups made before this
+-- migration have no zone and are recomputed on the tracker's next refresh.
+
+ALTER TABLE public.rollup_state ADD COLUMN IF NOT EXISTS tz text;
@@ -0,0 +1,19 @@
+-- Stretches of intense listening: BINGE_MIN_TRACKS unskipped plays of one
+-- user within BINGE_WINDOW_MINUTES, chained while the plays keep coming
+-- that fast. The tracker detects them as plays are stored; plays recorded
+-- before this migration are not assigned to any session.
+
+CREATE TABLE IF NOT EXISTS public.binge_sessions (
+    id uuid DEFAULT gen_random_uuid() NOT NULL,
+    user_id bigint NOT NULL,
+    started_at timestamp with time zone NOT NULL,
+    ended_at timestamp with time zone NOT NULL,
+    detected_at timestamp with time zone DEFAULT now() NOT NULL,
+    CONSTRAINT binge_sessions_pkey PRIMARY KEY (id),
+    CONSTRAINT binge_sessions_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE
+);
+
+ALTER TABLE public.track_plays ADD COLUMN IF NOT EXISTS binge_session_id uuid
+    REFERENCES public.binge_sessions(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_track_plays_binge_session ON public.track_plays USING btree (binge_session_id);
@@ -0,0 +1,9 @@
+-- One row per track and genre of any of its artists. Genre statistics join
+-- plays to this view instead of repeating the artist_tracks/artist_genres
+-- join, so a track whose artists share a genre still counts it once.
+
+CREATE OR REPLACE VIEW public.track_genres AS
+ SELECT DISTINCT at.track_id,
+    ag.genre_id
+   FROM (public.artist_tracks at
+     JOIN public.artist_genres ag ON ((ag.artist_id = at.artist_id)));
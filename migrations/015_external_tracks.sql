@@ -0,0 +1,31 @@
+-- Play counts from other players' library exports (so far Apple Music, see
+-- tracker/import_apple_music.py). track_id links an entry to the matching
+-- local track, found by ISRC or by normalized title and artist; unmatched
+-- entries keep it NULL. Re-importing a newer export updates the counts.
+
+CREATE OR REPLACE FUNCTION public.normalize_track_title(title text) RETURNS text
+    LANGUAGE sql IMMUTABLE
+    AS $$
+    SELECT lower(btrim(regexp_replace(
+        regexp_replace(regexp_replace(title, '\s*[\(\[][^\)\]]*[\)\]]', '', 'g'), '\s+-\s+.*$', ''),
+        '\s+', ' ', 'g')));
+$$;
+
+CREATE INDEX IF NOT EXISTS idx_tracks_normalized_title ON public.tracks USING btree (public.normalize_track_title(title));
+
+CREATE TABLE IF NOT EXISTS public.external_tracks (
+    source text NOT NULL,
+    external_id text NOT NULL,
+    title text NOT NULL,
+    artist text,
+    album text,
+    duration_ms integer,
+    isrc text,
+    play_count integer NOT NULL,
+    last_played_at timestamp with time zone,
+    track_id integer,
+    imported_at timestamp with time zone DEFAULT now() NOT NULL,
+    CONSTRAINT external_tracks_source_check CHECK ((source = 'apple_music'::text)),
+    CONSTRAINT external_tracks_pkey PRIMARY KEY (source, external_id),
+    CONSTRAINT external_tracks_track_id_fkey FOREIGN KEY (track_id) REFERENCES public.tracks(id) ON DELETE SET NULL
+);
@@ -0,0 +1,16 @@
+-- Last.fm submission state of each play the tracker scrobbled or tried to
+-- (see tracker/scrobbler.py). A play is marked pending before it is sent, so
+-- a crash mid-request leaves it pending instead of submitting it twice.
+-- Failed submissions are retried until attempts reaches the limit; accepted
+-- and ignored ones are final.
+
+CREATE TABLE IF NOT EXISTS public.scrobbles (
+    track_play_id integer NOT NULL,
+    status text NOT NULL,
+    attempts integer DEFAULT 0 NOT NULL,
+    error text,
+    updated_at timestamp with time zone DEFAULT now() NOT NULL,
+    CONSTRAINT scrobbles_status_check CHECK (status = ANY (ARRAY['pending', 'accepted', 'ignored', 'failed'])),
+    CONSTRAINT scrobbles_pkey PRIMARY KEY (track_play_id),
+    CONSTRAINT scrobbles_track_play_id_fkey FOREIGN KEY (track_play_id) REFERENCES public.track_plays(id) ON DELETE CASCADE
+);
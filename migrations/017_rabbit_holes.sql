@@ -0,0 +1,23 @@
+-- Stretches of listening to one artist: RABBIT_HOLE_MIN_PLAYS unskipped
+-- plays of one user covering two or more of the artist's tracks, with at
+-- most RABBIT_HOLE_MAX_DETOUR plays of other artists between two of them.
+-- The tracker detects them as plays are stored and keeps still_active set
+-- while the run can go on, so later plays extend the same row. Plays
+-- recorded before this migration are not looked at.
+
+CREATE TABLE IF NOT EXISTS public.rabbit_holes (
+    id uuid DEFAULT gen_random_uuid() NOT NULL,
+    user_id bigint NOT NULL,
+    artist_id integer NOT NULL,
+    started_at timestamp with time zone NOT NULL,
+    ended_at timestamp with time zone NOT NULL,
+    plays integer NOT NULL,
+    tracks integer NOT NULL,
+    still_active boolean DEFAULT true NOT NULL,
+    detected_at timestamp with time zone DEFAULT now() NOT NULL,
+    CONSTRAINT rabbit_holes_pkey PRIMARY KEY (id),
+    CONSTRAINT rabbit_holes_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE,
+    CONSTRAINT rabbit_holes_artist_id_fkey FOREIGN KEY (artist_id) REFERENCES public.artists(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_rabbit_holes_active ON public.rabbit_holes USING btree (user_id) WHERE still_active;
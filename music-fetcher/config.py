@@ -11,4 +11,9 @@ DB_CONFIG = {
     "password": os.getenv("POSTGRES_PASSWORD", "password"),
 }
 
+# Lowest level that is logged (debug, info, warning, error) and the output
+# format: one JSON object per line, or "text" for plain key=value lines.
+LOG_LEVEL = os.getenv("LOG_LEVEL", "info").upper()
+LOG_FORMAT = os.getenv("LOG_FORMAT", "json").lower()
+
 ENVIRONMENT = os.getenv("ENVIRONMENT", "dev")
\ No newline at end of file
@@ -9,6 +9,7 @@ from dataclasses import dataclass
 import psycopg2
 from psycopg2.extras import RealDictCursor
 
+from db_connection import wait_for_db
 from logger import log
 from config import DB_CONFIG
 from sql_queries import INSERT_SQL, DELETE_SQL
@@ -26,7 +27,10 @@ class Track:
     title: str
     duration: int
     album_mbid: Optional[str] = None
-    track_mbid: Optional[str] = None    
+    track_mbid: Optional[str] = None
+    isrc: Optional[str] = None
+    track_number: int = 0
+    disc_number: int = 1
 
 
 class DatabaseWriter:
@@ -43,7 +47,10 @@ class DatabaseWriter:
                     "track_title": track.title,
                     "duration_ms": track.duration,
                     "album_mbid": track.album_mbid,
-                    "track_mbid": track.track_mbid
+                    "track_mbid": track.track_mbid,
+                    "isrc": track.isrc,
+                    "track_number": track.track_number,
+                    "disc_number": track.disc_number
                 })
             self.conn.commit()
             log.debug("Inserted track", track_title=track.title)
@@ -153,7 +160,7 @@ class MusicBrainzClient:
 
     def fetch_release(self, mbid: str) -> List[Track]:
         data = self._get(f"release/{mbid}", {
-            "inc": "recordings+artists"
+            "inc": "recordings+artists+isrcs"
         })
 
         tracks = []
@@ -174,7 +181,12 @@ class MusicBrainzClient:
                         title=recording["title"],
                         duration=recording.get("length"),
                         album_mbid=mbid,
-                        track_mbid=recording.get("id")
+                        track_mbid=recording.get("id"),
+                        # A recording can carry several ISRCs; the first is
+                        # enough to match it against other sources.
+                        isrc=next(iter(recording.get("isrcs") or []), None),
+                        track_number=t.get("position") or 0,
+                        disc_number=medium.get("position") or 1
                     )
                 )
 
@@ -223,10 +235,7 @@ def remove_album():
 
 
 def create_app():
-    try:
-        conn = psycopg2.connect(**DB_CONFIG) 
-    except psycopg2.OperationalError as e:
-        log.warning("Database connection error, will retry", error=str(e), exc_info=True)
+    conn = wait_for_db(DB_CONFIG, logger=log)
 
     app.db_writer = DatabaseWriter(conn)
 
@@ -21,19 +21,22 @@ track AS (
         title,
         duration_ms,
         download_status,
-        mbid
+        mbid,
+        isrc
     )
     VALUES (
         %(track_title)s,
         %(duration_ms)s,
         'pending',
-        %(track_mbid)s
+        %(track_mbid)s,
+        %(isrc)s
     )
     ON CONFLICT (mbid)
     DO UPDATE SET
         title = EXCLUDED.title,
         duration_ms = EXCLUDED.duration_ms,
-        mbid = EXCLUDED.mbid
+        mbid = EXCLUDED.mbid,
+        isrc = COALESCE(EXCLUDED.isrc, tracks.isrc)
     RETURNING id
 ),
 
@@ -52,10 +55,12 @@ artist_album_links AS (
 ),
 
 album_track_link AS (
-    INSERT INTO album_tracks (album_id, track_id)
-    SELECT album.id, track.id
+    INSERT INTO album_tracks (album_id, track_id, track_number, disc_number)
+    SELECT album.id, track.id, %(track_number)s, %(disc_number)s
     FROM album, track
-    ON CONFLICT DO NOTHING
+    ON CONFLICT (album_id, track_id) DO UPDATE
+        SET track_number = EXCLUDED.track_number,
+            disc_number = EXCLUDED.disc_number
 )
 SELECT 1;
 """
@@ -0,0 +1,2053 @@
+"""
+Stats API serving aggregated listening statistics
+from the track_plays history.
+"""
+import calendar
+import functools
+import hmac
+import math
+import re
+import time
+import threading
+from collections import defaultdict, deque
+from concurrent.futures import ThreadPoolExecutor
+from datetime import date, datetime, timedelta, timezone
+from typing import Optional
+
+import psycopg2
+from psycopg2.extras import RealDictCursor
+from psycopg2.pool import ThreadedConnectionPool
+from flask import Flask, has_request_context, request, jsonify, make_response
+from flask_cors import CORS
+
+from db_connection import DB_CONNECT_TIMEOUT, wait_for_db
+from logger import log
+from config import (
+    DB_CONFIG,
+    STATS_DATABASE_URL,
+    DATABASE_URL_READONLY,
+    USER_TIMEZONE,
+    SESSION_GAP_MINUTES,
+    MIN_PLAY_MS,
+    QUERY_RATE_LIMIT,
+    QUERY_TIMEOUT_MS,
+    QUERY_MAX_ROWS,
+    DASHBOARD_WORKERS,
+    CORS_ALLOWED_ORIGINS,
+    DIVERSITY_MIN_PLAYS,
+    STATS_API_KEY,
+    SHUFFLE_MIN_ALBUM_PAIRS,
+    SHUFFLE_THRESHOLD,
+    RESPONSE_CACHE_TTL,
+    RESPONSE_CACHE_MAX_ENTRIES,
+)
+from formats import FORMATS, CONTENT_TYPES, render
+from openapi import PUBLIC_PATHS, document as openapi_document, undocumented
+from reports import render_wrapped_text, render_wrapped_html, render_diversity_table
+from sql_queries import (
+    BY_WEEKDAY_SQL,
+    BY_HOUR_SQL,
+    LOCAL_TODAY_SQL,
+    STREAKS_SQL,
+    ORDERED_PLAYS_SQL,
+    ORDERED_ARTIST_PLAYS_SQL,
+    SKIPS_BY_TRACK_SQL,
+    SKIPS_BY_ARTIST_SQL,
+    BINGE_DAYS_BY_TRACK_SQL,
+    BINGE_DAYS_BY_ARTIST_SQL,
+    BINGE_SESSIONS_SQL,
+    RECORDED_RABBIT_HOLES_SQL,
+    FAVORITE_TRACKS_SQL,
+    FAVORITE_ARTISTS_SQL,
+    FORGOTTEN_TRACKS_SQL,
+    FORGOTTEN_ARTISTS_SQL,
+    HEATMAP_SQL,
+    DISCOVERIES_SQL,
+    MONTHLY_DISCOVERIES_SQL,
+    LISTENING_TOTALS_SQL,
+    TOP_TRACKS_SQL,
+    TOP_ARTISTS_SQL,
+    TOP_GENRES_SQL,
+    GENRE_MINUTES_SQL,
+    ARTIST_MINUTES_SQL,
+    MOST_SKIPPED_TRACKS_SQL,
+    MOST_SKIPPED_ARTISTS_SQL,
+    DAILY_SUMMARY_SQL,
+    BUSIEST_DAY_SQL,
+    LONGEST_SESSION_SQL,
+    SESSION_STATS_SQL,
+    FIRST_PLAY_DAY_SQL,
+    ON_THIS_DAY_SQL,
+    ON_THIS_DAY_TRACKS_SQL,
+    SESSION_ALBUM_ORDER_SQL,
+    ARTIST_LEADERBOARD_SQL,
+    DEVICES_SQL,
+    REDISCOVERIES_SQL,
+    HISTORY_SQL,
+    TOP_ALBUMS_SQL,
+    ARTISTS_BY_NAME_SQL,
+    ARTIST_BY_ID_SQL,
+    ARTIST_SUMMARY_SQL,
+    ARTIST_TIMELINE_SQL,
+    ARTIST_TOP_TRACKS_SQL,
+    ARTIST_TRACKS_SQL,
+    CROSS_PLATFORM_SQL,
+    COMPLETION_SQL,
+    COMPLETION_HISTOGRAM_SQL,
+)
+
+DURATION_UNITS = {"d": 1, "w": 7, "y": 365}
+
+GRANULARITIES = ("week", "month", "year")
+
+HISTORY_PAGE_SIZE = 50
+HISTORY_MAX_PAGE_SIZE = 500
+
+WEEKDAYS = ["Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"]
+HEATMAP_SHADES = " ░▒▓█"
+
+
+class InvalidParameter(ValueError):
+    pass
+
+
+class RateLimiter:
+    """
+    Sliding-window limiter allowing `limit` calls per `window` seconds and key.
+
+    State is kept per process, so with several gunicorn workers the
+    effective limit is a multiple of `limit`.
+    """
+
+    def __init__(self, limit: int, window: float = 60.0):
+        self.limit = limit
+        self.window = window
+        self._calls = defaultdict(deque)
+        self._lock = threading.Lock()
+
+    def allow(self, key: str) -> bool:
+        now = time.monotonic()
+        with self._lock:
+            calls = self._calls[key]
+            while calls and calls[0] <= now - self.window:
+                calls.popleft()
+            if len(calls) >= self.limit:
+                return False
+            calls.append(now)
+            return True
+
+
+class TTLCache:
+    """
+    In-memory cache whose entries expire `ttl` seconds after they were stored.
+
+    Once `max_entries` is reached the oldest entry is dropped. Like the
+    rate limiter, each gunicorn worker has its own cache.
+    """
+
+    def __init__(self, ttl: float, max_entries: int):
+        self.ttl = ttl
+        self.max_entries = max_entries
+        self._entries = {}
+        self._lock = threading.Lock()
+
+    def get(self, key):
+        now = time.monotonic()
+        with self._lock:
+            entry = self._entries.get(key)
+            if entry is None or entry[0] <= now:
+                self._entries.pop(key, None)
+                return None
+            return entry[1]
+
+    def set(self, key, value):
+        now = time.monotonic()
+        with self._lock:
+            self._entries.pop(key, None)
+            for stale in [k for k, (expires, _) in self._entries.items() if expires <= now]:
+                del self._entries[stale]
+            while len(self._entries) >= self.max_entries:
+                del self._entries[next(iter(self._entries))]
+            self._entries[key] = (now + self.ttl, value)
+
+
+class DatabaseReader:
+
+    def __init__(self, conn, min_play_ms: Optional[int] = None):
+        self.conn = conn
+        # None: MIN_PLAY_MS, or ?min_play_ms= when reading for a request.
+        self.min_play_ms = min_play_ms
+
+    def _fetch_all(self, sql: str, params: dict) -> list[dict]:
+        with self.conn.cursor(cursor_factory=RealDictCursor) as cur:
+            cur.execute(sql, params)
+            return cur.fetchall()
+
+    def _fetch_one(self, sql: str, params: dict) -> Optional[dict]:
+        rows = self._fetch_all(sql, params)
+        return rows[0] if rows else None
+
+    def _params(self, **params) -> dict:
+        return {
+            "tz": USER_TIMEZONE,
+            "min_play_ms": current_min_play_ms() if self.min_play_ms is None else self.min_play_ms,
+            **params,
+        }
+
+    def _window(self, date_from: Optional[date], date_to: Optional[date], **params) -> dict:
+        return self._params(date_from=date_from, date_to=date_to, **params)
+
+    def plays_by_weekday(self, date_from: Optional[date], date_to: Optional[date]) -> list[dict]:
+        """
+        Count plays and minutes per local day-of-week.
+
+        :param date_from: First local day of the window (inclusive) or None
+        :param date_to: Last local day of the window (inclusive) or None
+        :return: Seven rows, Monday first, with weekday, plays and minutes
+        :rtype: list[dict]
+        """
+        return self._fetch_all(BY_WEEKDAY_SQL, self._window(date_from, date_to))
+
+    def plays_by_hour(self, date_from: Optional[date], date_to: Optional[date]) -> list[dict]:
+        """
+        Count plays and minutes per local hour of the day.
+
+        :return: 24 rows, midnight first, with hour, plays and minutes
+        :rtype: list[dict]
+        """
+        return self._fetch_all(BY_HOUR_SQL, self._window(date_from, date_to))
+
+    def local_today(self) -> date:
+        return self._fetch_all(LOCAL_TODAY_SQL, {"tz": USER_TIMEZONE})[0]["today"]
+
+    def listening_streaks(self, date_from: Optional[date], date_to: Optional[date],
+                          count_skipped: bool) -> list[dict]:
+        """
+        Find runs of consecutive local days with at least one play.
+
+        :param count_skipped: Whether days with only skipped plays count as active
+        :type count_skipped: bool
+        :return: Streaks ordered by start day with start_day, end_day and length
+        :rtype: list[dict]
+        """
+        return self._fetch_all(STREAKS_SQL, self._window(date_from, date_to, count_skipped=count_skipped))
+
+    def ordered_plays(self, date_from: Optional[date], date_to: Optional[date]) -> list[dict]:
+        """
+        Fetch all plays in the window ordered by user and play time.
+
+        :return: Plays with track, title, duration and artist
+        :rtype: list[dict]
+        """
+        return self._fetch_all(ORDERED_PLAYS_SQL, self._window(date_from, date_to))
+
+    def skip_rates(self, by: str, since: timedelta, min_plays: int, limit: int,
+                   weighted: bool = False) -> list[dict]:
+        """
+        Rank tracks or artists by skip rate.
+
+        :param by: Either "track" or "artist"
+        :type by: str
+        :param since: How far back to look
+        :param min_plays: Minimum evaluated plays for an item to be listed
+        :param limit: Maximum number of rows
+        :param weighted: Rank by average skip_score instead of skip rate
+        :return: Rows with plays, skips, skip_rate, skip_score_sum and
+            avg_skip_score, highest first
+        :rtype: list[dict]
+        """
+        sql = SKIPS_BY_ARTIST_SQL if by == "artist" else SKIPS_BY_TRACK_SQL
+        return self._fetch_all(sql, self._params(
+            since=since,
+            min_plays=min_plays,
+            limit=limit,
+            weighted=weighted,
+        ))
+
+    def binge_days(self, by: str, since: timedelta, min_repeats: int, limit: int) -> list[dict]:
+        """
+        Find local days on which one track or artist was played over and over.
+
+        :param by: Either "track" or "artist"
+        :type by: str
+        :param since: How far back to look
+        :param min_repeats: Minimum plays of the same item on one day
+        :param limit: Maximum number of rows
+        :return: Rows with day, plays and minutes, most plays first
+        :rtype: list[dict]
+        """
+        sql = BINGE_DAYS_BY_ARTIST_SQL if by == "artist" else BINGE_DAYS_BY_TRACK_SQL
+        return self._fetch_all(sql, self._params(since=since, min_repeats=min_repeats, limit=limit))
+
+    def ordered_artist_plays(self, date_from: Optional[date], date_to: Optional[date]) -> list[dict]:
+        """
+        Fetch the unskipped plays in the window ordered by user and play
+        time, each with the ids and names of its artists.
+        """
+        return self._fetch_all(ORDERED_ARTIST_PLAYS_SQL, self._window(date_from, date_to))
+
+    def binge_sessions(self, limit: int) -> list[dict]:
+        """
+        The most recent binge sessions detected by the tracker, newest
+        first, each with its plays in order.
+        """
+        return self._fetch_all(BINGE_SESSIONS_SQL, {"limit": limit})
+
+    def recorded_rabbit_holes(self, active_only: bool, limit: int) -> list[dict]:
+        """
+        The most recent rabbit holes recorded by the tracker, most recent
+        first, optionally only the ones still going on.
+        """
+        return self._fetch_all(RECORDED_RABBIT_HOLES_SQL, {
+            "active_only": active_only,
+            "limit": limit,
+            "session_gap": timedelta(minutes=SESSION_GAP_MINUTES),
+        })
+
+    def favorites(self, by: str, half_life: timedelta, limit: int) -> list[dict]:
+        """
+        Rank tracks or artists by plays weighted with exponential decay.
+
+        :param by: Either "track" or "artist"
+        :type by: str
+        :param half_life: Age at which a play counts half
+        :param limit: Maximum number of rows
+        :return: Rows with score and total plays, highest score first
+        :rtype: list[dict]
+        """
+        sql = FAVORITE_ARTISTS_SQL if by == "artist" else FAVORITE_TRACKS_SQL
+        return self._fetch_all(sql, self._params(half_life=half_life, limit=limit))
+
+    def forgotten(self, by: str, min_plays: int, quiet_for: timedelta, limit: int,
+                  exclude_active_artists: bool = False, active_plays: int = 10) -> list[dict]:
+        """
+        Find tracks or artists that were played a lot but not within quiet_for.
+
+        :param by: Either "track" or "artist"
+        :type by: str
+        :param min_plays: Minimum plays over the whole history
+        :param quiet_for: How long the item must not have been played
+        :param limit: Maximum number of rows
+        :param exclude_active_artists: Leave out tracks by artists with at
+            least active_plays plays within quiet_for (tracks only)
+        :return: Rows with plays and last_played_at, most played first
+        :rtype: list[dict]
+        """
+        sql = FORGOTTEN_ARTISTS_SQL if by == "artist" else FORGOTTEN_TRACKS_SQL
+        return self._fetch_all(sql, self._params(
+            min_plays=min_plays,
+            quiet_for=quiet_for,
+            limit=limit,
+            exclude_active_artists=exclude_active_artists,
+            active_plays=active_plays,
+        ))
+
+    def listening_heatmap(self, since: timedelta) -> list[list[float]]:
+        """
+        Sum listening minutes per local weekday and hour.
+
+        :param since: How far back to look
+        :return: 7x24 matrix of minutes, Monday first
+        :rtype: list[list[float]]
+        """
+        matrix = [[0.0] * 24 for _ in WEEKDAYS]
+        rows = self._fetch_all(HEATMAP_SQL, self._params(since=since))
+        for row in rows:
+            matrix[row["weekday"] - 1][row["hour"]] = round(row["minutes"], 1)
+        return matrix
+
+    def discoveries(self, granularity: str, date_from: Optional[date],
+                    date_to: Optional[date]) -> list[dict]:
+        """
+        Count artists and tracks played for the first time ever, per bucket.
+
+        :param granularity: One of GRANULARITIES
+        :type granularity: str
+        :return: Buckets with new_artists, new_tracks, top_new_artist and artist_names
+        :rtype: list[dict]
+        """
+        return self._fetch_all(DISCOVERIES_SQL, self._window(date_from, date_to, granularity=granularity))
+
+    def monthly_discoveries(self, months: int) -> list[dict]:
+        """
+        Read the per-month discovery counts stored by the tracker.
+
+        :param months: Number of most recent months to return
+        :type months: int
+        :return: Months in ascending order with new_artists, new_tracks and new_genres
+        :rtype: list[dict]
+        """
+        return self._fetch_all(MONTHLY_DISCOVERIES_SQL, {"months": months})
+
+    def listening_totals(self, date_from: Optional[date], date_to: Optional[date]) -> dict:
+        """
+        Total plays, skips, minutes, unique tracks and unique artists in a window.
+
+        Minutes only count plays that were not skipped.
+        """
+        return self._fetch_one(LISTENING_TOTALS_SQL, self._window(date_from, date_to))
+
+    def top_tracks(self, date_from: Optional[date], date_to: Optional[date], limit: Optional[int]) -> list[dict]:
+        return self._fetch_all(TOP_TRACKS_SQL, self._window(date_from, date_to, limit=limit))
+
+    def top_artists(self, date_from: Optional[date], date_to: Optional[date], limit: int) -> list[dict]:
+        return self._fetch_all(TOP_ARTISTS_SQL, self._window(date_from, date_to, limit=limit))
+
+    def top_genres(self, date_from: Optional[date], date_to: Optional[date], limit: int) -> list[dict]:
+        return self._fetch_all(TOP_GENRES_SQL, self._window(date_from, date_to, limit=limit))
+
+    def genre_minutes(self, granularity: str, date_from: Optional[date],
+                      date_to: Optional[date]) -> list[dict]:
+        """
+        Listening minutes per genre and bucket, from plays that were not skipped.
+
+        Every bucket with plays is returned, with one row per genre. A bucket
+        whose plays have no known genres has a single row with genre None.
+        genre_plays counts plays split evenly across an artist's genres.
+        """
+        return self._fetch_all(GENRE_MINUTES_SQL, self._window(
+            date_from, date_to, granularity=granularity))
+
+    def artist_minutes(self, granularity: str, date_from: Optional[date],
+                       date_to: Optional[date]) -> list[dict]:
+        """
+        Listening minutes per artist and bucket, from plays that were not
+        skipped, in the shape of genre_minutes. Spelling variants of an
+        artist are one artist; a track with several artists counts fully
+        for each.
+        """
+        return self._fetch_all(ARTIST_MINUTES_SQL, self._window(
+            date_from, date_to, granularity=granularity))
+
+    def most_skipped_tracks(self, date_from: Optional[date], date_to: Optional[date],
+                            limit: int) -> list[dict]:
+        return self._fetch_all(MOST_SKIPPED_TRACKS_SQL, self._window(date_from, date_to, limit=limit))
+
+    def most_skipped_artists(self, date_from: Optional[date], date_to: Optional[date],
+                             limit: int) -> list[dict]:
+        return self._fetch_all(MOST_SKIPPED_ARTISTS_SQL, self._window(date_from, date_to, limit=limit))
+
+    def daily_summary(self, date_from: Optional[date], date_to: Optional[date]) -> list[dict]:
+        """
+        Plays, skips and minutes per local day, for days with at least one play.
+
+        Minutes only count plays that were not skipped.
+        """
+        return self._fetch_all(DAILY_SUMMARY_SQL, self._window(date_from, date_to))
+
+    def busiest_day(self, date_from: Optional[date], date_to: Optional[date]) -> Optional[dict]:
+        return self._fetch_one(BUSIEST_DAY_SQL, self._window(date_from, date_to))
+
+    def longest_session(self, date_from: Optional[date], date_to: Optional[date]) -> Optional[dict]:
+        return self._fetch_one(LONGEST_SESSION_SQL, self._window(
+            date_from, date_to, session_gap=timedelta(minutes=SESSION_GAP_MINUTES)))
+
+    def session_stats(self, date_from: Optional[date], date_to: Optional[date]) -> dict:
+        """
+        Count listening sessions and summarize their length in minutes and tracks.
+
+        :return: Row with sessions, averages, medians and length buckets
+        :rtype: dict
+        """
+        return self._fetch_one(SESSION_STATS_SQL, self._window(
+            date_from, date_to, session_gap=timedelta(minutes=SESSION_GAP_MINUTES)))
+
+    def session_album_order(self, date_from: Optional[date], date_to: Optional[date]) -> list[dict]:
+        """
+        Per listening session, count consecutive plays from the same album and
+        how many of those followed the album's track order.
+        """
+        return self._fetch_all(SESSION_ALBUM_ORDER_SQL, self._window(
+            date_from, date_to, session_gap=timedelta(minutes=SESSION_GAP_MINUTES)))
+
+    def artist_leaderboard(self, limit: int) -> list[dict]:
+        """
+        Rank artists by time listened over the whole history.
+
+        :return: Rows with rank, artist, total_ms and plays
+        :rtype: list[dict]
+        """
+        return self._fetch_all(ARTIST_LEADERBOARD_SQL, self._params(limit=limit))
+
+    def devices(self, date_from: Optional[date], date_to: Optional[date]) -> list[dict]:
+        """
+        Plays and minutes per Navidrome player. Plays recorded before the
+        player was stored are grouped as "unknown".
+        """
+        return self._fetch_all(DEVICES_SQL, self._window(date_from, date_to))
+
+    def rediscoveries(self, since: timedelta, limit: int) -> list[dict]:
+        """
+        Plays marked as rediscoveries by the tracker, longest gap first.
+        """
+        return self._fetch_all(REDISCOVERIES_SQL, self._params(since=since, limit=limit))
+
+    def history(self, after_id: int, limit: int, offset: int,
+                date_from: Optional[date], date_to: Optional[date]) -> list[dict]:
+        """
+        Plays in the order they were stored.
+
+        :param after_id: Only plays with a higher track_play_id
+        :param offset: Plays to skip before the first one returned
+        :return: Up to limit rows with track_play_id, played_at, track_id,
+            title, artist, username, device_name, skipped and skip_score
+        :rtype: list[dict]
+        """
+        return self._fetch_all(HISTORY_SQL, self._window(date_from, date_to, after_id=after_id, limit=limit,
+                                                          offset=offset))
+
+    def top_albums(self, sort: str, limit: int, date_from: Optional[date], date_to: Optional[date]) -> list[dict]:
+        """
+        Albums by listening time, plays or skip rate, with their most and
+        least played tracks.
+
+        :param sort: One of "minutes", "plays" or "skip_rate"
+        :type sort: str
+        :return: Rows with album, artist, plays, minutes, skip_rate,
+            most_played_track and least_played_track
+        :rtype: list[dict]
+        """
+        return self._fetch_all(TOP_ALBUMS_SQL, self._window(date_from, date_to, sort=sort, limit=limit))
+
+    def artists_by_name(self, name: str) -> list[dict]:
+        """
+        Artists whose name matches case-insensitively, most played first.
+        """
+        return self._fetch_all(ARTISTS_BY_NAME_SQL, {"name": name})
+
+    def artist_by_id(self, artist_id: int) -> Optional[dict]:
+        return self._fetch_one(ARTIST_BY_ID_SQL, {"artist_id": artist_id})
+
+    def artist_summary(self, artist_id: int, date_from: Optional[date], date_to: Optional[date]) -> dict:
+        """
+        Plays, minutes, skip rate and first/last play of an artist.
+
+        :return: A single row; first_played and last_played are None
+            without plays in the window
+        :rtype: dict
+        """
+        return self._fetch_one(ARTIST_SUMMARY_SQL, self._window(date_from, date_to, artist_id=artist_id))
+
+    def artist_timeline(self, artist_id: int, granularity: str,
+                        date_from: Optional[date], date_to: Optional[date]) -> list[dict]:
+        """
+        Plays and minutes of an artist per local week or month. Buckets
+        without plays are not returned.
+        """
+        return self._fetch_all(ARTIST_TIMELINE_SQL, self._window(
+            date_from, date_to, artist_id=artist_id, granularity=granularity,
+        ))
+
+    def artist_top_tracks(self, artist_id: int, limit: int,
+                          date_from: Optional[date], date_to: Optional[date]) -> list[dict]:
+        return self._fetch_all(ARTIST_TOP_TRACKS_SQL, self._window(
+            date_from, date_to, artist_id=artist_id, limit=limit,
+        ))
+
+    def artist_tracks(self, artist_id: int, date_from: Optional[date], date_to: Optional[date]) -> list[dict]:
+        """
+        Every track of an artist played in the window, most played first.
+        """
+        return self._fetch_all(ARTIST_TRACKS_SQL, self._window(date_from, date_to, artist_id=artist_id))
+
+    def cross_platform(self) -> list[dict]:
+        """
+        Plays and tracks per source: Navidrome and each imported library.
+        """
+        return self._fetch_all(CROSS_PLATFORM_SQL, self._params())
+
+    def completion(self, since: timedelta) -> dict:
+        """
+        Percentiles of the listened fraction of plays since `since`.
+
+        :return: plays, unscored_plays and percentiles (p10, p25, p50, p75,
+            p90, or None without scored plays)
+        :rtype: dict
+        """
+        return self._fetch_one(COMPLETION_SQL, self._params(since=since))
+
+    def completion_histogram(self, since: timedelta) -> list[dict]:
+        """
+        Scored plays per 10% bucket of listened fraction. Empty buckets are
+        not returned.
+        """
+        return self._fetch_all(COMPLETION_HISTOGRAM_SQL, self._params(since=since))
+
+    def first_play_day(self) -> Optional[date]:
+        return self._fetch_one(FIRST_PLAY_DAY_SQL, self._params())["day"]
+
+    def on_this_day(self, month_day: str, before: date) -> list[dict]:
+        """
+        Summarize every local day before `before` that falls on month_day.
+
+        :param month_day: Calendar date as "MM-DD"
+        :type month_day: str
+        :return: Days with plays, minutes, top track and top artist, newest first
+        :rtype: list[dict]
+        """
+        return self._fetch_all(ON_THIS_DAY_SQL, self._params(month_day=month_day, before=before))
+
+    def on_this_day_tracks(self, month_day: str, before: date) -> list[dict]:
+        """
+        Every track played on the local days before `before` that fall on
+        month_day, with its plays and skips that day.
+
+        :param month_day: Calendar date as "MM-DD"
+        :type month_day: str
+        :return: One row per day and track, newest day first, tracks in the
+            order they were first played that day
+        :rtype: list[dict]
+        """
+        return self._fetch_all(ON_THIS_DAY_TRACKS_SQL, self._params(month_day=month_day, before=before))
+
+    def run_readonly_query(self, sql: str) -> list[dict]:
+        """
+        Run an ad-hoc query inside a read-only transaction.
+
+        The transaction is always rolled back and bounded by
+        QUERY_TIMEOUT_MS and QUERY_MAX_ROWS.
+
+        :param sql: A single SELECT statement
+        :type sql: str
+        :return: Result rows
+        :rtype: list[dict]
+        :raises psycopg2.Error: If the query fails or tries to write
+        """
+        with self.conn.cursor(cursor_factory=RealDictCursor) as cur:
+            cur.execute("BEGIN TRANSACTION READ ONLY;")
+            try:
+                cur.execute("SET LOCAL statement_timeout = %s;", (QUERY_TIMEOUT_MS,))
+                cur.execute(sql)
+                return cur.fetchmany(QUERY_MAX_ROWS)
+            finally:
+                cur.execute("ROLLBACK;")
+
+
+# -------------------------
+# Helpers
+# -------------------------
+
+def summarize_streaks(streaks: list[dict], today: date) -> dict:
+    """
+    Reduce streak islands to current streak, longest streak and active days.
+
+    A streak is still current if its last day is today or yesterday,
+    since today's first play may simply not have happened yet.
+
+    :param streaks: Streaks as returned by DatabaseReader.listening_streaks
+    :param today: Current local date
+    :return: Streak summary
+    :rtype: dict
+    """
+    current = 0
+    if streaks and streaks[-1]["end_day"] >= today - timedelta(days=1):
+        current = streaks[-1]["length"]
+
+    longest = max(streaks, key=lambda s: s["length"], default=None)
+
+    return {
+        "current_streak": current,
+        "longest_streak": {
+            "length": longest["length"],
+            "from": longest["start_day"].isoformat(),
+            "to": longest["end_day"].isoformat(),
+        } if longest else None,
+        "active_days": sum(s["length"] for s in streaks),
+    }
+
+
+def detect_binges(plays: list[dict], slack: timedelta, min_count: int) -> list[dict]:
+    """
+    Find runs of back-to-back plays of the same track.
+
+    A play continues a run if it is the same track for the same user and
+    starts no later than the previous play's start plus the track duration
+    plus slack.
+
+    :param plays: Plays ordered by user and played_at
+    :param slack: Allowed gap between the end of one play and the next start
+    :param min_count: Minimum plays in a run to report it
+    :return: Binges with track, count and span, most recent first
+    :rtype: list[dict]
+    """
+    binges = []
+    run = []
+
+    def close_run():
+        if len(run) >= min_count:
+            binges.append({
+                "track_id": run[0]["track_id"],
+                "title": run[0]["title"],
+                "artist": run[0]["artist"],
+                "count": len(run),
+                "started_at": run[0]["played_at"].isoformat(),
+                "ended_at": run[-1]["played_at"].isoformat(),
+            })
+
+    for play in plays:
+        if run:
+            previous = run[-1]
+            deadline = previous["played_at"] + timedelta(milliseconds=previous["duration_ms"] or 0) + slack
+            if (play["user_id"] == previous["user_id"]
+                    and play["track_id"] == previous["track_id"]
+                    and play["played_at"] <= deadline):
+                run.append(play)
+                continue
+            close_run()
+        run = [play]
+    close_run()
+
+    binges.sort(key=lambda b: b["started_at"], reverse=True)
+    return binges
+
+
+def detect_rabbit_holes(plays: list[dict], min_plays: int, max_detour: int, gap: timedelta,
+                        now: Optional[datetime] = None) -> list[dict]:
+    """
+    Find runs in which one user kept playing tracks of the same artist.
+
+    A play of the artist continues its run if at most max_detour plays of
+    other artists came in between and it started within gap of the run's
+    previous play. Runs of a single track are binges, not rabbit holes, so a
+    run needs at least two different tracks besides min_plays plays. A run
+    that reaches the last play of its user and ended less than gap before
+    now is still active.
+
+    :param plays: Unskipped plays ordered by user and played_at, with
+        artist_ids and artist_names
+    :param min_plays: Minimum plays of the artist in a run
+    :param max_detour: Plays of other artists allowed between two of its plays
+    :param gap: Maximum time between two plays of the run
+    :param now: Reference time for still_active, defaults to the current time
+    :return: Rabbit holes with artist, plays, tracks, span and still_active,
+        most recent first
+    :rtype: list[dict]
+    """
+    now = now or datetime.now(timezone.utc)
+    holes = []
+    runs = {}
+
+    def close(run: dict, active: bool = False):
+        if run["plays"] >= min_plays and len(run["tracks"]) >= 2:
+            holes.append({
+                "artist_id": run["artist_id"],
+                "artist": run["artist"],
+                "plays": run["plays"],
+                "tracks": len(run["tracks"]),
+                "started_at": run["started_at"].isoformat(),
+                "ended_at": run["ended_at"].isoformat(),
+                "still_active": active and now - run["ended_at"] < gap,
+            })
+
+    for index, play in enumerate(plays):
+        if index and play["user_id"] != plays[index - 1]["user_id"]:
+            for run in runs.values():
+                close(run, active=run["last_index"] == index - 1)
+            runs = {}
+
+        for artist_id, name in zip(play["artist_ids"], play["artist_names"]):
+            run = runs.get(artist_id)
+            if run and (index - run["last_index"] - 1 > max_detour
+                        or play["played_at"] - run["ended_at"] > gap):
+                close(run)
+                run = None
+            if run is None:
+                run = runs[artist_id] = {
+                    "artist_id": artist_id,
+                    "artist": name,
+                    "plays": 0,
+                    "tracks": set(),
+                    "started_at": play["played_at"],
+                }
+            run["plays"] += 1
+            run["tracks"].add(play["track_id"])
+            run["ended_at"] = play["played_at"]
+            run["last_index"] = index
+
+        for artist_id in [a for a, run in runs.items() if index - run["last_index"] > max_detour]:
+            close(runs.pop(artist_id))
+
+    last_index = len(plays) - 1
+    for run in runs.values():
+        close(run, active=run["last_index"] == last_index)
+
+    holes.sort(key=lambda h: h["started_at"], reverse=True)
+    return holes
+
+
+def render_heatmap(matrix: list[list[float]]) -> str:
+    """
+    Render a weekday/hour matrix as a text grid with shading characters.
+
+    Each cell is shaded relative to the busiest cell of the matrix.
+
+    :param matrix: 7x24 matrix of minutes, Monday first
+    :return: Aligned text grid with an hour header and a legend
+    :rtype: str
+    """
+    peak = max(max(row) for row in matrix)
+    lines = ["     " + "".join(f"{hour:02d} " for hour in range(24))]
+
+    for weekday, row in zip(WEEKDAYS, matrix):
+        cells = []
+        for minutes in row:
+            level = 0
+            if peak and minutes:
+                level = max(1, round(minutes / peak * (len(HEATMAP_SHADES) - 1)))
+            cells.append(HEATMAP_SHADES[level] * 2 + " ")
+        lines.append(f"{weekday}  " + "".join(cells))
+
+    lines.append("")
+    lines.append(f"peak: {peak:.0f} min per cell")
+    return "\n".join(lines) + "\n"
+
+
+def listening_diversity(rows: list[dict], min_plays: int, by: str = "genre") -> list[dict]:
+    """
+    Score how evenly listening time is spread over genres or artists, per
+    bucket.
+
+    With p_i the share of the bucket's minutes that went to genre or artist
+    i, two scores are given: the Shannon entropy -sum(p_i * log2(p_i)) in
+    bits, 0 when everything is one genre and log2(n) when n genres got the
+    same time, and the Gini-Simpson index 1 - sum(p_i^2), the chance that
+    two random minutes belong to different genres, from 0 up to 1 - 1/n.
+    Buckets with fewer than min_plays plays are flagged low_confidence and
+    get no scores.
+
+    :param rows: Output of DatabaseReader.genre_minutes or artist_minutes,
+        ordered by bucket
+    :param min_plays: Plays needed for a bucket to be scored
+    :param by: "genre" or "artist", the column of rows to score
+    :return: One entry per bucket with the scores, the number of genres or
+        artists and the top one's share
+    :rtype: list[dict]
+    """
+    buckets = {}
+    for row in rows:
+        bucket = buckets.setdefault(row["bucket"], {"plays": row["plays"], "minutes": {}})
+        if row[by] is not None and row["minutes"] > 0:
+            bucket["minutes"][row[by]] = row["minutes"]
+
+    result = []
+    for bucket, data in buckets.items():
+        total = sum(data["minutes"].values())
+        low_confidence = data["plays"] < min_plays or not total
+        entry = {
+            "bucket": bucket.isoformat(),
+            "plays": data["plays"],
+            f"{by}s": len(data["minutes"]),
+            "entropy": None,
+            "gini_simpson": None,
+            f"top_{by}": None,
+            f"top_{by}_share": None,
+            "low_confidence": low_confidence,
+        }
+        if total:
+            top, top_minutes = max(data["minutes"].items(), key=lambda item: item[1])
+            entry[f"top_{by}"] = top
+            entry[f"top_{by}_share"] = round(top_minutes / total, 3)
+        if not low_confidence:
+            shares = [minutes / total for minutes in data["minutes"].values()]
+            entry["entropy"] = round(-sum(p * math.log2(p) for p in shares), 3)
+            entry["gini_simpson"] = round(1 - sum(p * p for p in shares), 3)
+        result.append(entry)
+
+    return result
+
+
+def genre_trends(rows: list[dict], top: int) -> tuple[list[str], list[dict]]:
+    """
+    Share of plays per bucket for the genres with the most plays overall.
+
+    Shares are based on plays with known genres and add up to 1 per bucket,
+    with everything outside the top genres collected under "other".
+
+    :param rows: Output of DatabaseReader.genre_minutes, ordered by bucket
+    :param top: Number of genres to report individually
+    :return: The top genres and one entry per bucket with their shares
+    :rtype: tuple[list[str], list[dict]]
+    """
+    totals = defaultdict(float)
+    buckets = {}
+    for row in rows:
+        plays = buckets.setdefault(row["bucket"], {})
+        if row["genre"] is not None and row["genre_plays"] > 0:
+            plays[row["genre"]] = row["genre_plays"]
+            totals[row["genre"]] += row["genre_plays"]
+
+    genres = sorted(totals, key=lambda genre: (-totals[genre], genre))[:top]
+
+    result = []
+    for bucket, plays in buckets.items():
+        total = sum(plays.values())
+        shares = {genre: round(plays.get(genre, 0) / total, 3) if total else 0.0 for genre in genres}
+        shares["other"] = round(1 - sum(shares.values()), 3) if total else 0.0
+        result.append({
+            "bucket": bucket.isoformat(),
+            "plays": round(total, 1),
+            "shares": shares,
+        })
+
+    return genres, result
+
+
+def completion_histogram(rows: list[dict], width: int = 40) -> list[dict]:
+    """
+    Fill in all ten 10% buckets and draw a text bar for each, scaled so the
+    fullest bucket is width characters long.
+    """
+    plays = {row["bucket"]: row["plays"] for row in rows}
+    most = max(plays.values(), default=0)
+
+    return [
+        {
+            "range": f"{bucket * 10}-{bucket * 10 + 10}%",
+            "plays": plays.get(bucket, 0),
+            "bar": "#" * round(plays.get(bucket, 0) / most * width) if most else "",
+        }
+        for bucket in range(10)
+    ]
+
+
+def busiest_of(rows: list[dict], key: str, label=None) -> Optional[dict]:
+    """
+    Pick the row with the most minutes, e.g. from plays_by_weekday.
+
+    :param rows: Rows with key, plays and minutes
+    :param key: Name of the column identifying the row
+    :param label: Turns the key into a display name, if given
+    :return: key, plays and minutes of the busiest row, None without plays
+    :rtype: Optional[dict]
+    """
+    busiest = max(rows, key=lambda row: row["minutes"], default=None)
+    if not busiest or not busiest["plays"]:
+        return None
+    value = busiest[key]
+    return {key: label(value) if label else value, "plays": busiest["plays"], "minutes": busiest["minutes"]}
+
+
+def build_wrapped(reader: DatabaseReader, year: int) -> dict:
+    """
+    Assemble the yearly "Wrapped" summary.
+
+    :param reader: Database reader
+    :param year: Calendar year in USER_TIMEZONE
+    :return: Summary document
+    :rtype: dict
+    """
+    date_from, date_to = date(year, 1, 1), date(year, 12, 31)
+
+    totals = reader.listening_totals(date_from, date_to)
+    top_genres = reader.top_genres(date_from, date_to, limit=3)
+    most_skipped = reader.most_skipped_tracks(date_from, date_to, limit=1)
+    most_skipped_artist = reader.most_skipped_artists(date_from, date_to, limit=1)
+    weekdays = reader.plays_by_weekday(date_from, date_to)
+    hours = reader.plays_by_hour(date_from, date_to)
+    diversity = listening_diversity(reader.genre_minutes("year", date_from, date_to), DIVERSITY_MIN_PLAYS)
+    busiest_day = reader.busiest_day(date_from, date_to)
+    longest_session = reader.longest_session(date_from, date_to)
+    streak_islands = reader.listening_streaks(date_from, date_to, count_skipped=False)
+
+    if busiest_day:
+        busiest_day["day"] = busiest_day["day"].isoformat()
+    if longest_session:
+        for key in ("day", "started_at", "ended_at"):
+            longest_session[key] = longest_session[key].isoformat()
+
+    return {
+        "year": year,
+        "timezone": USER_TIMEZONE,
+        "total_minutes": totals["minutes"],
+        "total_plays": totals["plays"],
+        "unique_tracks": totals["unique_tracks"],
+        "unique_artists": totals["unique_artists"],
+        "top_artists": reader.top_artists(date_from, date_to, limit=5),
+        "top_tracks": reader.top_tracks(date_from, date_to, limit=5),
+        "top_genre": top_genres[0]["genre"] if top_genres else None,
+        "top_genres": [row["genre"] for row in top_genres],
+        "most_skipped_track": most_skipped[0] if most_skipped else None,
+        "most_skipped_artist": most_skipped_artist[0] if most_skipped_artist else None,
+        "busiest_weekday": busiest_of(weekdays, "weekday", lambda weekday: WEEKDAYS[weekday - 1]),
+        "busiest_hour": busiest_of(hours, "hour"),
+        "genre_diversity": diversity[0]["entropy"] if diversity else None,
+        "longest_session": longest_session,
+        "busiest_day": busiest_day,
+        "streaks": summarize_streaks(streak_islands, min(reader.local_today(), date_to)),
+    }
+
+
+def parse_period(value: str, today: date) -> tuple[date, date]:
+    """
+    Parse a period expression into an inclusive (from, to) date range.
+
+    Accepted forms:
+      - "2024-03" or "2024-03-15" for a single month or day
+      - "2024-Q1" for a calendar quarter
+      - "2024-01..2024-06" or "2024-01-05..2024-02-10" for explicit ranges
+      - "last-30d" for the 30 days up to today
+      - "previous-30d" for the 30 days before that (also with w/y units)
+
+    :param value: Period expression
+    :param today: Current local date, anchoring relative periods
+    :return: First and last day of the period
+    :raises InvalidParameter: If the expression cannot be parsed
+    """
+    relative = re.fullmatch(r"(last|previous)-(\d+)([dwy])", value)
+    if relative:
+        length = int(relative.group(2)) * DURATION_UNITS[relative.group(3)]
+        end = today if relative.group(1) == "last" else today - timedelta(days=length)
+        return end - timedelta(days=length - 1), end
+
+    start, _, end = value.partition("..")
+    return _period_bound(start, first=True), _period_bound(end or start, first=False)
+
+
+def _period_bound(value: str, first: bool) -> date:
+    try:
+        quarter = re.fullmatch(r"(\d{4})-Q([1-4])", value)
+        if quarter:
+            year, month = int(quarter.group(1)), int(quarter.group(2)) * 3 - (2 if first else 0)
+            day = 1 if first else calendar.monthrange(year, month)[1]
+            return date(year, month, day)
+        if re.fullmatch(r"\d{4}-\d{2}", value):
+            year, month = map(int, value.split("-"))
+            day = 1 if first else calendar.monthrange(year, month)[1]
+            return date(year, month, day)
+        return date.fromisoformat(value)
+    except ValueError:
+        raise InvalidParameter(f"invalid period bound: {value!r}")
+
+
+def list_changes(before: list[str], after: list[str]) -> dict:
+    """
+    Entries that entered and left a ranked list between two periods.
+    """
+    return {
+        "new": [name for name in after if name not in before],
+        "dropped": [name for name in before if name not in after],
+    }
+
+
+def period_summary(reader: DatabaseReader, date_from: date, date_to: date, limit: int = 10) -> dict:
+    totals = reader.listening_totals(date_from, date_to)
+    top_genres = reader.top_genres(date_from, date_to, limit=limit)
+    genre_plays = sum(row["plays"] for row in top_genres)
+    for row in top_genres:
+        row["share"] = round(row["plays"] / genre_plays, 3)
+
+    return {
+        "from": date_from.isoformat(),
+        "to": date_to.isoformat(),
+        "minutes": totals["minutes"],
+        "plays": totals["plays"],
+        "unique_artists": totals["unique_artists"],
+        "skip_rate": round(totals["skips"] / totals["plays"], 3) if totals["plays"] else 0.0,
+        "top_tracks": reader.top_tracks(date_from, date_to, limit=limit),
+        "top_artists": reader.top_artists(date_from, date_to, limit=limit),
+        "top_genres": top_genres,
+    }
+
+
+def jaccard_similarity(a: set, b: set) -> Optional[float]:
+    """
+    Size of the intersection over the size of the union: 1.0 for equal
+    sets, 0.0 for disjoint ones, None if both are empty.
+    """
+    union = a | b
+    if not union:
+        return None
+    return len(a & b) / len(union)
+
+
+def percent_change(before: float, after: float) -> Optional[float]:
+    """
+    Relative change from before to after in percent, None if before is 0.
+    """
+    if not before:
+        return None
+    return round((after - before) / before * 100, 1)
+
+
+def shuffle_likelihood(album_pairs: int, in_order_pairs: int,
+                       min_pairs: int = SHUFFLE_MIN_ALBUM_PAIRS) -> Optional[float]:
+    """
+    Share of same-album transitions that did not follow the track order.
+
+    Listening to an album front to back scores 0, shuffling it scores close
+    to 1. Returns None when a session has fewer than min_pairs same-album
+    transitions, e.g. because it mixed many albums, as there is too little
+    to judge.
+    """
+    if album_pairs < min_pairs:
+        return None
+    return round(1 - in_order_pairs / album_pairs, 3)
+
+
+def on_this_day_years(rows: list[dict], tracks: list[dict], month: int, day: int, first_year: int,
+                      last_year: int) -> list[dict]:
+    """
+    One entry per year from last_year down to first_year, empty where
+    nothing was played. Years without that date (29 February) are left out.
+    """
+    by_day = {row["day"]: row for row in rows}
+    tracks_by_day = defaultdict(list)
+    for track in tracks:
+        tracks_by_day[track["day"]].append({
+            "track_id": track["track_id"],
+            "title": track["title"],
+            "artist": track["artist"],
+            "plays": track["plays"],
+            "skips": track["skips"],
+            "first_played_at": track["first_played_at"].isoformat(),
+        })
+    years = []
+    for year in range(last_year, first_year - 1, -1):
+        try:
+            day_date = date(year, month, day)
+        except ValueError:
+            continue
+        row = by_day.get(day_date)
+        years.append({
+            "date": day_date.isoformat(),
+            "plays": row["plays"] if row else 0,
+            "minutes": row["minutes"] if row else 0.0,
+            "top_track": {
+                "title": row["top_track"],
+                "artist": row["top_track_artist"],
+                "plays": row["top_track_plays"],
+            } if row and row["top_track"] else None,
+            "top_artist": {
+                "artist": row["top_artist"],
+                "plays": row["top_artist_plays"],
+            } if row and row["top_artist"] else None,
+            "tracks": tracks_by_day[day_date],
+        })
+    return years
+
+
+def build_dashboard(pool: ThreadedConnectionPool, date_from: date, date_to: date,
+                    limit: int, min_play_ms: int = MIN_PLAY_MS) -> dict:
+    """
+    Collect the dashboard sections, each on its own pooled connection.
+
+    The sections are independent read-only queries, so they run
+    concurrently instead of one after another.
+
+    :param pool: Pool with at least DASHBOARD_WORKERS connections
+    :param date_from: First local day, inclusive
+    :param date_to: Last local day, inclusive
+    :param limit: Length of the top lists
+    :param min_play_ms: Shortest play that is counted, in milliseconds listened
+    :return: Sections keyed by name
+    :rtype: dict
+    """
+    sections = {
+        "top_tracks": lambda reader: reader.top_tracks(date_from, date_to, limit),
+        "top_artists": lambda reader: reader.top_artists(date_from, date_to, limit),
+        "top_genres": lambda reader: reader.top_genres(date_from, date_to, limit),
+        "daily": lambda reader: reader.daily_summary(date_from, date_to),
+        "totals": lambda reader: reader.listening_totals(date_from, date_to),
+    }
+
+    def run(section):
+        conn = pool.getconn()
+        try:
+            conn.autocommit = True
+            return section(DatabaseReader(conn, min_play_ms))
+        finally:
+            pool.putconn(conn)
+
+    with ThreadPoolExecutor(max_workers=DASHBOARD_WORKERS) as executor:
+        futures = {name: executor.submit(run, section) for name, section in sections.items()}
+        results = {name: future.result() for name, future in futures.items()}
+
+    totals = results.pop("totals")
+    for row in results["daily"]:
+        row["day"] = row["day"].isoformat()
+
+    return {
+        **results,
+        "skip_rate": round(totals["skips"] / totals["plays"], 3) if totals["plays"] else 0.0,
+        "totals": totals,
+    }
+
+
+def is_single_select(sql: str) -> bool:
+    """
+    Cheap pre-check that a query is one SELECT (or WITH ... SELECT) statement.
+
+    The read-only transaction is what actually prevents writes; this only
+    rejects obvious misuse with a clear error.
+    """
+    statement = sql.strip().rstrip(";").strip()
+    if not statement or ";" in statement:
+        return False
+    first_word = statement.split(None, 1)[0].lower()
+    return first_word in ("select", "with")
+
+
+# -------------------------
+# Request Helpers
+# -------------------------
+
+def parse_date_param(name: str) -> Optional[date]:
+    value = request.args.get(name)
+    if not value:
+        return None
+    try:
+        return date.fromisoformat(value)
+    except ValueError:
+        raise InvalidParameter(f"{name} must be a date in YYYY-MM-DD format")
+
+
+def parse_bool_param(name: str, default: bool = False) -> bool:
+    value = request.args.get(name)
+    if value is None:
+        return default
+    if value.lower() in ("1", "true", "yes"):
+        return True
+    if value.lower() in ("0", "false", "no"):
+        return False
+    raise InvalidParameter(f"{name} must be true or false")
+
+
+def parse_int_param(name: str, default: int, minimum: int = 0) -> int:
+    value = request.args.get(name)
+    if value is None:
+        return default
+    try:
+        parsed = int(value)
+    except ValueError:
+        raise InvalidParameter(f"{name} must be an integer")
+    if parsed < minimum:
+        raise InvalidParameter(f"{name} must be at least {minimum}")
+    return parsed
+
+
+def current_min_play_ms() -> int:
+    """
+    :return: The min_play_ms parameter of the current request, MIN_PLAY_MS
+        without one or outside a request
+    :rtype: int
+    :raises InvalidParameter: If the parameter is not a non-negative integer
+    """
+    if not has_request_context():
+        return MIN_PLAY_MS
+    return parse_int_param("min_play_ms", default=MIN_PLAY_MS)
+
+
+def parse_duration_param(name: str, default: str) -> timedelta:
+    """
+    Parse a relative duration such as "90d", "12w" or "1y".
+    """
+    value = request.args.get(name, default)
+    match = re.fullmatch(r"(\d+)([dwy])", value)
+    if not match:
+        raise InvalidParameter(f"{name} must look like 90d, 12w or 1y")
+    return timedelta(days=int(match.group(1)) * DURATION_UNITS[match.group(2)])
+
+
+def parse_choice_param(name: str, choices: tuple[str, ...], default: str) -> str:
+    value = request.args.get(name, default)
+    if value not in choices:
+        raise InvalidParameter(f"{name} must be one of {', '.join(choices)}")
+    return value
+
+
+def respond(payload: dict, rows_key: str):
+    """
+    Return payload as JSON, or only its rows_key list rendered in the
+    format requested with ?format=table|csv|markdown.
+    """
+    fmt = parse_choice_param("format", FORMATS, default="json")
+    if fmt == "json":
+        return jsonify(payload)
+    return render(fmt, payload[rows_key]), 200, {"Content-Type": CONTENT_TYPES[fmt]}
+
+
+def parse_named_period(name: str, today: date) -> tuple[date, date]:
+    """
+    Read one of two compared periods, given as <name>=<period>,
+    period_<name>=<period> or period_<name>_from and period_<name>_to dates.
+
+    :param name: "a" or "b"
+    :param today: Current local date, anchoring relative periods
+    :return: First and last day of the period
+    :raises InvalidParameter: If the period is missing or invalid
+    """
+    value = request.args.get(name) or request.args.get(f"period_{name}")
+    if value:
+        date_from, date_to = parse_period(value, today)
+    else:
+        date_from = parse_date_param(f"period_{name}_from")
+        date_to = parse_date_param(f"period_{name}_to")
+        if not date_from or not date_to:
+            raise InvalidParameter(
+                f"period {name} is required, as {name}=... or period_{name}_from and period_{name}_to")
+    if date_from > date_to:
+        raise InvalidParameter(f"period {name} ends before it starts")
+    return date_from, date_to
+
+
+def parse_window() -> tuple[Optional[date], Optional[date]]:
+    date_from = parse_date_param("from")
+    date_to = parse_date_param("to")
+    if date_from and date_to and date_from > date_to:
+        raise InvalidParameter("from must not be after to")
+    return date_from, date_to
+
+
+# -------------------------
+# API Endpoints
+# -------------------------
+app = Flask(__name__)
+if CORS_ALLOWED_ORIGINS:
+    CORS(app, origins=CORS_ALLOWED_ORIGINS)
+query_limiter = RateLimiter(QUERY_RATE_LIMIT)
+
+
+response_cache = TTLCache(RESPONSE_CACHE_TTL, RESPONSE_CACHE_MAX_ENTRIES)
+
+
+def cached(view):
+    """
+    Serve successful responses of a GET endpoint from response_cache.
+
+    Requests are keyed on path and query string; ?nocache=1 skips the
+    cache and does not store the fresh response either.
+    """
+    @functools.wraps(view)
+    def wrapper(*args, **kwargs):
+        if not RESPONSE_CACHE_TTL or parse_bool_param("nocache"):
+            return view(*args, **kwargs)
+
+        key = (request.path, tuple(sorted(
+            (name, value) for name, value in request.args.items(multi=True) if name != "nocache"
+        )))
+        hit = response_cache.get(key)
+        if hit is not None:
+            body, content_type = hit
+            return app.response_class(body, status=200, content_type=content_type)
+
+        response = make_response(view(*args, **kwargs))
+        if response.status_code == 200:
+            response_cache.set(key, (response.get_data(), response.content_type))
+        return response
+
+    return wrapper
+
+
+@app.before_request
+def require_api_key():
+    # Preflight requests carry no credentials; flask-cors answers them.
+    if not STATS_API_KEY or request.path in PUBLIC_PATHS or request.method == "OPTIONS":
+        return None
+
+    scheme, _, token = request.headers.get("Authorization", "").partition(" ")
+    if scheme.lower() != "bearer" or not hmac.compare_digest(
+            token.strip().encode(), STATS_API_KEY.encode()):
+        log.info("Rejected unauthenticated request", path=request.path, remote_addr=request.remote_addr)
+        return jsonify({"error": "missing or invalid API key"}), 401, {"WWW-Authenticate": "Bearer"}
+
+    return None
+
+
+@app.errorhandler(InvalidParameter)
+def invalid_parameter(e):
+    return {"error": str(e)}, 400
+
+
+@app.route("/healthz", methods=["GET"])
+def healthz():
+    return jsonify({"status": "ok"})
+
+
+@app.route("/openapi.json", methods=["GET"])
+def openapi():
+    return jsonify(openapi_document())
+
+
+@app.route("/by-weekday", methods=["GET"])
+@cached
+def by_weekday():
+    date_from, date_to = parse_window()
+    rows = app.db_reader.plays_by_weekday(date_from, date_to)
+    log.debug("Computed weekday breakdown", date_from=date_from, date_to=date_to)
+
+    return respond({
+        "from": date_from.isoformat() if date_from else None,
+        "to": date_to.isoformat() if date_to else None,
+        "timezone": USER_TIMEZONE,
+        "weekdays": [
+            {
+                "weekday": WEEKDAYS[row["weekday"] - 1],
+                "plays": row["plays"],
+                "minutes": row["minutes"],
+            }
+            for row in rows
+        ],
+    }, "weekdays")
+
+
+@app.route("/streaks", methods=["GET"])
+@cached
+def streaks():
+    date_from, date_to = parse_window()
+    count_skipped = parse_bool_param("count_skipped")
+
+    islands = app.db_reader.listening_streaks(date_from, date_to, count_skipped)
+    summary = summarize_streaks(islands, app.db_reader.local_today())
+
+    return jsonify({
+        "timezone": USER_TIMEZONE,
+        "count_skipped": count_skipped,
+        **summary,
+    })
+
+
+@app.route("/binges", methods=["GET"])
+@cached
+def binges():
+    date_from, date_to = parse_window()
+    slack = timedelta(seconds=parse_int_param("slack", default=30))
+    min_count = parse_int_param("min_count", default=3, minimum=2)
+
+    plays = app.db_reader.ordered_plays(date_from, date_to)
+    found = detect_binges(plays, slack, min_count)
+    log.debug("Detected binges", plays=len(plays), binges=len(found))
+
+    return respond({"binges": found}, "binges")
+
+
+@app.route("/rabbit-holes", methods=["GET"])
+@cached
+def rabbit_holes():
+    date_from, date_to = parse_window()
+    min_plays = parse_int_param("min_plays", default=5, minimum=2)
+    max_detour = parse_int_param("max_detour", default=1, minimum=0)
+    limit = parse_int_param("limit", default=50, minimum=1)
+
+    plays = app.db_reader.ordered_artist_plays(date_from, date_to)
+    found = detect_rabbit_holes(plays, min_plays, max_detour, timedelta(minutes=SESSION_GAP_MINUTES))
+    log.debug("Detected rabbit holes", plays=len(plays), rabbit_holes=len(found))
+
+    return respond({
+        "min_plays": min_plays,
+        "max_detour": max_detour,
+        "rabbit_holes": found[:limit],
+    }, "rabbit_holes")
+
+
+@app.route("/rabbit-holes/recorded", methods=["GET"])
+@cached
+def recorded_rabbit_holes():
+    active_only = parse_bool_param("active")
+    limit = parse_int_param("limit", default=20, minimum=1)
+
+    holes = app.db_reader.recorded_rabbit_holes(active_only, limit)
+    for row in holes:
+        row["started_at"] = row["started_at"].isoformat()
+        row["ended_at"] = row["ended_at"].isoformat()
+
+    return respond({"rabbit_holes": holes}, "rabbit_holes")
+
+
+@app.route("/binge-days", methods=["GET"])
+@cached
+def binge_days():
+    by = parse_choice_param("by", ("track", "artist"), default="track")
+    since = parse_duration_param("since", default="1y")
+    min_repeats = parse_int_param("min_repeats", default=5, minimum=2)
+    limit = parse_int_param("limit", default=50, minimum=1)
+
+    rows = app.db_reader.binge_days(by, since, min_repeats, limit)
+    for row in rows:
+        row["day"] = row["day"].isoformat()
+
+    return respond({
+        "by": by,
+        "since_days": since.days,
+        "min_repeats": min_repeats,
+        "days": rows,
+    }, "days")
+
+
+@app.route("/binge-sessions", methods=["GET"])
+@cached
+def binge_sessions():
+    limit = parse_int_param("limit", default=10, minimum=1)
+
+    sessions = app.db_reader.binge_sessions(limit)
+    for row in sessions:
+        row["started_at"] = row["started_at"].isoformat()
+        row["ended_at"] = row["ended_at"].isoformat()
+
+    return respond({"sessions": sessions}, "sessions")
+
+
+@app.route("/favorites", methods=["GET"])
+@cached
+def favorites():
+    half_life = parse_duration_param("half_life", default="30d")
+    if not half_life:
+        raise InvalidParameter("half_life must be longer than 0 days")
+    limit = parse_int_param("limit", default=25, minimum=1)
+    # Text formats show one list; pick it with by=
+    by = parse_choice_param("by", ("track", "artist"), default="track")
+
+    return respond({
+        "half_life_days": half_life.days,
+        "tracks": app.db_reader.favorites("track", half_life, limit),
+        "artists": app.db_reader.favorites("artist", half_life, limit),
+    }, f"{by}s")
+
+
+@app.route("/forgotten", methods=["GET"])
+@cached
+def forgotten():
+    by = parse_choice_param("by", ("track", "artist"), default="track")
+    min_plays = parse_int_param("min_plays", default=20, minimum=1)
+    quiet_for = parse_duration_param("quiet_for", default="180d")
+    exclude_active_artists = parse_bool_param("exclude_active_artists")
+    active_plays = parse_int_param("active_plays", default=10, minimum=1)
+    limit = parse_int_param("limit", default=50, minimum=1)
+
+    rows = app.db_reader.forgotten(by, min_plays, quiet_for, limit,
+                                   exclude_active_artists, active_plays)
+    for row in rows:
+        row["last_played_at"] = row["last_played_at"].isoformat()
+
+    return respond({
+        "by": by,
+        "min_plays": min_plays,
+        "quiet_for_days": quiet_for.days,
+        "items": rows,
+    }, "items")
+
+
+@app.route("/skips", methods=["GET"])
+@cached
+def skips():
+    by = parse_choice_param("by", ("track", "artist"), default="track")
+    since = parse_duration_param("since", default="90d")
+    min_plays = parse_int_param("min_plays", default=5, minimum=1)
+    limit = parse_int_param("limit", default=50, minimum=1)
+    weighted = parse_bool_param("weighted")
+
+    rows = app.db_reader.skip_rates(by, since, min_plays, limit, weighted)
+
+    return respond({
+        "by": by,
+        "since_days": since.days,
+        "min_plays": min_plays,
+        "weighted": weighted,
+        "items": rows,
+    }, "items")
+
+
+@app.route("/heatmap", methods=["GET"])
+@cached
+def heatmap():
+    since = parse_duration_param("since", default="90d")
+    fmt = parse_choice_param("format", ("json", "text"), default="json")
+
+    matrix = app.db_reader.listening_heatmap(since)
+
+    if fmt == "text":
+        return render_heatmap(matrix), 200, {"Content-Type": "text/plain; charset=utf-8"}
+
+    return jsonify({
+        "since_days": since.days,
+        "timezone": USER_TIMEZONE,
+        "weekdays": WEEKDAYS,
+        "minutes": matrix,
+    })
+
+
+@app.route("/discoveries", methods=["GET"])
+@cached
+def discoveries():
+    date_from, date_to = parse_window()
+    granularity = parse_choice_param("granularity", GRANULARITIES, default="month")
+    with_names = parse_bool_param("list")
+
+    rows = app.db_reader.discoveries(granularity, date_from, date_to)
+
+    buckets = []
+    for row in rows:
+        bucket = {
+            "bucket": row["bucket"].isoformat(),
+            "new_artists": row["new_artists"],
+            "new_tracks": row["new_tracks"],
+            "top_new_artist": row["top_new_artist"],
+        }
+        if with_names:
+            bucket["artist_names"] = row["artist_names"]
+        buckets.append(bucket)
+
+    return respond({"granularity": granularity, "buckets": buckets}, "buckets")
+
+
+@app.route("/discoveries/monthly", methods=["GET"])
+@cached
+def monthly_discoveries():
+    months = parse_int_param("months", default=12, minimum=1)
+
+    rows = app.db_reader.monthly_discoveries(months)
+
+    return respond({"months": [
+        {
+            "month": row["month"].strftime("%Y-%m"),
+            "new_artists": row["new_artists"],
+            "new_tracks": row["new_tracks"],
+            "new_genres": row["new_genres"],
+        }
+        for row in rows
+    ]}, "months")
+
+
+@app.route("/sessions", methods=["GET"])
+@cached
+def sessions():
+    since = parse_duration_param("since", default="90d")
+    date_to = app.db_reader.local_today()
+    date_from = date_to - since + timedelta(days=1)
+
+    stats = app.db_reader.session_stats(date_from, date_to)
+    longest = app.db_reader.longest_session(date_from, date_to)
+    if longest:
+        for key in ("day", "started_at", "ended_at"):
+            longest[key] = longest[key].isoformat()
+
+    return jsonify({
+        "since_days": since.days,
+        "session_gap_minutes": SESSION_GAP_MINUTES,
+        "sessions": stats["sessions"],
+        "avg_minutes": stats["avg_minutes"],
+        "median_minutes": stats["median_minutes"],
+        "avg_tracks": stats["avg_tracks"],
+        "median_tracks": stats["median_tracks"],
+        "distribution": {
+            "under_15m": stats["under_15m"],
+            "15m_to_1h": stats["from_15m_to_1h"],
+            "1h_to_3h": stats["from_1h_to_3h"],
+            "over_3h": stats["over_3h"],
+        },
+        "longest": longest,
+    })
+
+
+@app.route("/diversity", methods=["GET"])
+@cached
+def diversity():
+    date_from, date_to = parse_window()
+    granularity = parse_choice_param("granularity", GRANULARITIES, default="month")
+    min_plays = parse_int_param("min_plays", default=DIVERSITY_MIN_PLAYS, minimum=1)
+    by = parse_choice_param("by", ("genre", "artist"), default="genre")
+    fmt = parse_choice_param("format", ("json", "text"), default="json")
+
+    if by == "artist":
+        rows = app.db_reader.artist_minutes(granularity, date_from, date_to)
+    else:
+        rows = app.db_reader.genre_minutes(granularity, date_from, date_to)
+    buckets = listening_diversity(rows, min_plays, by)
+
+    if fmt == "text":
+        return render_diversity_table(buckets, by), 200, {"Content-Type": "text/plain; charset=utf-8"}
+
+    return jsonify({"granularity": granularity, "by": by, "min_plays": min_plays, "buckets": buckets})
+
+
+@app.route("/on-this-day", methods=["GET"])
+@cached
+def on_this_day():
+    today = app.db_reader.local_today()
+    month_day = request.args.get("date", today.strftime("%m-%d"))
+    match = re.fullmatch(r"(\d{2})-(\d{2})", month_day)
+    try:
+        if not match:
+            raise ValueError
+        date(2000, int(match.group(1)), int(match.group(2)))
+    except ValueError:
+        raise InvalidParameter("date must be a calendar date in MM-DD format")
+    month, day = int(match.group(1)), int(match.group(2))
+
+    first_day = app.db_reader.first_play_day()
+    if first_day is None:
+        return respond({"date": month_day, "timezone": USER_TIMEZONE, "years_with_data": 0,
+                        "tracking_since": None, "years": []}, "years")
+
+    before = date(today.year, 1, 1)
+    rows = app.db_reader.on_this_day(month_day, before)
+    tracks = app.db_reader.on_this_day_tracks(month_day, before)
+    years = on_this_day_years(rows, tracks, month, day, first_day.year, today.year - 1)
+
+    return respond({
+        "date": month_day,
+        "timezone": USER_TIMEZONE,
+        "years_with_data": sum(1 for year in years if year["plays"]),
+        "tracking_since": first_day.isoformat(),
+        "years": years,
+    }, "years")
+
+
+@app.route("/shuffle", methods=["GET"])
+@cached
+def shuffle():
+    date_from, date_to = parse_window()
+    min_pairs = parse_int_param("min_pairs", default=SHUFFLE_MIN_ALBUM_PAIRS, minimum=1)
+
+    sessions = []
+    for row in app.db_reader.session_album_order(date_from, date_to):
+        likelihood = shuffle_likelihood(row["album_pairs"], row["in_order_pairs"], min_pairs)
+        sessions.append({
+            "started_at": row["started_at"].isoformat(),
+            "ended_at": row["ended_at"].isoformat(),
+            "tracks": row["tracks"],
+            "album_pairs": row["album_pairs"],
+            "in_order_pairs": row["in_order_pairs"],
+            "shuffle_likelihood": likelihood,
+            "shuffled": None if likelihood is None else likelihood >= SHUFFLE_THRESHOLD,
+        })
+
+    return respond({"min_pairs": min_pairs, "threshold": SHUFFLE_THRESHOLD, "sessions": sessions}, "sessions")
+
+
+@app.route("/artist-leaderboard", methods=["GET"])
+@cached
+def artist_leaderboard():
+    limit = parse_int_param("limit", default=50, minimum=1)
+    return respond({"artists": app.db_reader.artist_leaderboard(limit)}, "artists")
+
+
+@app.route("/devices", methods=["GET"])
+@cached
+def devices():
+    date_from, date_to = parse_window()
+
+    rows = app.db_reader.devices(date_from, date_to)
+    total_minutes = sum(row["minutes"] for row in rows)
+    for row in rows:
+        row["share"] = round(row["minutes"] / total_minutes, 3) if total_minutes else 0.0
+
+    return respond({"devices": rows}, "devices")
+
+
+@app.route("/rediscoveries", methods=["GET"])
+@cached
+def rediscoveries():
+    since = parse_duration_param("since", default="30d")
+    limit = parse_int_param("limit", default=50, minimum=1)
+
+    rows = app.db_reader.rediscoveries(since, limit)
+    for row in rows:
+        row["played_at"] = row["played_at"].isoformat()
+
+    return respond({"since_days": since.days, "rediscoveries": rows}, "rediscoveries")
+
+
+# Not cached: a cached page would hide plays stored since, and cursors
+# taken from it would skip them.
+@app.route("/history", methods=["GET"])
+def history():
+    date_from, date_to = parse_window()
+    # page and page_size are the older form, kept for simple clients.
+    paged = "page" in request.args or "page_size" in request.args
+    if paged and "after_id" in request.args:
+        raise InvalidParameter("pass either after_id or page, not both")
+
+    if paged:
+        page = parse_int_param("page", default=1, minimum=1)
+        limit = parse_int_param("page_size", default=HISTORY_PAGE_SIZE, minimum=1)
+        after_id, offset = 0, (page - 1) * limit
+    else:
+        after_id = parse_int_param("after_id", default=0)
+        limit = parse_int_param("limit", default=HISTORY_PAGE_SIZE, minimum=1)
+        offset = 0
+    if limit > HISTORY_MAX_PAGE_SIZE:
+        raise InvalidParameter(f"limit and page_size must be at most {HISTORY_MAX_PAGE_SIZE}")
+
+    # One extra row tells whether there is a next page.
+    rows = app.db_reader.history(after_id, limit + 1, offset, date_from, date_to)
+    more = len(rows) > limit
+    rows = rows[:limit]
+    for row in rows:
+        row["played_at"] = row["played_at"].isoformat()
+
+    payload = {"plays": rows, "next_cursor": rows[-1]["track_play_id"] if more else None}
+    if paged:
+        payload["page"] = page
+        payload["page_size"] = limit
+    return respond(payload, "plays")
+
+
+@app.route("/top-albums", methods=["GET"])
+@cached
+def top_albums():
+    date_from, date_to = parse_window()
+    sort = parse_choice_param("sort", ("minutes", "plays", "skip_rate"), default="minutes")
+    limit = parse_int_param("limit", default=10, minimum=1)
+
+    return respond({"sort": sort, "albums": app.db_reader.top_albums(sort, limit, date_from, date_to)}, "albums")
+
+
+@app.route("/artist", methods=["GET"])
+@cached
+def artist():
+    artist_id = parse_int_param("id", default=None, minimum=1)
+    name = request.args.get("name")
+    granularity = parse_choice_param("granularity", ("week", "month"), default="month")
+    date_from, date_to = parse_window()
+
+    if artist_id is not None:
+        match = app.db_reader.artist_by_id(artist_id)
+        if match is None:
+            return {"error": f"no artist with id {artist_id}"}, 404
+    elif name:
+        matches = app.db_reader.artists_by_name(name)
+        if not matches:
+            return {"error": f"no artist named {name}"}, 404
+        if len(matches) > 1:
+            return {"error": f"several artists are named {name}, pass one of their ids", "matches": matches}, 409
+        match = matches[0]
+    else:
+        raise InvalidParameter("name or id is required")
+
+    artist_id = match["artist_id"]
+    summary = app.db_reader.artist_summary(artist_id, date_from, date_to)
+    for key in ("first_played", "last_played"):
+        if summary[key] is not None:
+            summary[key] = summary[key].isoformat()
+
+    timeline = app.db_reader.artist_timeline(artist_id, granularity, date_from, date_to)
+    for row in timeline:
+        row["bucket"] = row["bucket"].isoformat()
+
+    return respond({
+        "artist_id": artist_id,
+        "artist": match["artist"],
+        **summary,
+        "top_tracks": app.db_reader.artist_top_tracks(artist_id, 5, date_from, date_to),
+        "granularity": granularity,
+        "timeline": timeline,
+    }, "timeline")
+
+
+@app.route("/artists/<int:artist_id>/tracks", methods=["GET"])
+@cached
+def artist_tracks(artist_id: int):
+    date_from, date_to = parse_window()
+
+    match = app.db_reader.artist_by_id(artist_id)
+    if match is None:
+        return {"error": f"no artist with id {artist_id}"}, 404
+
+    tracks = app.db_reader.artist_tracks(artist_id, date_from, date_to)
+    for row in tracks:
+        row["first_played"] = row["first_played"].isoformat()
+        row["last_played"] = row["last_played"].isoformat()
+
+    return respond({"artist_id": artist_id, "artist": match["artist"], "tracks": tracks}, "tracks")
+
+
+@app.route("/cross-platform", methods=["GET"])
+@cached
+def cross_platform():
+    sources = app.db_reader.cross_platform()
+    for row in sources:
+        if row["last_played"] is not None:
+            row["last_played"] = row["last_played"].isoformat()
+
+    return respond({"sources": sources}, "sources")
+
+
+@app.route("/completion", methods=["GET"])
+@cached
+def completion():
+    since = parse_duration_param("since", default="90d")
+
+    summary = app.db_reader.completion(since)
+    percentiles = summary["percentiles"]
+    histogram = completion_histogram(app.db_reader.completion_histogram(since))
+
+    return respond({
+        "since_days": since.days,
+        "plays": summary["plays"],
+        "unscored_plays": summary["unscored_plays"],
+        "percentiles": dict(zip(
+            ("p10", "p25", "p50", "p75", "p90"),
+            [round(value, 3) for value in percentiles] if percentiles else [None] * 5,
+        )),
+        "histogram": histogram,
+    }, "histogram")
+
+
+@app.route("/genre-trends", methods=["GET"])
+@cached
+def genre_trends_endpoint():
+    date_from, date_to = parse_window()
+    bucket = parse_choice_param("bucket", ("week", "month"), default="month")
+    top = parse_int_param("top", default=5, minimum=1)
+
+    rows = app.db_reader.genre_minutes(bucket, date_from, date_to)
+    genres, buckets = genre_trends(rows, top)
+
+    return respond({"bucket": bucket, "genres": genres, "buckets": buckets}, "buckets")
+
+
+@app.route("/wrapped", methods=["GET"])
+@cached
+def wrapped():
+    year = parse_int_param("year", default=app.db_reader.local_today().year, minimum=1970)
+    fmt = parse_choice_param("format", ("json", "text", "html"), default="json")
+
+    summary = build_wrapped(app.db_reader, year)
+
+    if fmt == "text":
+        return render_wrapped_text(summary), 200, {"Content-Type": "text/plain; charset=utf-8"}
+    if fmt == "html":
+        return render_wrapped_html(summary), 200, {"Content-Type": "text/html; charset=utf-8"}
+    return jsonify(summary)
+
+
+@app.route("/compare", methods=["GET"])
+@cached
+def compare():
+    today = app.db_reader.local_today()
+    periods = {name: period_summary(app.db_reader, *parse_named_period(name, today)) for name in ("a", "b")}
+
+    a, b = periods["a"], periods["b"]
+    metrics = ("minutes", "plays", "unique_artists", "skip_rate")
+    return jsonify({
+        "a": a,
+        "b": b,
+        "delta": {key: round(b[key] - a[key], 3) for key in metrics},
+        "delta_pct": {key: percent_change(a[key], b[key]) for key in metrics},
+        "top_tracks": list_changes(
+            [f"{row['artist']} - {row['title']}" for row in a["top_tracks"]],
+            [f"{row['artist']} - {row['title']}" for row in b["top_tracks"]],
+        ),
+        "top_artists": list_changes(
+            [row["artist"] for row in a["top_artists"]],
+            [row["artist"] for row in b["top_artists"]],
+        ),
+        "top_genres": list_changes(
+            [row["genre"] for row in a["top_genres"]],
+            [row["genre"] for row in b["top_genres"]],
+        ),
+    })
+
+
+@app.route("/similarity", methods=["GET"])
+@cached
+def similarity():
+    today = app.db_reader.local_today()
+    limit = parse_int_param("limit", default=10, minimum=1)
+    periods = {name: parse_named_period(name, today) for name in ("a", "b")}
+
+    # Every track played in the period, most played first.
+    tracks = {name: {row["track_id"]: row for row in app.db_reader.top_tracks(*window, limit=None)}
+              for name, window in periods.items()}
+    a, b = tracks["a"], tracks["b"]
+    score = jaccard_similarity(set(a), set(b))
+
+    common = [{"track_id": track_id, "title": a[track_id]["title"], "artist": a[track_id]["artist"],
+               "plays_a": a[track_id]["plays"], "plays_b": b[track_id]["plays"]}
+              for track_id in a.keys() & b.keys()]
+    common.sort(key=lambda row: (-(row["plays_a"] + row["plays_b"]), row["title"]))
+    only_a = [row for track_id, row in a.items() if track_id not in b]
+    only_b = [row for track_id, row in b.items() if track_id not in a]
+
+    return jsonify({
+        "a": {"from": periods["a"][0].isoformat(), "to": periods["a"][1].isoformat(), "tracks": len(a)},
+        "b": {"from": periods["b"][0].isoformat(), "to": periods["b"][1].isoformat(), "tracks": len(b)},
+        "similarity": None if score is None else round(score, 3),
+        "common": {"count": len(common), "tracks": common[:limit]},
+        "only_a": {"count": len(only_a), "tracks": only_a[:limit]},
+        "only_b": {"count": len(only_b), "tracks": only_b[:limit]},
+    })
+
+
+@app.route("/dashboard", methods=["GET"])
+@cached
+def dashboard():
+    date_from, date_to = parse_window()
+    limit = parse_int_param("limit", default=10, minimum=1)
+
+    # Keep the daily series bounded when no window is given.
+    date_to = date_to or app.db_reader.local_today()
+    date_from = date_from or date_to - timedelta(days=29)
+    if date_from > date_to:
+        raise InvalidParameter("from must not be after to")
+
+    result = build_dashboard(app.db_pool, date_from, date_to, limit, current_min_play_ms())
+
+    return jsonify({"from": date_from.isoformat(), "to": date_to.isoformat(), **result})
+
+
+@app.route("/query", methods=["POST"])
+def query():
+    # Arbitrary SQL is only accepted from key holders, on a role that cannot write.
+    if not (DATABASE_URL_READONLY and STATS_API_KEY):
+        return {"error": "ad-hoc queries are disabled"}, 404
+
+    sql = (request.get_json(silent=True) or {}).get("sql")
+
+    if not sql:
+        return {"error": "sql missing"}, 400
+
+    if not is_single_select(sql):
+        return {"error": "only a single SELECT statement is allowed"}, 400
+
+    if not query_limiter.allow(request.remote_addr):
+        return {"error": f"rate limit of {QUERY_RATE_LIMIT} queries per minute exceeded"}, 429
+
+    try:
+        rows = app.query_reader.run_readonly_query(sql)
+    except psycopg2.Error as e:
+        log.info("Ad-hoc query failed", error=str(e))
+        return {"error": str(e).strip()}, 400
+
+    log.info("Ran ad-hoc query", rows=len(rows))
+    return jsonify(rows)
+
+
+def connection_params() -> dict:
+    """
+    :return: Keyword arguments for psycopg2.connect, from STATS_DATABASE_URL
+        if set, otherwise from the POSTGRES_* settings
+    :rtype: dict
+    """
+    if STATS_DATABASE_URL:
+        return {"dsn": STATS_DATABASE_URL}
+    return DB_CONFIG
+
+
+def create_app():
+    missing = undocumented([rule.rule for rule in app.url_map.iter_rules() if rule.endpoint != "static"])
+    if missing:
+        log.warning("Endpoints missing from the OpenAPI document", routes=missing)
+    if STATS_DATABASE_URL:
+        log.info("Reading from STATS_DATABASE_URL instead of POSTGRES_HOST")
+    conn = wait_for_db(connection_params(), logger=log)
+    conn.autocommit = True
+
+    app.db_reader = DatabaseReader(conn)
+    app.db_pool = ThreadedConnectionPool(
+        1, DASHBOARD_WORKERS, **connection_params(), connect_timeout=DB_CONNECT_TIMEOUT)
+
+    app.query_reader = None
+    if DATABASE_URL_READONLY and STATS_API_KEY:
+        query_conn = wait_for_db({"dsn": DATABASE_URL_READONLY}, logger=log)
+        query_conn.autocommit = True
+        app.query_reader = DatabaseReader(query_conn)
+    elif DATABASE_URL_READONLY:
+        log.warning("POST /query stays disabled until STATS_API_KEY is set")
+
+    return app
+
+app = create_app()
+
+if __name__ == "__main__":
+    app.run(host="0.0.0.0", port=5001, debug=True)
@@ -0,0 +1,67 @@
+from dotenv import load_dotenv
+import os
+
+load_dotenv()
+
+DB_CONFIG = {
+    "host": os.getenv("POSTGRES_HOST", "localhost"),
+    "port": int(os.getenv("POSTGRES_PORT", 5432)),
+    "dbname": os.getenv("POSTGRES_DB"),
+    "user": os.getenv("POSTGRES_USER"),
+    "password": os.getenv("POSTGRES_PASSWORD"),
+}
+
+# Optional libpq connection string, e.g. of a read replica. The stats-api
+# only reads, so when set it is used for every connection instead of the
+# POSTGRES_* settings above.
+STATS_DATABASE_URL = os.getenv("STATS_DATABASE_URL")
+
+USER_TIMEZONE = os.getenv("USER_TIMEZONE", "UTC")
+SESSION_GAP_MINUTES = int(os.getenv("SESSION_GAP_MINUTES", 30))
+# Plays listened to for less than this many milliseconds are left out of
+# every play statistic; ?min_play_ms= overrides it per request (0 = keep all).
+# The daily rollups count every play, so with a nonzero threshold they are
+# not used and per-day totals are summed from track_plays, which is slower
+# over long windows.
+MIN_PLAY_MS = int(os.getenv("MIN_PLAY_MS", 0))
+
+# libpq connection string of a role that may only SELECT. POST /query runs
+# on it, and only exists when both it and STATS_API_KEY are set.
+DATABASE_URL_READONLY = os.getenv("DATABASE_URL_READONLY")
+QUERY_RATE_LIMIT = int(os.getenv("QUERY_RATE_LIMIT", 10))  # per client and minute
+QUERY_TIMEOUT_MS = int(os.getenv("QUERY_TIMEOUT_MS", 5000))
+QUERY_MAX_ROWS = int(os.getenv("QUERY_MAX_ROWS", 1000))
+
+# Sessions need this many consecutive same-album plays to be judged.
+SHUFFLE_MIN_ALBUM_PAIRS = int(os.getenv("SHUFFLE_MIN_ALBUM_PAIRS", 3))
+# Sessions whose shuffle likelihood reaches this are reported as shuffled.
+SHUFFLE_THRESHOLD = float(os.getenv("SHUFFLE_THRESHOLD", 0.5))
+
+# When set, every endpoint except /healthz requires
+# "Authorization: Bearer <STATS_API_KEY>".
+STATS_API_KEY = os.getenv("STATS_API_KEY")
+
+# Seconds a GET response is served from memory; 0 disables the cache.
+RESPONSE_CACHE_TTL = int(os.getenv("RESPONSE_CACHE_TTL", 60))
+RESPONSE_CACHE_MAX_ENTRIES = int(os.getenv("RESPONSE_CACHE_MAX_ENTRIES", 256))
+
+# Buckets with fewer plays get no diversity score.
+DIVERSITY_MIN_PLAYS = int(os.getenv("DIVERSITY_MIN_PLAYS", 50))
+
+# Comma-separated origins allowed to call the API from a browser, e.g.
+# "https://dash.example.com". Empty disables CORS.
+CORS_ALLOWED_ORIGINS = [
+    origin.strip()
+    for origin in os.getenv("CORS_ALLOWED_ORIGINS", "").split(",")
+    if origin.strip()
+]
+
+# Connections available for running dashboard sections in parallel.
+DASHBOARD_WORKERS = int(os.getenv("DASHBOARD_WORKERS", 4))
+
+# Lowest level that is logged (debug, info, warning, error) and the output
+# format: one JSON object per line, or "text" for plain key=value lines.
+LOG_LEVEL = os.getenv("LOG_LEVEL", "info").upper()
+LOG_FORMAT = os.getenv("LOG_FORMAT", "json").lower()
+
+ENVIRONMENT = os.getenv("ENVIRONMENT", "dev")
@@ -0,0 +1,165 @@
+"""
+Rendering of list results as aligned text tables, CSV or Markdown.
+
+Every list endpoint returns rows as dicts; the renderers take the columns
+from the rows themselves, so new endpoints get all formats for free.
+"""
+import csv
+import io
+import unicodedata
+
+FORMATS = ("json", "table", "csv", "markdown")
+
+CONTENT_TYPES = {
+    "table": "text/plain; charset=utf-8",
+    "csv": "text/csv; charset=utf-8",
+    "markdown": "text/markdown; charset=utf-8",
+}
+
+TABLE_MAX_CELL_WIDTH = 40
+
+
+def flatten(row: dict, prefix: str = "") -> dict:
+    """
+    Flatten nested dicts into dotted keys, e.g. {"top_track": {"title": x}}
+    becomes {"top_track.title": x}.
+    """
+    flat = {}
+    for key, value in row.items():
+        name = f"{prefix}{key}"
+        if isinstance(value, dict):
+            flat.update(flatten(value, f"{name}."))
+        else:
+            flat[name] = value
+    return flat
+
+
+def columns_of(rows: list[dict]) -> list[str]:
+    """
+    All keys of the rows in order of first appearance.
+    """
+    columns = {}
+    for row in rows:
+        columns.update(dict.fromkeys(row))
+    return list(columns)
+
+
+def cell_text(value) -> str:
+    if value is None:
+        return ""
+    if isinstance(value, bool):
+        return "true" if value else "false"
+    if isinstance(value, (list, tuple)):
+        return ", ".join(cell_text(item) for item in value)
+    return str(value)
+
+
+def display_width(text: str) -> int:
+    """
+    Number of terminal columns text occupies: wide East Asian characters
+    take two, combining marks none.
+    """
+    width = 0
+    for char in text:
+        if unicodedata.combining(char):
+            continue
+        width += 2 if unicodedata.east_asian_width(char) in ("W", "F") else 1
+    return width
+
+
+def truncate(text: str, max_width: int) -> str:
+    """
+    Shorten text to at most max_width columns, ending in "…" if cut.
+    """
+    if display_width(text) <= max_width:
+        return text
+    result, width = "", 0
+    for char in text:
+        char_width = display_width(char)
+        if width + char_width > max_width - 1:
+            break
+        result += char
+        width += char_width
+    return result + "…"
+
+
+def _pad(text: str, width: int, right: bool) -> str:
+    padding = " " * (width - display_width(text))
+    return padding + text if right else text + padding
+
+
+def render_table(columns: list[str], rows: list[dict], max_cell_width: int = TABLE_MAX_CELL_WIDTH) -> str:
+    """
+    Render rows as a text table. Numeric columns are right-aligned and
+    cells longer than max_cell_width are truncated.
+    """
+    if not columns:
+        return "(no rows)\n"
+
+    cells = [
+        [truncate(" ".join(cell_text(row.get(column)).split()), max_cell_width) for column in columns]
+        for row in rows
+    ]
+    widths = [
+        max([display_width(column)] + [display_width(line[i]) for line in cells])
+        for i, column in enumerate(columns)
+    ]
+    numeric = [
+        all(isinstance(row.get(column), (int, float)) and not isinstance(row.get(column), bool)
+            for row in rows if row.get(column) is not None)
+        for column in columns
+    ]
+
+    lines = [
+        "  ".join(_pad(column, widths[i], numeric[i]) for i, column in enumerate(columns)).rstrip(),
+        "  ".join("-" * width for width in widths),
+    ]
+    for line in cells:
+        lines.append("  ".join(_pad(cell, widths[i], numeric[i]) for i, cell in enumerate(line)).rstrip())
+    return "\n".join(lines) + "\n"
+
+
+def render_csv(columns: list[str], rows: list[dict]) -> str:
+    out = io.StringIO()
+    writer = csv.writer(out, lineterminator="\n")
+    writer.writerow(columns)
+    for row in rows:
+        writer.writerow([cell_text(row.get(column)) for column in columns])
+    return out.getvalue()
+
+
+def _markdown_cell(text: str) -> str:
+    return text.replace("\\", "\\\\").replace("|", "\\|").replace("\r", " ").replace("\n", " ")
+
+
+def render_markdown(columns: list[str], rows: list[dict]) -> str:
+    """
+    Render rows as a GitHub-flavored Markdown table.
+    """
+    lines = [
+        "| " + " | ".join(_markdown_cell(column) for column in columns) + " |",
+        "|" + "|".join(" --- " for _ in columns) + "|",
+    ]
+    for row in rows:
+        lines.append("| " + " | ".join(_markdown_cell(cell_text(row.get(column))) for column in columns) + " |")
+    return "\n".join(lines) + "\n"
+
+
+RENDERERS = {
+    "table": render_table,
+    "csv": render_csv,
+    "markdown": render_markdown,
+}
+
+
+def render(fmt: str, rows: list[dict]) -> str:
+    """
+    Render rows in one of the text formats.
+
+    :param fmt: "table", "csv" or "markdown"
+    :param rows: Result rows; nested dicts are flattened into dotted columns
+    :return: Rendered text
+    :rtype: str
+    """
+    flat = [flatten(row) for row in rows]
+    return RENDERERS[fmt](columns_of(flat), flat)
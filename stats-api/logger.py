@@ -0,0 +1,26 @@
+"""
+Logger setup for stats-api.
+"""
+import sys
+import logging
+import structlog
+from config import ENVIRONMENT, LOG_FORMAT, LOG_LEVEL
+
+logging.basicConfig(
+    format="%(message)s",
+    stream=sys.stdout,
+    level=LOG_LEVEL,
+)
+
+structlog.configure(
+    processors=[
+        structlog.stdlib.filter_by_level,
+        structlog.processors.TimeStamper(fmt="iso", key="ts"),
+        structlog.processors.add_log_level,
+        structlog.dev.ConsoleRenderer(colors=False) if LOG_FORMAT == "text"
+        else structlog.processors.JSONRenderer(),
+    ],
+    logger_factory=structlog.stdlib.LoggerFactory(),
+)
+
+log = structlog.get_logger(service=f"stats-api-{ENVIRONMENT}")
@@ -0,0 +1,442 @@
+"""
+OpenAPI description of the stats endpoints, served on GET /openapi.json.
+
+The document is maintained by hand next to the endpoints. `undocumented`
+lists registered routes that are missing from it, and create_app logs them
+at startup, so a new endpoint without an entry here shows up right away.
+"""
+from config import DIVERSITY_MIN_PLAYS, MIN_PLAY_MS, SESSION_GAP_MINUTES, SHUFFLE_MIN_ALBUM_PAIRS
+
+INT = {"type": "integer"}
+NUM = {"type": "number"}
+STR = {"type": "string"}
+BOOL = {"type": "boolean"}
+DATE = {"type": "string", "format": "date"}
+DATETIME = {"type": "string", "format": "date-time"}
+
+
+def _nullable(schema: dict) -> dict:
+    return {**schema, "nullable": True}
+
+
+def _obj(**properties) -> dict:
+    return {"type": "object", "properties": properties}
+
+
+def _list(items: dict) -> dict:
+    return {"type": "array", "items": items}
+
+
+def _param(name: str, schema: dict, description: str, default=None, enum=None, required: bool = False,
+           location: str = "query") -> dict:
+    schema = dict(schema)
+    if default is not None:
+        schema["default"] = default
+    if enum:
+        schema["enum"] = list(enum)
+    return {"name": name, "in": location, "required": required, "description": description, "schema": schema}
+
+
+WINDOW_DATES = [
+    _param("from", DATE, "First local day of the window, inclusive"),
+    _param("to", DATE, "Last local day of the window, inclusive"),
+]
+MIN_PLAY = _param("min_play_ms", INT, "Leave out plays listened to for less than this many milliseconds",
+                  MIN_PLAY_MS)
+WINDOW = WINDOW_DATES + [MIN_PLAY]
+SINCE_DESCRIPTION = "Relative window ending today, in days, weeks or years (e.g. 90d, 12w, 1y)"
+LIMIT_DESCRIPTION = "Maximum number of entries"
+
+
+def _since(default: str) -> dict:
+    return _param("since", STR, SINCE_DESCRIPTION, default)
+
+
+def _limit(default: int) -> dict:
+    return _param("limit", INT, LIMIT_DESCRIPTION, default)
+
+
+# ?format= of endpoints that return a list, which then replaces the JSON body.
+LIST_FORMAT = _param("format", STR, "json, or only the list as an aligned text table, CSV or Markdown",
+                     "json", ("json", "table", "csv", "markdown"))
+
+PERIODS = [
+    _param("a", STR, "First period: a month, a quarter (2024-Q1), a day, a range (2024-01..2024-06) "
+                     "or e.g. previous-30d; also accepted as period_a"),
+    _param("b", STR, "Second period, like a; also accepted as period_b"),
+    _param("period_a_from", DATE, "First day of period a, instead of a"),
+    _param("period_a_to", DATE, "Last day of period a, instead of a"),
+    _param("period_b_from", DATE, "First day of period b, instead of b"),
+    _param("period_b_to", DATE, "Last day of period b, instead of b"),
+]
+
+TRACK = _obj(track_id=INT, title=STR, artist=_nullable(STR), plays=INT, minutes=NUM)
+ARTIST = _obj(artist_id=INT, artist=STR, plays=INT, minutes=NUM)
+GENRE = _obj(genre=STR, plays=INT)
+TOTALS = _obj(plays=INT, skips=INT, minutes=NUM, unique_tracks=INT, unique_artists=INT)
+STREAKS = _obj(
+    current_streak=INT,
+    longest_streak=_nullable(_obj(length=INT, **{"from": DATE, "to": DATE})),
+    active_days=INT,
+)
+PERIOD = _obj(
+    minutes=NUM, plays=INT, unique_artists=INT, skip_rate=NUM,
+    top_tracks=_list(TRACK), top_artists=_list(ARTIST), top_genres=_list(_obj(genre=STR, plays=INT, share=NUM)),
+    **{"from": DATE, "to": DATE},
+)
+LIST_CHANGES = _obj(new=_list(STR), dropped=_list(STR))
+METRIC_DELTAS = _obj(minutes=_nullable(NUM), plays=_nullable(NUM), unique_artists=_nullable(NUM),
+                     skip_rate=_nullable(NUM))
+ERROR = _obj(error=STR)
+
+
+def _get(summary: str, response: dict, parameters: list = (), text: bool = False, html: bool = False,
+         errors: tuple = ("400",)) -> dict:
+    content = {"application/json": {"schema": response}}
+    if text:
+        content["text/plain"] = {"schema": STR}
+    if html:
+        content["text/html"] = {"schema": STR}
+    responses = {"200": {"description": "OK", "content": content}}
+    for status in errors:
+        responses[status] = {"description": ERROR_DESCRIPTIONS[status],
+                             "content": {"application/json": {"schema": ERROR}}}
+    return {"get": {"summary": summary, "parameters": list(parameters), "responses": responses}}
+
+
+ERROR_DESCRIPTIONS = {
+    "400": "Invalid parameter",
+    "404": "Not found",
+    "409": "Several matches",
+    "429": "Rate limit exceeded",
+}
+
+PATHS = {
+    "/healthz": _get("Liveness check; never requires an API key", _obj(status=STR), errors=()),
+    "/by-weekday": _get(
+        "Plays and minutes per day of the week",
+        _obj(timezone=STR, weekdays=_list(_obj(weekday=STR, plays=INT, minutes=NUM)),
+             **{"from": _nullable(DATE), "to": _nullable(DATE)}),
+        WINDOW + [LIST_FORMAT]),
+    "/streaks": _get(
+        "Current and longest run of consecutive listening days",
+        _obj(timezone=STR, count_skipped=BOOL, **STREAKS["properties"]),
+        WINDOW + [_param("count_skipped", BOOL, "Count days with only skipped plays", False)]),
+    "/binges": _get(
+        "Runs of back-to-back plays of the same track",
+        _obj(binges=_list(_obj(track_id=INT, title=STR, artist=_nullable(STR), count=INT,
+                               started_at=DATETIME, ended_at=DATETIME))),
+        WINDOW + [
+            _param("min_count", INT, "Minimum plays in a run", 3),
+            _param("slack", INT, "Seconds a play may start after the previous one ended", 30),
+            LIST_FORMAT,
+        ]),
+    "/rabbit-holes": _get(
+        "Stretches of listening to one artist",
+        _obj(min_plays=INT, max_detour=INT,
+             rabbit_holes=_list(_obj(artist_id=INT, artist=STR, plays=INT, tracks=INT, started_at=DATETIME,
+                                     ended_at=DATETIME, still_active=BOOL))),
+        WINDOW + [
+            _param("min_plays", INT, "Minimum unskipped plays of the artist", 5),
+            _param("max_detour", INT, "Plays of other artists allowed between two plays", 1),
+            _limit(50),
+            LIST_FORMAT,
+        ]),
+    "/rabbit-holes/recorded": _get(
+        "Rabbit holes recorded by the tracker as plays came in",
+        _obj(rabbit_holes=_list(_obj(rabbit_hole_id=STR, username=STR, artist_id=INT, artist=STR, plays=INT,
+                                     tracks=INT, started_at=DATETIME, ended_at=DATETIME, still_active=BOOL))),
+        [
+            _param("active", BOOL, "Only the rabbit holes still going on", False),
+            _limit(20),
+            LIST_FORMAT,
+        ]),
+    "/binge-days": _get(
+        "Days on which one track or artist was played many times",
+        _obj(by=STR, since_days=INT, min_repeats=INT,
+             days=_list(_obj(day=DATE, track_id=INT, title=STR, artist_id=INT, artist=STR, plays=INT,
+                             minutes=NUM))),
+        [
+            _param("by", STR, "Group by track or artist", "track", ("track", "artist")),
+            _since("1y"),
+            _param("min_repeats", INT, "Minimum plays on one day", 5),
+            _limit(50),
+            LIST_FORMAT,
+        ]),
+    "/binge-sessions": _get(
+        "Most recent binge sessions recorded by the tracker",
+        _obj(sessions=_list(_obj(session_id=INT, username=STR, started_at=DATETIME, ended_at=DATETIME,
+                                 plays=INT, minutes=NUM, tracks=_list(_obj())))),
+        [_limit(10), LIST_FORMAT]),
+    "/favorites": _get(
+        "Current favorite tracks and artists, weighted towards recent plays",
+        _obj(half_life_days=INT,
+             tracks=_list(_obj(track_id=INT, title=STR, artist=_nullable(STR), score=NUM, plays=INT)),
+             artists=_list(_obj(artist_id=INT, artist=STR, score=NUM, plays=INT))),
+        [
+            _param("half_life", STR, "Age at which a play counts half", "30d"),
+            _limit(25),
+            _param("by", STR, "List rendered by text formats", "track", ("track", "artist")),
+            LIST_FORMAT,
+        ]),
+    "/forgotten": _get(
+        "Much played tracks or artists that have not been played for a while",
+        _obj(by=STR, min_plays=INT, quiet_for_days=INT,
+             items=_list(_obj(track_id=INT, title=STR, artist_id=INT, artist=STR, plays=INT,
+                              last_played_at=DATETIME))),
+        [
+            _param("by", STR, "Tracks or artists", "track", ("track", "artist")),
+            _param("min_plays", INT, "Minimum plays overall", 20),
+            _param("quiet_for", STR, "Time without a play", "180d"),
+            _param("exclude_active_artists", BOOL, "Leave out tracks of artists still played", False),
+            _param("active_plays", INT, "Plays within quiet_for that make an artist active", 10),
+            _limit(50),
+            LIST_FORMAT,
+        ]),
+    "/skips": _get(
+        "Tracks or artists by skip rate",
+        _obj(by=STR, since_days=INT, min_plays=INT, weighted=BOOL,
+             items=_list(_obj(track_id=INT, title=STR, artist_id=INT, artist=STR, plays=INT, skips=INT,
+                              skip_rate=NUM, skip_score_sum=NUM, avg_skip_score=_nullable(NUM)))),
+        [
+            _param("by", STR, "Tracks or artists", "track", ("track", "artist")),
+            _since("90d"),
+            _param("min_plays", INT, "Minimum evaluated plays", 5),
+            _limit(50),
+            _param("weighted", BOOL, "Rank by average skip score instead of skip rate", False),
+            LIST_FORMAT,
+        ]),
+    "/heatmap": _get(
+        "Listening minutes per weekday and hour",
+        _obj(since_days=INT, timezone=STR, weekdays=_list(STR), minutes=_list(_list(NUM))),
+        [_since("90d"), _param("format", STR, "JSON or a shaded text grid", "json", ("json", "text"))],
+        text=True),
+    "/discoveries": _get(
+        "Artists and tracks played for the first time, per bucket",
+        _obj(granularity=STR,
+             buckets=_list(_obj(bucket=DATE, new_artists=INT, new_tracks=INT, top_new_artist=_nullable(STR),
+                                artist_names=_list(STR)))),
+        WINDOW_DATES + [
+            _param("granularity", STR, "Bucket size", "month", ("week", "month", "year")),
+            _param("list", BOOL, "Add the names of the new artists", False),
+            LIST_FORMAT,
+        ]),
+    "/discoveries/monthly": _get(
+        "New artists, tracks and genres per calendar month",
+        _obj(months=_list(_obj(month=STR, new_artists=INT, new_tracks=INT, new_genres=INT))),
+        [_param("months", INT, "Number of months", 12), LIST_FORMAT]),
+    "/sessions": _get(
+        f"Listening session statistics; sessions end after {SESSION_GAP_MINUTES} minutes without a play",
+        _obj(since_days=INT, session_gap_minutes=INT, sessions=INT, avg_minutes=_nullable(NUM),
+             median_minutes=_nullable(NUM), avg_tracks=_nullable(NUM), median_tracks=_nullable(NUM),
+             distribution=_obj(under_15m=INT, **{"15m_to_1h": INT, "1h_to_3h": INT}, over_3h=INT),
+             longest=_nullable(_obj(day=DATE, started_at=DATETIME, ended_at=DATETIME, minutes=NUM, tracks=INT))),
+        [_since("90d"), MIN_PLAY]),
+    "/diversity": _get(
+        "How evenly listening time is spread across genres or artists, per bucket",
+        _obj(granularity=STR, by=STR, min_plays=INT,
+             buckets=_list(_obj(bucket=DATE, plays=INT, genres=INT, artists=INT, entropy=_nullable(NUM),
+                                gini_simpson=_nullable(NUM), top_genre=_nullable(STR), top_genre_share=_nullable(NUM),
+                                top_artist=_nullable(STR), top_artist_share=_nullable(NUM), low_confidence=BOOL))),
+        WINDOW + [
+            _param("granularity", STR, "Bucket size", "month", ("week", "month", "year")),
+            _param("by", STR, "Spread across genres or artists", "genre", ("genre", "artist")),
+            _param("min_plays", INT, "Plays a bucket needs to get scores", DIVERSITY_MIN_PLAYS),
+            _param("format", STR, "JSON or a text table", "json", ("json", "text")),
+        ],
+        text=True),
+    "/on-this-day": _get(
+        "Listening on this calendar date in previous years",
+        _obj(date=STR, timezone=STR, years_with_data=INT, tracking_since=_nullable(DATE),
+             years=_list(_obj(date=DATE, plays=INT, minutes=NUM,
+                              top_track=_nullable(_obj(title=STR, artist=_nullable(STR), plays=INT)),
+                              top_artist=_nullable(_obj(artist=STR, plays=INT)),
+                              tracks=_list(_obj(track_id=INT, title=STR, artist=_nullable(STR), plays=INT,
+                                                skips=INT, first_played_at=DATETIME))))),
+        [_param("date", STR, "Calendar date as MM-DD (default today)"), LIST_FORMAT]),
+    "/shuffle": _get(
+        "Whether albums were played on shuffle, per session",
+        _obj(min_pairs=INT, threshold=NUM,
+             sessions=_list(_obj(started_at=DATETIME, ended_at=DATETIME, tracks=INT, album_pairs=INT,
+                                 in_order_pairs=INT, shuffle_likelihood=_nullable(NUM), shuffled=_nullable(BOOL)))),
+        WINDOW + [
+            _param("min_pairs", INT, "Same-album transitions a session needs", SHUFFLE_MIN_ALBUM_PAIRS),
+            LIST_FORMAT,
+        ]),
+    "/artist-leaderboard": _get(
+        "Artists ranked by total listening time",
+        _obj(artists=_list(_obj(rank=INT, artist_id=INT, artist=STR, total_ms=INT, plays=INT))),
+        [_limit(50), LIST_FORMAT]),
+    "/devices": _get(
+        "Plays, minutes and share of listening time per player",
+        _obj(devices=_list(_obj(device=STR, plays=INT, minutes=NUM, share=NUM))),
+        WINDOW + [LIST_FORMAT]),
+    "/rediscoveries": _get(
+        "Plays of tracks that had not been played for a long time",
+        _obj(since_days=INT,
+             rediscoveries=_list(_obj(track_play_id=INT, track_id=INT, title=STR, artist=_nullable(STR),
+                                      played_at=DATETIME, days_since_last_play=INT))),
+        [_since("30d"), _limit(50), LIST_FORMAT]),
+    "/history": _get(
+        "Plays in the order they were stored, a page at a time",
+        _obj(plays=_list(_obj(track_play_id=INT, played_at=DATETIME, track_id=INT, title=STR,
+                              artist=_nullable(STR), username=STR, device_name=_nullable(STR),
+                              skipped=BOOL, skip_score=_nullable(NUM))),
+             next_cursor=_nullable(INT), page=INT, page_size=INT),
+        WINDOW + [
+            _param("after_id", INT, "Only plays after this track_play_id; pass the previous next_cursor", 0),
+            _param("limit", INT, "Plays per page, at most 500", 50),
+            _param("page", INT, "Page number, instead of after_id; slower for later pages"),
+            _param("page_size", INT, "Plays per page with page, at most 500", 50),
+            LIST_FORMAT,
+        ]),
+    "/top-albums": _get(
+        "Albums by time listened, plays or skip rate",
+        _obj(sort=STR,
+             albums=_list(_obj(album_id=INT, album=STR, artist=_nullable(STR), plays=INT, minutes=NUM,
+                               skip_rate=NUM, most_played_track=_obj(), least_played_track=_obj()))),
+        WINDOW + [
+            _param("sort", STR, "Ranking", "minutes", ("minutes", "plays", "skip_rate")),
+            _limit(10),
+            LIST_FORMAT,
+        ]),
+    "/artist": _get(
+        "Drill-down for one artist, by name or id",
+        _obj(artist_id=INT, artist=STR, plays=INT, minutes=NUM, skip_rate=_nullable(NUM),
+             first_played=_nullable(DATETIME), last_played=_nullable(DATETIME), top_tracks=_list(TRACK),
+             granularity=STR, timeline=_list(_obj(bucket=DATE, plays=INT, minutes=NUM))),
+        WINDOW + [
+            _param("name", STR, "Artist name, matched case-insensitively"),
+            _param("id", INT, "Artist id, instead of name"),
+            _param("granularity", STR, "Timeline bucket size", "month", ("week", "month")),
+            LIST_FORMAT,
+        ],
+        errors=("400", "404", "409")),
+    "/artists/{artist_id}/tracks": _get(
+        "Every track of one artist played in the window",
+        _obj(artist_id=INT, artist=STR,
+             tracks=_list(_obj(track_id=INT, title=STR, plays=INT, minutes=NUM, skips=INT,
+                               first_played=DATETIME, last_played=DATETIME))),
+        [_param("artist_id", INT, "Artist id", location="path", required=True)] + WINDOW + [LIST_FORMAT],
+        errors=("400", "404")),
+    "/cross-platform": _get(
+        "Plays per source: Navidrome and imported libraries",
+        _obj(sources=_list(_obj(source=STR, plays=INT, tracks=INT, matched_tracks=_nullable(INT),
+                                matched_plays=_nullable(INT), last_played=_nullable(DATETIME)))),
+        [LIST_FORMAT]),
+    "/completion": _get(
+        "How much of a track is listened to",
+        _obj(since_days=INT, plays=INT, unscored_plays=INT,
+             percentiles=_obj(p10=_nullable(NUM), p25=_nullable(NUM), p50=_nullable(NUM), p75=_nullable(NUM),
+                              p90=_nullable(NUM)),
+             histogram=_list(_obj(range=STR, plays=INT, bar=STR))),
+        [_since("90d"), LIST_FORMAT]),
+    "/genre-trends": _get(
+        "Share of plays per bucket for the top genres",
+        _obj(bucket=STR, genres=_list(STR),
+             buckets=_list(_obj(bucket=DATE, plays=NUM,
+                                shares={"type": "object", "additionalProperties": NUM}))),
+        WINDOW + [
+            _param("bucket", STR, "Bucket size", "month", ("week", "month")),
+            _param("top", INT, "Genres listed by name; the rest is other", 5),
+            LIST_FORMAT,
+        ]),
+    "/wrapped": _get(
+        "Yearly summary",
+        _obj(year=INT, timezone=STR, total_minutes=NUM, total_plays=INT, unique_tracks=INT, unique_artists=INT,
+             top_artists=_list(ARTIST), top_tracks=_list(TRACK), top_genre=_nullable(STR), top_genres=_list(STR),
+             most_skipped_track=_nullable(_obj()), most_skipped_artist=_nullable(_obj()),
+             busiest_weekday=_nullable(_obj(weekday=STR, plays=INT, minutes=NUM)),
+             busiest_hour=_nullable(_obj(hour=INT, plays=INT, minutes=NUM)),
+             genre_diversity=_nullable(NUM), longest_session=_nullable(_obj()), busiest_day=_nullable(_obj()),
+             streaks=STREAKS),
+        [
+            _param("year", INT, "Calendar year (default the current one)"),
+            MIN_PLAY,
+            _param("format", STR, "JSON, plain text or a standalone page", "json", ("json", "text", "html")),
+        ],
+        text=True, html=True),
+    "/compare": _get(
+        "Compare two periods",
+        _obj(a=PERIOD, b=PERIOD, delta=METRIC_DELTAS, delta_pct=METRIC_DELTAS,
+             top_tracks=LIST_CHANGES, top_artists=LIST_CHANGES, top_genres=LIST_CHANGES),
+        PERIODS + [MIN_PLAY]),
+    "/similarity": _get(
+        "Jaccard similarity of the tracks played in two periods",
+        _obj(a=_obj(**{"from": DATE, "to": DATE}, tracks=INT), b=_obj(**{"from": DATE, "to": DATE}, tracks=INT),
+             similarity=_nullable(NUM),
+             common=_obj(count=INT, tracks=_list(_obj(track_id=INT, title=STR, artist=_nullable(STR),
+                                                      plays_a=INT, plays_b=INT))),
+             only_a=_obj(count=INT, tracks=_list(TRACK)),
+             only_b=_obj(count=INT, tracks=_list(TRACK))),
+        PERIODS + [_limit(10), MIN_PLAY]),
+    "/dashboard": _get(
+        "Top lists, daily series and totals in one response (default the last 30 days)",
+        _obj(top_tracks=_list(TRACK), top_artists=_list(ARTIST), top_genres=_list(GENRE),
+             daily=_list(_obj(day=DATE, plays=INT, skips=INT, minutes=NUM)), totals=TOTALS,
+             skip_rate=NUM, **{"from": DATE, "to": DATE}),
+        WINDOW + [_limit(10)]),
+    "/query": {"post": {
+        "summary": "Run one ad-hoc SELECT in a read-only transaction as the DATABASE_URL_READONLY role",
+        "requestBody": {"required": True, "content": {"application/json": {"schema": _obj(sql=STR)}}},
+        "responses": {
+            "200": {"description": "The rows",
+                    "content": {"application/json": {"schema": _list({"type": "object"})}}},
+            "400": {"description": "Missing, invalid or failing statement",
+                    "content": {"application/json": {"schema": ERROR}}},
+            "404": {"description": "Disabled, because DATABASE_URL_READONLY or STATS_API_KEY is not set",
+                    "content": {"application/json": {"schema": ERROR}}},
+            "429": {"description": ERROR_DESCRIPTIONS["429"],
+                    "content": {"application/json": {"schema": ERROR}}},
+        },
+    }},
+    "/openapi.json": _get("This document; never requires an API key", {"type": "object"}, errors=()),
+}
+
+# Paths that can be called without the API key.
+PUBLIC_PATHS = ("/healthz", "/openapi.json")
+
+
+def document() -> dict:
+    """
+    :return: The OpenAPI 3.0 document for all endpoints
+    :rtype: dict
+    """
+    paths = {}
+    for path, operations in PATHS.items():
+        paths[path] = {
+            method: operation if path in PUBLIC_PATHS else {**operation, "security": [{"bearerAuth": []}]}
+            for method, operation in operations.items()
+        }
+
+    return {
+        "openapi": "3.0.3",
+        "info": {
+            "title": "Music Analytics stats API",
+            "version": "1",
+            "description": (
+                "Listening statistics from the track_plays history. Days are local days of USER_TIMEZONE. "
+                "Responses of GET endpoints are cached; add nocache=1 to bypass the cache. "
+                "The bearer token is only required when STATS_API_KEY is set."
+            ),
+        },
+        "paths": paths,
+        "components": {"securitySchemes": {"bearerAuth": {"type": "http", "scheme": "bearer"}}},
+    }
+
+
+def undocumented(rules: list[str]) -> list[str]:
+    """
+    :param rules: Flask URL rules, e.g. "/artists/<int:artist_id>/tracks"
+    :return: The rules without an entry in PATHS
+    :rtype: list[str]
+    """
+    def as_path(rule: str) -> str:
+        parts = []
+        for part in rule.split("/"):
+            if part.startswith("<") and part.endswith(">"):
+                part = "{" + part[1:-1].split(":")[-1] + "}"
+            parts.append(part)
+        return "/".join(parts)
+
+    return [rule for rule in rules if as_path(rule) not in PATHS]
@@ -0,0 +1,166 @@
+"""
+Text and HTML rendering for summary reports.
+"""
+from html import escape
+
+
+def _track_label(track: dict) -> str:
+    return f"{track['artist'] or 'Unknown artist'} - {track['title']}"
+
+
+def _wrapped_lines(summary: dict) -> list[tuple[str, str]]:
+    """
+    Flatten the headline figures of a Wrapped summary into (label, value) pairs.
+    """
+    streaks = summary["streaks"]
+    longest_streak = streaks["longest_streak"]
+    session = summary["longest_session"]
+    day = summary["busiest_day"]
+    skipped = summary["most_skipped_track"]
+    skipped_artist = summary["most_skipped_artist"]
+    weekday = summary["busiest_weekday"]
+    hour = summary["busiest_hour"]
+    diversity = summary["genre_diversity"]
+
+    return [
+        ("Minutes listened", f"{summary['total_minutes']:,.0f}"),
+        ("Plays", f"{summary['total_plays']:,}"),
+        ("Unique tracks", f"{summary['unique_tracks']:,}"),
+        ("Unique artists", f"{summary['unique_artists']:,}"),
+        ("Top genres", ", ".join(summary["top_genres"]) or "-"),
+        ("Genre diversity", f"{diversity:.2f} bits" if diversity is not None else "-"),
+        ("Most skipped", f"{_track_label(skipped)} ({skipped['skips']}x)" if skipped else "-"),
+        ("Most skipped artist",
+         f"{skipped_artist['artist']} ({skipped_artist['skips']}x)" if skipped_artist else "-"),
+        ("Busiest weekday", f"{weekday['weekday']} ({weekday['minutes']:.0f} min)" if weekday else "-"),
+        ("Busiest hour", f"{hour['hour']:02d}:00 ({hour['minutes']:.0f} min)" if hour else "-"),
+        ("Longest session",
+         f"{session['minutes']:.0f} min, {session['tracks']} tracks on {session['day']}" if session else "-"),
+        ("Busiest day", f"{day['day']} ({day['minutes']:.0f} min)" if day else "-"),
+        ("Longest streak",
+         f"{longest_streak['length']} days ({longest_streak['from']} - {longest_streak['to']})"
+         if longest_streak else "-"),
+        ("Active days", str(streaks["active_days"])),
+    ]
+
+
+def render_wrapped_text(summary: dict) -> str:
+    lines = [f"Your {summary['year']} in music", ""]
+
+    for label, value in _wrapped_lines(summary):
+        lines.append(f"{label + ':':<22}{value}")
+
+    lines += ["", "Top artists"]
+    for i, artist in enumerate(summary["top_artists"], start=1):
+        lines.append(f"  {i}. {artist['artist']} ({artist['plays']} plays)")
+
+    lines += ["", "Top tracks"]
+    for i, track in enumerate(summary["top_tracks"], start=1):
+        lines.append(f"  {i}. {_track_label(track)} ({track['plays']} plays)")
+
+    return "\n".join(lines) + "\n"
+
+
+def render_wrapped_html(summary: dict) -> str:
+    """
+    Render a Wrapped summary as a standalone page with inline styling.
+    """
+    facts = "".join(
+        f'<div style="background:#1f2937;border-radius:12px;padding:16px">'
+        f'<div style="color:#9ca3af;font-size:13px">{escape(label)}</div>'
+        f'<div style="font-size:20px;font-weight:600;margin-top:4px">{escape(value)}</div></div>'
+        for label, value in _wrapped_lines(summary)
+    )
+    artists = "".join(
+        f"<li>{escape(a['artist'])} <span style=\"color:#9ca3af\">{a['plays']} plays</span></li>"
+        for a in summary["top_artists"]
+    )
+    tracks = "".join(
+        f"<li>{escape(_track_label(t))} <span style=\"color:#9ca3af\">{t['plays']} plays</span></li>"
+        for t in summary["top_tracks"]
+    )
+
+    return f"""<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{summary['year']} in music</title>
+</head>
+<body style="margin:0;background:#111827;color:#f9fafb;font-family:system-ui,sans-serif">
+<main style="max-width:760px;margin:0 auto;padding:40px 20px">
+<h1 style="font-size:40px;margin:0 0 24px">Your {summary['year']} in music</h1>
+<section style="display:grid;grid-template-columns:repeat(auto-fill,minmax(220px,1fr));gap:12px">{facts}</section>
+<h2 style="margin-top:32px">Top artists</h2>
+<ol style="line-height:1.8">{artists}</ol>
+<h2>Top tracks</h2>
+<ol style="line-height:1.8">{tracks}</ol>
+</main>
+</body>
+</html>
+"""
+
+
+def render_diversity_table(buckets: list[dict], by: str = "genre") -> str:
+    """
+    Render genre or artist diversity buckets as an aligned text table.
+    """
+    lines = [f"{'bucket':<12}{'plays':>7}{by + 's':>8}{'entropy':>9}{'gini':>7}  top {by}"]
+
+    for bucket in buckets:
+        entropy = "low" if bucket["low_confidence"] else f"{bucket['entropy']:.2f}"
+        gini = "low" if bucket["low_confidence"] else f"{bucket['gini_simpson']:.2f}"
+        top = "-"
+        if bucket[f"top_{by}"]:
+            top = f"{bucket[f'top_{by}']} ({bucket[f'top_{by}_share']:.0%})"
+        lines.append(
+            f"{bucket['bucket']:<12}{bucket['plays']:>7}{bucket[by + 's']:>8}{entropy:>9}{gini:>7}  {top}"
+        )
+
+    return "\n".join(lines) + "\n"
+
+
+def _columns(rows: list[tuple], right: tuple[int, ...] = ()) -> list[str]:
+    """
+    Pad the cells of rows to a common width per column, right-aligning the
+    columns whose index is in right.
+    """
+    widths = [max(len(row[i]) for row in rows) for i in range(len(rows[0]))] if rows else []
+    return [
+        "  ".join(cell.rjust(widths[i]) if i in right else cell.ljust(widths[i])
+                  for i, cell in enumerate(row)).rstrip()
+        for row in rows
+    ]
+
+
+def render_summary_text(summary: dict) -> str:
+    """
+    Render a period summary (see period_summary) as a plain-text report for
+    the terminal.
+    """
+    hours, minutes = divmod(round(summary["minutes"]), 60)
+    lines = [f"Listening from {summary['from']} to {summary['to']}", ""]
+    lines += _columns([
+        ("Time listened:", f"{hours}h {minutes:02d}m"),
+        ("Plays:", f"{summary['plays']:,}"),
+        ("Unique artists:", f"{summary['unique_artists']:,}"),
+        ("Skip rate:", f"{summary['skip_rate']:.1%}"),
+    ])
+
+    sections = [
+        ("Top tracks", [(_track_label(t), f"{t['plays']} plays", f"{t['minutes']:.0f} min")
+                        for t in summary["top_tracks"]]),
+        ("Top artists", [(a["artist"], f"{a['plays']} plays", f"{a['minutes']:.0f} min")
+                         for a in summary["top_artists"]]),
+        ("Top genres", [(g["genre"], f"{g['plays']} plays", f"{g['share']:.0%}")
+                        for g in summary["top_genres"]]),
+    ]
+    for title, rows in sections:
+        lines += ["", title]
+        if not rows:
+            lines.append("  -")
+            continue
+        numbered = [(f"{i}.", *row) for i, row in enumerate(rows, start=1)]
+        lines += [f"  {line}" for line in _columns(numbered, right=(0, 2, 3))]
+
+    return "\n".join(lines) + "\n"
@@ -0,0 +1,1276 @@
+# Leaves out plays listened to for less than min_play_ms, i.e. the track's
+# duration minus the unplayed share given by skip_score; 0 keeps every play,
+# and plays without a skip score are always kept. Every query that counts
+# plays (track_plays aliased tp) applies it, most through PLAYED_IN_WINDOW.
+LONG_ENOUGH_PLAY = """
+    (%(min_play_ms)s = 0
+        OR tp.skip_score IS NULL
+        OR (SELECT COALESCE(duration_ms, 0) FROM tracks WHERE id = tp.track_id) * (1 - tp.skip_score)
+            >= %(min_play_ms)s)
+"""
+
+# Restricts track_plays (aliased tp) to an inclusive [date_from, date_to]
+# window of local calendar days, of plays that are LONG_ENOUGH_PLAY. Either
+# bound may be NULL.
+PLAYED_IN_WINDOW = f"""
+    (%(date_from)s::date IS NULL
+        OR tp.played_at >= %(date_from)s::date::timestamp AT TIME ZONE %(tz)s)
+    AND (%(date_to)s::date IS NULL
+        OR tp.played_at < (%(date_to)s::date + 1)::timestamp AT TIME ZONE %(tz)s)
+    AND {LONG_ENOUGH_PLAY}
+"""
+
+# Daily rollups are kept by the tracker up to rollup_state.refreshed_through.
+# The *_ROWS fragments below read those days from the rollup tables and any
+# later day from track_plays, grouped the same way, so results do not depend
+# on how recently the rollups were refreshed. Without rollups every day is
+# read from track_plays. All of them take the PLAYED_IN_WINDOW parameters.
+# The rollups count every play, so they are not used with a min_play_ms.
+ROLLED_UP_THROUGH = """
+    (SELECT COALESCE(MAX(refreshed_through), '-infinity'::date)
+     FROM rollup_state WHERE tz = %(tz)s AND %(min_play_ms)s = 0)
+"""
+
+ROLLUP_IN_WINDOW = f"""
+    r.day <= {ROLLED_UP_THROUGH}
+    AND (%(date_from)s::date IS NULL OR r.day >= %(date_from)s::date)
+    AND (%(date_to)s::date IS NULL OR r.day <= %(date_to)s::date)
+"""
+
+NOT_ROLLED_UP = f"""
+    tp.played_at >= ({ROLLED_UP_THROUGH} + 1)::timestamp AT TIME ZONE %(tz)s
+"""
+
+# day, plays, skips, duration_ms (of plays that were not skipped)
+DAILY_LISTENING_ROWS = f"""
+    SELECT r.day, r.plays, r.skips, r.duration_ms
+    FROM daily_listening r
+    WHERE {ROLLUP_IN_WINDOW}
+
+    UNION ALL
+
+    SELECT
+        (tp.played_at AT TIME ZONE %(tz)s)::date,
+        COUNT(*),
+        COUNT(*) FILTER (WHERE tp.skipped),
+        COALESCE(SUM(t.duration_ms) FILTER (WHERE tp.skipped IS NOT TRUE), 0)
+    FROM track_plays tp
+    JOIN tracks t ON t.id = tp.track_id
+    WHERE {PLAYED_IN_WINDOW}
+    AND {NOT_ROLLED_UP}
+    GROUP BY 1
+"""
+
+# day, artist_id, plays, duration_ms; skipped plays are left out.
+DAILY_ARTIST_ROWS = f"""
+    SELECT r.day, r.artist_id, r.plays, r.duration_ms
+    FROM daily_artist_listening r
+    WHERE {ROLLUP_IN_WINDOW}
+
+    UNION ALL
+
+    SELECT
+        (tp.played_at AT TIME ZONE %(tz)s)::date,
+        at.artist_id,
+        COUNT(*),
+        COALESCE(SUM(t.duration_ms), 0)
+    FROM track_plays tp
+    JOIN tracks t ON t.id = tp.track_id
+    JOIN artist_tracks at ON at.track_id = tp.track_id
+    WHERE tp.skipped IS NOT TRUE
+    AND {PLAYED_IN_WINDOW}
+    AND {NOT_ROLLED_UP}
+    GROUP BY 1, 2
+"""
+
+# day, genre_id, plays, weighted_plays, weighted_duration_ms; skipped plays
+# are left out. A play counts once per genre in plays even if several of its
+# artists share that genre, and is split evenly between its genres in the
+# weighted columns.
+DAILY_GENRE_ROWS = f"""
+    SELECT r.day, r.genre_id, r.plays, r.weighted_plays, r.weighted_duration_ms
+    FROM daily_genre_listening r
+    WHERE {ROLLUP_IN_WINDOW}
+
+    UNION ALL
+
+    SELECT day, genre_id, COUNT(*), SUM(weight), SUM(duration_ms)
+    FROM (
+        SELECT
+            day,
+            genre_id,
+            1.0 / COUNT(*) OVER (PARTITION BY id) AS weight,
+            duration_ms::float8 / COUNT(*) OVER (PARTITION BY id) AS duration_ms
+        FROM (
+            SELECT
+                tp.id,
+                (tp.played_at AT TIME ZONE %(tz)s)::date AS day,
+                COALESCE(t.duration_ms, 0) AS duration_ms,
+                tg.genre_id
+            FROM track_plays tp
+            JOIN tracks t ON t.id = tp.track_id
+            JOIN track_genres tg ON tg.track_id = tp.track_id
+            WHERE tp.skipped IS NOT TRUE
+            AND {PLAYED_IN_WINDOW}
+            AND {NOT_ROLLED_UP}
+        ) play_genres
+    ) weighted
+    GROUP BY day, genre_id
+"""
+
+# Display name of all artists of track t, e.g. "Artist A & Artist B".
+TRACK_ARTISTS = """
+    (SELECT STRING_AGG(a.name, ' & ' ORDER BY a.name)
+     FROM artist_tracks at
+     JOIN artists a ON a.id = at.artist_id
+     WHERE at.track_id = t.id)
+"""
+
+BY_WEEKDAY_SQL = f"""
+WITH plays AS (
+    SELECT
+        EXTRACT(ISODOW FROM tp.played_at AT TIME ZONE %(tz)s)::int AS weekday,
+        t.duration_ms
+    FROM track_plays tp
+    JOIN tracks t ON t.id = tp.track_id
+    WHERE {PLAYED_IN_WINDOW}
+)
+
+SELECT
+    d.weekday,
+    COUNT(p.weekday) AS plays,
+    ROUND(COALESCE(SUM(p.duration_ms), 0) / 60000.0, 1)::float8 AS minutes
+FROM generate_series(1, 7) AS d(weekday)
+LEFT JOIN plays p ON p.weekday = d.weekday
+GROUP BY d.weekday
+ORDER BY d.weekday;
+"""
+
+LOCAL_TODAY_SQL = """
+SELECT (now() AT TIME ZONE %(tz)s)::date AS today;
+"""
+
+# Gaps-and-islands over active local days: subtracting the row number from
+# consecutive days yields the same anchor date for every day in a streak.
+STREAKS_SQL = f"""
+WITH active_days AS (
+    SELECT DISTINCT (tp.played_at AT TIME ZONE %(tz)s)::date AS day
+    FROM track_plays tp
+    WHERE (%(count_skipped)s OR tp.skipped IS NOT TRUE)
+    AND {PLAYED_IN_WINDOW}
+),
+
+islands AS (
+    SELECT
+        day,
+        day - (ROW_NUMBER() OVER (ORDER BY day))::int AS anchor
+    FROM active_days
+)
+
+SELECT
+    MIN(day) AS start_day,
+    MAX(day) AS end_day,
+    COUNT(*) AS length
+FROM islands
+GROUP BY anchor
+ORDER BY start_day;
+"""
+
+ORDERED_PLAYS_SQL = f"""
+SELECT
+    tp.id,
+    tp.user_id,
+    tp.track_id,
+    tp.played_at,
+    t.title,
+    t.duration_ms,
+    {TRACK_ARTISTS} AS artist
+FROM track_plays tp
+JOIN tracks t ON t.id = tp.track_id
+WHERE {PLAYED_IN_WINDOW}
+ORDER BY tp.user_id, tp.played_at;
+"""
+
+# Unskipped plays with the ids and names of their artists, in the same
+# order. Plays of tracks without known artists have empty arrays.
+ORDERED_ARTIST_PLAYS_SQL = f"""
+SELECT
+    tp.user_id,
+    tp.track_id,
+    tp.played_at,
+    COALESCE(ARRAY_AGG(a.id ORDER BY a.id) FILTER (WHERE a.id IS NOT NULL), '{{}}') AS artist_ids,
+    COALESCE(ARRAY_AGG(a.name ORDER BY a.id) FILTER (WHERE a.id IS NOT NULL), '{{}}') AS artist_names
+FROM track_plays tp
+LEFT JOIN artist_tracks at ON at.track_id = tp.track_id
+LEFT JOIN artists a ON a.id = at.artist_id
+WHERE tp.skipped IS NOT TRUE
+AND {PLAYED_IN_WINDOW}
+GROUP BY tp.id
+ORDER BY tp.user_id, tp.played_at;
+"""
+
+# Plays with skipped IS NULL were never evaluated and are left out entirely.
+# skip_score weighs each play by how much of it was left unplayed; plays
+# recorded before the column existed have no score and are ignored by the
+# weighted figures only.
+SKIPS_BY_TRACK_SQL = f"""
+SELECT
+    t.id AS track_id,
+    t.title,
+    {TRACK_ARTISTS} AS artist,
+    COUNT(*) AS plays,
+    COUNT(*) FILTER (WHERE tp.skipped) AS skips,
+    ROUND(COUNT(*) FILTER (WHERE tp.skipped)::numeric / COUNT(*), 3)::float8 AS skip_rate,
+    ROUND(COALESCE(SUM(tp.skip_score), 0)::numeric, 3)::float8 AS skip_score_sum,
+    ROUND(AVG(tp.skip_score)::numeric, 3)::float8 AS avg_skip_score
+FROM track_plays tp
+JOIN tracks t ON t.id = tp.track_id
+WHERE tp.skipped IS NOT NULL
+AND tp.played_at >= now() - %(since)s
+AND {LONG_ENOUGH_PLAY}
+GROUP BY t.id, t.title
+HAVING COUNT(*) >= %(min_plays)s
+ORDER BY CASE WHEN %(weighted)s THEN AVG(tp.skip_score) ELSE NULL END DESC NULLS LAST,
+    skip_rate DESC, plays DESC
+LIMIT %(limit)s;
+"""
+
+SKIPS_BY_ARTIST_SQL = f"""
+SELECT
+    a.id AS artist_id,
+    a.name AS artist,
+    COUNT(*) AS plays,
+    COUNT(*) FILTER (WHERE tp.skipped) AS skips,
+    ROUND(COUNT(*) FILTER (WHERE tp.skipped)::numeric / COUNT(*), 3)::float8 AS skip_rate,
+    ROUND(COALESCE(SUM(tp.skip_score), 0)::numeric, 3)::float8 AS skip_score_sum,
+    ROUND(AVG(tp.skip_score)::numeric, 3)::float8 AS avg_skip_score
+FROM track_plays tp
+JOIN artist_tracks at ON at.track_id = tp.track_id
+JOIN artists a ON a.id = at.artist_id
+WHERE tp.skipped IS NOT NULL
+AND tp.played_at >= now() - %(since)s
+AND {LONG_ENOUGH_PLAY}
+GROUP BY a.id, a.name
+HAVING COUNT(*) >= %(min_plays)s
+ORDER BY CASE WHEN %(weighted)s THEN AVG(tp.skip_score) ELSE NULL END DESC NULLS LAST,
+    skip_rate DESC, plays DESC
+LIMIT %(limit)s;
+"""
+
+# Local days on which a single track or artist was played at least
+# %(min_repeats)s times.
+BINGE_DAYS_BY_TRACK_SQL = f"""
+SELECT
+    (tp.played_at AT TIME ZONE %(tz)s)::date AS day,
+    t.id AS track_id,
+    t.title,
+    {TRACK_ARTISTS} AS artist,
+    COUNT(*) AS plays,
+    ROUND(COALESCE(SUM(t.duration_ms), 0) / 60000.0, 1)::float8 AS minutes
+FROM track_plays tp
+JOIN tracks t ON t.id = tp.track_id
+WHERE tp.played_at >= now() - %(since)s
+AND {LONG_ENOUGH_PLAY}
+GROUP BY 1, t.id, t.title
+HAVING COUNT(*) >= %(min_repeats)s
+ORDER BY plays DESC, day DESC
+LIMIT %(limit)s;
+"""
+
+BINGE_DAYS_BY_ARTIST_SQL = f"""
+SELECT
+    (tp.played_at AT TIME ZONE %(tz)s)::date AS day,
+    a.id AS artist_id,
+    a.name AS artist,
+    COUNT(*) AS plays,
+    ROUND(COALESCE(SUM(t.duration_ms), 0) / 60000.0, 1)::float8 AS minutes
+FROM track_plays tp
+JOIN tracks t ON t.id = tp.track_id
+JOIN artist_tracks at ON at.track_id = tp.track_id
+JOIN artists a ON a.id = at.artist_id
+WHERE tp.played_at >= now() - %(since)s
+AND {LONG_ENOUGH_PLAY}
+GROUP BY 1, a.id, a.name
+HAVING COUNT(*) >= %(min_repeats)s
+ORDER BY plays DESC, day DESC
+LIMIT %(limit)s;
+"""
+
+BINGE_SESSIONS_SQL = f"""
+WITH recent AS (
+    SELECT bs.id, bs.started_at, bs.ended_at, u.username
+    FROM binge_sessions bs
+    JOIN users u ON u.id = bs.user_id
+    ORDER BY bs.started_at DESC
+    LIMIT %(limit)s
+),
+
+session_plays AS (
+    SELECT
+        tp.binge_session_id,
+        tp.played_at,
+        t.id AS track_id,
+        t.title,
+        {TRACK_ARTISTS} AS artist,
+        COALESCE(t.duration_ms, 0) AS duration_ms
+    FROM track_plays tp
+    JOIN tracks t ON t.id = tp.track_id
+    WHERE tp.binge_session_id IN (SELECT id FROM recent)
+)
+
+SELECT
+    r.id::text AS session_id,
+    r.username,
+    r.started_at,
+    r.ended_at,
+    COUNT(*) AS plays,
+    ROUND(SUM(sp.duration_ms) / 60000.0, 1)::float8 AS minutes,
+    JSON_AGG(JSON_BUILD_OBJECT(
+        'track_id', sp.track_id,
+        'title', sp.title,
+        'artist', sp.artist,
+        'played_at', sp.played_at
+    ) ORDER BY sp.played_at) AS tracks
+FROM recent r
+JOIN session_plays sp ON sp.binge_session_id = r.id
+GROUP BY r.id, r.username, r.started_at, r.ended_at
+ORDER BY r.started_at DESC;
+"""
+
+# Rabbit holes recorded by the tracker, most recent first. The tracker only
+# ends one at the user's next play, so a hole whose last play is longer
+# than the session gap ago is reported as over already.
+RECORDED_RABBIT_HOLES_SQL = """
+SELECT *
+FROM (
+    SELECT
+        rh.id::text AS rabbit_hole_id,
+        u.username,
+        rh.artist_id,
+        a.name AS artist,
+        rh.plays,
+        rh.tracks,
+        rh.started_at,
+        rh.ended_at,
+        rh.still_active AND rh.ended_at > now() - %(session_gap)s AS still_active
+    FROM rabbit_holes rh
+    JOIN users u ON u.id = rh.user_id
+    JOIN artists a ON a.id = rh.artist_id
+) holes
+WHERE still_active OR NOT %(active_only)s
+ORDER BY started_at DESC
+LIMIT %(limit)s;
+"""
+
+# Each play weighs 0.5 ^ (age / half_life), so a play one half-life ago
+# counts half as much as one right now. The exponent is clamped because
+# float8 exp() raises on underflow instead of returning 0.
+PLAY_WEIGHT = """
+    EXP(GREATEST(
+        -LN(2) * EXTRACT(EPOCH FROM now() - tp.played_at)::float8
+            / EXTRACT(EPOCH FROM %(half_life)s::interval)::float8,
+        -700))
+"""
+
+FAVORITE_TRACKS_SQL = f"""
+SELECT
+    t.id AS track_id,
+    t.title,
+    {TRACK_ARTISTS} AS artist,
+    ROUND(SUM({PLAY_WEIGHT})::numeric, 3)::float8 AS score,
+    COUNT(*) AS plays
+FROM track_plays tp
+JOIN tracks t ON t.id = tp.track_id
+WHERE tp.skipped IS NOT TRUE
+AND {LONG_ENOUGH_PLAY}
+GROUP BY t.id, t.title
+ORDER BY score DESC
+LIMIT %(limit)s;
+"""
+
+FAVORITE_ARTISTS_SQL = f"""
+SELECT
+    a.id AS artist_id,
+    a.name AS artist,
+    ROUND(SUM({PLAY_WEIGHT})::numeric, 3)::float8 AS score,
+    COUNT(*) AS plays
+FROM track_plays tp
+JOIN artist_tracks at ON at.track_id = tp.track_id
+JOIN artists a ON a.id = at.artist_id
+WHERE tp.skipped IS NOT TRUE
+AND {LONG_ENOUGH_PLAY}
+GROUP BY a.id, a.name
+ORDER BY score DESC
+LIMIT %(limit)s;
+"""
+
+# Artists with at least %(active_plays)s plays inside the quiet window.
+ACTIVE_ARTISTS = f"""
+    (SELECT at.artist_id
+     FROM track_plays tp
+     JOIN artist_tracks at ON at.track_id = tp.track_id
+     WHERE tp.played_at >= now() - %(quiet_for)s
+     AND {LONG_ENOUGH_PLAY}
+     GROUP BY at.artist_id
+     HAVING COUNT(*) >= %(active_plays)s)
+"""
+
+FORGOTTEN_TRACKS_SQL = f"""
+SELECT
+    t.id AS track_id,
+    t.title,
+    {TRACK_ARTISTS} AS artist,
+    COUNT(*) AS plays,
+    MAX(tp.played_at) AS last_played_at
+FROM track_plays tp
+JOIN tracks t ON t.id = tp.track_id
+WHERE tp.skipped IS NOT TRUE
+AND {LONG_ENOUGH_PLAY}
+AND (NOT %(exclude_active_artists)s OR NOT EXISTS (
+    SELECT 1
+    FROM artist_tracks at
+    WHERE at.track_id = t.id
+    AND at.artist_id IN {ACTIVE_ARTISTS}
+))
+GROUP BY t.id, t.title
+HAVING COUNT(*) >= %(min_plays)s
+AND MAX(tp.played_at) < now() - %(quiet_for)s
+ORDER BY plays DESC
+LIMIT %(limit)s;
+"""
+
+FORGOTTEN_ARTISTS_SQL = f"""
+SELECT
+    a.id AS artist_id,
+    a.name AS artist,
+    COUNT(*) AS plays,
+    MAX(tp.played_at) AS last_played_at
+FROM track_plays tp
+JOIN artist_tracks at ON at.track_id = tp.track_id
+JOIN artists a ON a.id = at.artist_id
+WHERE tp.skipped IS NOT TRUE
+AND {LONG_ENOUGH_PLAY}
+GROUP BY a.id, a.name
+HAVING COUNT(*) >= %(min_plays)s
+AND MAX(tp.played_at) < now() - %(quiet_for)s
+ORDER BY plays DESC
+LIMIT %(limit)s;
+"""
+
+# Without a stored listened time each play is attributed to its start hour.
+BY_HOUR_SQL = f"""
+WITH plays AS (
+    SELECT
+        EXTRACT(HOUR FROM tp.played_at AT TIME ZONE %(tz)s)::int AS hour,
+        t.duration_ms
+    FROM track_plays tp
+    JOIN tracks t ON t.id = tp.track_id
+    WHERE {PLAYED_IN_WINDOW}
+)
+
+SELECT
+    h.hour,
+    COUNT(p.hour) AS plays,
+    ROUND(COALESCE(SUM(p.duration_ms), 0) / 60000.0, 1)::float8 AS minutes
+FROM generate_series(0, 23) AS h(hour)
+LEFT JOIN plays p ON p.hour = h.hour
+GROUP BY h.hour
+ORDER BY h.hour;
+"""
+
+HEATMAP_SQL = f"""
+SELECT
+    EXTRACT(ISODOW FROM tp.played_at AT TIME ZONE %(tz)s)::int AS weekday,
+    EXTRACT(HOUR FROM tp.played_at AT TIME ZONE %(tz)s)::int AS hour,
+    (COALESCE(SUM(t.duration_ms), 0) / 60000.0)::float8 AS minutes
+FROM track_plays tp
+JOIN tracks t ON t.id = tp.track_id
+WHERE tp.played_at >= now() - %(since)s
+AND {LONG_ENOUGH_PLAY}
+GROUP BY 1, 2;
+"""
+
+# First plays are computed over the full history; the window only limits
+# which buckets are returned.
+DISCOVERIES_SQL = f"""
+WITH first_artist_plays AS (
+    SELECT
+        at.artist_id,
+        MIN(tp.played_at) AS first_played,
+        COUNT(*) AS total_plays
+    FROM track_plays tp
+    JOIN artist_tracks at ON at.track_id = tp.track_id
+    WHERE {LONG_ENOUGH_PLAY}
+    GROUP BY at.artist_id
+),
+
+first_track_plays AS (
+    SELECT
+        tp.track_id,
+        MIN(tp.played_at) AS first_played
+    FROM track_plays tp
+    WHERE {LONG_ENOUGH_PLAY}
+    GROUP BY tp.track_id
+),
+
+new_artists AS (
+    SELECT
+        date_trunc(%(granularity)s, fap.first_played AT TIME ZONE %(tz)s)::date AS bucket,
+        a.name,
+        fap.total_plays
+    FROM first_artist_plays fap
+    JOIN artists a ON a.id = fap.artist_id
+),
+
+new_tracks AS (
+    SELECT
+        date_trunc(%(granularity)s, ftp.first_played AT TIME ZONE %(tz)s)::date AS bucket,
+        COUNT(*) AS new_tracks
+    FROM first_track_plays ftp
+    GROUP BY 1
+),
+
+buckets AS (
+    SELECT bucket FROM new_artists
+    UNION
+    SELECT bucket FROM new_tracks
+)
+
+SELECT
+    b.bucket,
+    COUNT(na.name) AS new_artists,
+    COALESCE(nt.new_tracks, 0) AS new_tracks,
+    (ARRAY_AGG(na.name ORDER BY na.total_plays DESC, na.name))[1] AS top_new_artist,
+    COALESCE(ARRAY_AGG(na.name ORDER BY na.name) FILTER (WHERE na.name IS NOT NULL), '{{}}') AS artist_names
+FROM buckets b
+LEFT JOIN new_artists na ON na.bucket = b.bucket
+LEFT JOIN new_tracks nt ON nt.bucket = b.bucket
+WHERE (%(date_from)s::date IS NULL OR b.bucket >= date_trunc(%(granularity)s, %(date_from)s::date))
+AND (%(date_to)s::date IS NULL OR b.bucket <= %(date_to)s::date)
+GROUP BY b.bucket, nt.new_tracks
+ORDER BY b.bucket;
+"""
+
+# Filled by the tracker on the first poll of each month.
+MONTHLY_DISCOVERIES_SQL = """
+SELECT month, new_artists, new_tracks, new_genres
+FROM (
+    SELECT month, new_artists, new_tracks, new_genres
+    FROM monthly_discoveries
+    ORDER BY month DESC
+    LIMIT %(months)s
+) latest
+ORDER BY month ASC;
+"""
+
+LISTENING_TOTALS_SQL = f"""
+SELECT
+    COUNT(*) AS plays,
+    COUNT(*) FILTER (WHERE tp.skipped) AS skips,
+    ROUND(COALESCE(SUM(t.duration_ms) FILTER (WHERE tp.skipped IS NOT TRUE), 0) / 60000.0, 1)::float8 AS minutes,
+    COUNT(DISTINCT tp.track_id) AS unique_tracks,
+    (SELECT COUNT(DISTINCT at.artist_id)
+     FROM track_plays tp
+     JOIN artist_tracks at ON at.track_id = tp.track_id
+     WHERE {PLAYED_IN_WINDOW}) AS unique_artists
+FROM track_plays tp
+JOIN tracks t ON t.id = tp.track_id
+WHERE {PLAYED_IN_WINDOW};
+"""
+
+TOP_TRACKS_SQL = f"""
+SELECT
+    t.id AS track_id,
+    t.title,
+    {TRACK_ARTISTS} AS artist,
+    COUNT(*) AS plays,
+    ROUND(COALESCE(SUM(t.duration_ms), 0) / 60000.0, 1)::float8 AS minutes
+FROM track_plays tp
+JOIN tracks t ON t.id = tp.track_id
+WHERE tp.skipped IS NOT TRUE
+AND {PLAYED_IN_WINDOW}
+GROUP BY t.id, t.title
+ORDER BY plays DESC, minutes DESC, t.title
+LIMIT %(limit)s;
+"""
+
+# Artists are grouped by normalized_name, so "Beatles" and "The Beatles" add
+# up. Each group is shown under the id and name of its most played artist.
+TOP_ARTISTS_SQL = f"""
+WITH artist_plays AS (
+    SELECT
+        a.id,
+        a.name,
+        a.normalized_name,
+        SUM(d.plays) AS plays,
+        SUM(d.duration_ms) AS duration_ms
+    FROM ({DAILY_ARTIST_ROWS}) d
+    JOIN artists a ON a.id = d.artist_id
+    GROUP BY a.id, a.name, a.normalized_name
+)
+
+SELECT
+    (ARRAY_AGG(id ORDER BY plays DESC, id))[1] AS artist_id,
+    (ARRAY_AGG(name ORDER BY plays DESC, id))[1] AS artist,
+    SUM(plays)::bigint AS plays,
+    ROUND(SUM(duration_ms) / 60000.0, 1)::float8 AS minutes
+FROM artist_plays
+GROUP BY normalized_name
+ORDER BY plays DESC, minutes DESC, artist
+LIMIT %(limit)s;
+"""
+
+TOP_GENRES_SQL = f"""
+SELECT
+    g.name AS genre,
+    SUM(d.plays)::bigint AS plays
+FROM ({DAILY_GENRE_ROWS}) d
+JOIN genres g ON g.id = d.genre_id
+GROUP BY g.name
+ORDER BY plays DESC, g.name
+LIMIT %(limit)s;
+"""
+
+# Listening minutes and plays per genre and bucket. A play of an artist with
+# several genres is split evenly between them, so every bucket adds up to the
+# time and number of plays with known genres.
+GENRE_MINUTES_SQL = f"""
+WITH bucket_plays AS (
+    SELECT
+        date_trunc(%(granularity)s, d.day::timestamp)::date AS bucket,
+        SUM(d.plays - d.skips)::bigint AS plays
+    FROM ({DAILY_LISTENING_ROWS}) d
+    GROUP BY 1
+    HAVING SUM(d.plays - d.skips) > 0
+),
+
+genre_buckets AS (
+    SELECT
+        date_trunc(%(granularity)s, d.day::timestamp)::date AS bucket,
+        d.genre_id,
+        SUM(d.weighted_plays) AS genre_plays,
+        SUM(d.weighted_duration_ms) AS duration_ms
+    FROM ({DAILY_GENRE_ROWS}) d
+    GROUP BY 1, 2
+)
+
+SELECT
+    bp.bucket,
+    bp.plays,
+    g.name AS genre,
+    COALESCE(gb.duration_ms, 0) / 60000.0 AS minutes,
+    COALESCE(gb.genre_plays, 0)::float8 AS genre_plays
+FROM bucket_plays bp
+LEFT JOIN genre_buckets gb ON gb.bucket = bp.bucket
+LEFT JOIN genres g ON g.id = gb.genre_id
+ORDER BY bp.bucket, minutes DESC;
+"""
+
+ARTIST_MINUTES_SQL = f"""
+WITH bucket_plays AS (
+    SELECT
+        date_trunc(%(granularity)s, d.day::timestamp)::date AS bucket,
+        SUM(d.plays - d.skips)::bigint AS plays
+    FROM ({DAILY_LISTENING_ROWS}) d
+    GROUP BY 1
+    HAVING SUM(d.plays - d.skips) > 0
+),
+
+artist_buckets AS (
+    SELECT
+        date_trunc(%(granularity)s, d.day::timestamp)::date AS bucket,
+        a.normalized_name,
+        MIN(a.name) AS artist,
+        SUM(d.duration_ms) AS duration_ms
+    FROM ({DAILY_ARTIST_ROWS}) d
+    JOIN artists a ON a.id = d.artist_id
+    GROUP BY 1, 2
+)
+
+SELECT
+    bp.bucket,
+    bp.plays,
+    ab.artist,
+    COALESCE(ab.duration_ms, 0) / 60000.0 AS minutes
+FROM bucket_plays bp
+LEFT JOIN artist_buckets ab ON ab.bucket = bp.bucket
+ORDER BY bp.bucket, minutes DESC;
+"""
+
+MOST_SKIPPED_TRACKS_SQL = f"""
+SELECT
+    t.id AS track_id,
+    t.title,
+    {TRACK_ARTISTS} AS artist,
+    COUNT(*) FILTER (WHERE tp.skipped) AS skips,
+    COUNT(*) AS plays
+FROM track_plays tp
+JOIN tracks t ON t.id = tp.track_id
+WHERE tp.skipped IS NOT NULL
+AND {PLAYED_IN_WINDOW}
+GROUP BY t.id, t.title
+HAVING COUNT(*) FILTER (WHERE tp.skipped) > 0
+ORDER BY skips DESC, plays ASC, t.title
+LIMIT %(limit)s;
+"""
+
+MOST_SKIPPED_ARTISTS_SQL = f"""
+SELECT
+    a.id AS artist_id,
+    a.name AS artist,
+    COUNT(*) FILTER (WHERE tp.skipped) AS skips,
+    COUNT(*) AS plays
+FROM track_plays tp
+JOIN artist_tracks at ON at.track_id = tp.track_id
+JOIN artists a ON a.id = at.artist_id
+WHERE tp.skipped IS NOT NULL
+AND {PLAYED_IN_WINDOW}
+GROUP BY a.id, a.name
+HAVING COUNT(*) FILTER (WHERE tp.skipped) > 0
+ORDER BY skips DESC, plays ASC, a.name
+LIMIT %(limit)s;
+"""
+
+DAILY_SUMMARY_SQL = f"""
+SELECT
+    d.day,
+    d.plays,
+    d.skips,
+    ROUND(d.duration_ms / 60000.0, 1)::float8 AS minutes
+FROM ({DAILY_LISTENING_ROWS}) d
+ORDER BY d.day;
+"""
+
+BUSIEST_DAY_SQL = f"""
+SELECT
+    (tp.played_at AT TIME ZONE %(tz)s)::date AS day,
+    COUNT(*) AS plays,
+    ROUND(COALESCE(SUM(t.duration_ms), 0) / 60000.0, 1)::float8 AS minutes
+FROM track_plays tp
+JOIN tracks t ON t.id = tp.track_id
+WHERE tp.skipped IS NOT TRUE
+AND {PLAYED_IN_WINDOW}
+GROUP BY 1
+ORDER BY minutes DESC, plays DESC
+LIMIT 1;
+"""
+
+# Splits plays into listening sessions: a new session starts when a play
+# begins more than %(session_gap)s after the previous play would have ended.
+# Sessions are attributed to the local day they started on.
+SESSIONS_CTE = f"""
+timed_plays AS (
+    SELECT
+        tp.id,
+        tp.user_id,
+        tp.track_id,
+        tp.played_at,
+        tp.played_at + COALESCE(t.duration_ms, 0) * interval '1 millisecond' AS ended_at
+    FROM track_plays tp
+    JOIN tracks t ON t.id = tp.track_id
+    WHERE {PLAYED_IN_WINDOW}
+),
+
+session_starts AS (
+    SELECT
+        *,
+        CASE
+            WHEN played_at - LAG(ended_at) OVER (PARTITION BY user_id ORDER BY played_at)
+                 <= %(session_gap)s THEN 0
+            ELSE 1
+        END AS is_start
+    FROM timed_plays
+),
+
+session_plays AS (
+    SELECT
+        *,
+        SUM(is_start) OVER (PARTITION BY user_id ORDER BY played_at) AS session_no
+    FROM session_starts
+),
+
+sessions AS (
+    SELECT
+        user_id,
+        session_no,
+        MIN(played_at) AS started_at,
+        MAX(ended_at) AS ended_at,
+        COUNT(*) AS tracks,
+        (ARRAY_AGG(track_id ORDER BY played_at))[1] AS first_track_id,
+        (ARRAY_AGG(track_id ORDER BY played_at DESC))[1] AS last_track_id
+    FROM session_plays
+    GROUP BY user_id, session_no
+)
+"""
+
+LONGEST_SESSION_SQL = f"""
+WITH {SESSIONS_CTE}
+
+SELECT
+    (s.started_at AT TIME ZONE %(tz)s)::date AS day,
+    s.started_at,
+    s.ended_at,
+    s.tracks,
+    ROUND(EXTRACT(EPOCH FROM s.ended_at - s.started_at) / 60.0, 1)::float8 AS minutes,
+    ft.title AS first_track,
+    lt.title AS last_track
+FROM sessions s
+JOIN tracks ft ON ft.id = s.first_track_id
+JOIN tracks lt ON lt.id = s.last_track_id
+ORDER BY s.ended_at - s.started_at DESC
+LIMIT 1;
+"""
+
+# Buckets are [0, 15), [15, 60), [60, 180) and 180+ minutes.
+SESSION_STATS_SQL = f"""
+WITH {SESSIONS_CTE},
+
+lengths AS (
+    SELECT
+        EXTRACT(EPOCH FROM ended_at - started_at) / 60.0 AS minutes,
+        tracks
+    FROM sessions
+)
+
+SELECT
+    COUNT(*) AS sessions,
+    ROUND(AVG(minutes), 1)::float8 AS avg_minutes,
+    ROUND(PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY minutes)::numeric, 1)::float8 AS median_minutes,
+    ROUND(AVG(tracks), 1)::float8 AS avg_tracks,
+    PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY tracks)::float8 AS median_tracks,
+    COUNT(*) FILTER (WHERE minutes < 15) AS under_15m,
+    COUNT(*) FILTER (WHERE minutes >= 15 AND minutes < 60) AS from_15m_to_1h,
+    COUNT(*) FILTER (WHERE minutes >= 60 AND minutes < 180) AS from_1h_to_3h,
+    COUNT(*) FILTER (WHERE minutes >= 180) AS over_3h
+FROM lengths;
+"""
+
+FIRST_PLAY_DAY_SQL = f"""
+SELECT (MIN(tp.played_at) AT TIME ZONE %(tz)s)::date AS day
+FROM track_plays tp
+WHERE {LONG_ENOUGH_PLAY};
+"""
+
+# Plays on a given local calendar date (MM-DD) of every earlier year, with
+# the most played track and artist of each day.
+ON_THIS_DAY_SQL = f"""
+WITH day_plays AS (
+    SELECT
+        (tp.played_at AT TIME ZONE %(tz)s)::date AS day,
+        tp.track_id,
+        tp.skipped,
+        t.duration_ms
+    FROM track_plays tp
+    JOIN tracks t ON t.id = tp.track_id
+    WHERE to_char(tp.played_at AT TIME ZONE %(tz)s, 'MM-DD') = %(month_day)s
+    AND (tp.played_at AT TIME ZONE %(tz)s)::date < %(before)s
+    AND {LONG_ENOUGH_PLAY}
+),
+
+totals AS (
+    SELECT
+        day,
+        COUNT(*) AS plays,
+        ROUND(COALESCE(SUM(duration_ms) FILTER (WHERE skipped IS NOT TRUE), 0) / 60000.0, 1)::float8 AS minutes
+    FROM day_plays
+    GROUP BY day
+),
+
+top_tracks AS (
+    SELECT DISTINCT ON (day)
+        day,
+        track_id,
+        COUNT(*) AS plays
+    FROM day_plays
+    WHERE skipped IS NOT TRUE
+    GROUP BY day, track_id
+    ORDER BY day, COUNT(*) DESC, track_id
+),
+
+top_artists AS (
+    SELECT DISTINCT ON (dp.day)
+        dp.day,
+        at.artist_id,
+        COUNT(*) AS plays
+    FROM day_plays dp
+    JOIN artist_tracks at ON at.track_id = dp.track_id
+    WHERE dp.skipped IS NOT TRUE
+    GROUP BY dp.day, at.artist_id
+    ORDER BY dp.day, COUNT(*) DESC, at.artist_id
+)
+
+SELECT
+    tot.day,
+    tot.plays,
+    tot.minutes,
+    t.title AS top_track,
+    {TRACK_ARTISTS} AS top_track_artist,
+    tt.plays AS top_track_plays,
+    a.name AS top_artist,
+    ta.plays AS top_artist_plays
+FROM totals tot
+LEFT JOIN top_tracks tt ON tt.day = tot.day
+LEFT JOIN tracks t ON t.id = tt.track_id
+LEFT JOIN top_artists ta ON ta.day = tot.day
+LEFT JOIN artists a ON a.id = ta.artist_id
+ORDER BY tot.day DESC;
+"""
+
+ON_THIS_DAY_TRACKS_SQL = f"""
+SELECT
+    (tp.played_at AT TIME ZONE %(tz)s)::date AS day,
+    t.id AS track_id,
+    t.title,
+    {TRACK_ARTISTS} AS artist,
+    COUNT(*) AS plays,
+    COUNT(*) FILTER (WHERE tp.skipped) AS skips,
+    MIN(tp.played_at) AS first_played_at
+FROM track_plays tp
+JOIN tracks t ON t.id = tp.track_id
+WHERE to_char(tp.played_at AT TIME ZONE %(tz)s, 'MM-DD') = %(month_day)s
+AND (tp.played_at AT TIME ZONE %(tz)s)::date < %(before)s
+AND {LONG_ENOUGH_PLAY}
+GROUP BY 1, t.id
+ORDER BY day DESC, first_played_at, t.id;
+"""
+
+# Consecutive plays within a session are compared against album_tracks: a
+# pair counts as an album pair when both tracks share an album, and as in
+# order when the second is the next track on that album (next number on the
+# same disc, or track 1 of the next disc).
+SESSION_ALBUM_ORDER_SQL = f"""
+WITH {SESSIONS_CTE},
+
+pairs AS (
+    SELECT
+        user_id,
+        session_no,
+        LAG(track_id) OVER (PARTITION BY user_id, session_no ORDER BY played_at) AS prev_track_id,
+        track_id
+    FROM session_plays
+),
+
+scored AS (
+    SELECT
+        p.user_id,
+        p.session_no,
+        EXISTS (
+            SELECT 1
+            FROM album_tracks a1
+            JOIN album_tracks a2 ON a2.album_id = a1.album_id
+            WHERE a1.track_id = p.prev_track_id
+            AND a2.track_id = p.track_id
+        ) AS same_album,
+        EXISTS (
+            SELECT 1
+            FROM album_tracks a1
+            JOIN album_tracks a2 ON a2.album_id = a1.album_id
+            WHERE a1.track_id = p.prev_track_id
+            AND a2.track_id = p.track_id
+            AND a1.track_number > 0
+            AND (
+                (a2.disc_number = a1.disc_number AND a2.track_number = a1.track_number + 1)
+                OR (a2.disc_number = a1.disc_number + 1 AND a2.track_number = 1)
+            )
+        ) AS in_order
+    FROM pairs p
+    WHERE p.prev_track_id IS NOT NULL
+)
+
+SELECT
+    s.started_at,
+    s.ended_at,
+    s.tracks,
+    COUNT(sc.session_no) FILTER (WHERE sc.same_album) AS album_pairs,
+    COUNT(sc.session_no) FILTER (WHERE sc.in_order) AS in_order_pairs
+FROM sessions s
+LEFT JOIN scored sc ON sc.user_id = s.user_id AND sc.session_no = s.session_no
+GROUP BY s.user_id, s.session_no, s.started_at, s.ended_at, s.tracks
+ORDER BY s.started_at;
+"""
+
+# Time actually spent per play: the unplayed share given by skip_score is
+# subtracted where it is known, skipped plays without a score count as 0.
+LISTENED_MS = """
+    CASE
+        WHEN tp.skip_score IS NOT NULL THEN COALESCE(t.duration_ms, 0) * (1 - tp.skip_score)
+        WHEN tp.skipped THEN 0
+        ELSE COALESCE(t.duration_ms, 0)
+    END
+"""
+
+# Grouped by normalized_name like TOP_ARTISTS_SQL.
+ARTIST_LEADERBOARD_SQL = f"""
+WITH artist_plays AS (
+    SELECT
+        a.id,
+        a.name,
+        a.normalized_name,
+        COUNT(*) AS plays,
+        SUM({LISTENED_MS}) AS listened_ms
+    FROM track_plays tp
+    JOIN tracks t ON t.id = tp.track_id
+    JOIN artist_tracks at ON at.track_id = tp.track_id
+    JOIN artists a ON a.id = at.artist_id
+    WHERE {LONG_ENOUGH_PLAY}
+    GROUP BY a.id, a.name, a.normalized_name
+)
+
+SELECT
+    RANK() OVER (ORDER BY SUM(listened_ms) DESC) AS rank,
+    (ARRAY_AGG(id ORDER BY plays DESC, id))[1] AS artist_id,
+    (ARRAY_AGG(name ORDER BY plays DESC, id))[1] AS artist,
+    ROUND(SUM(listened_ms))::bigint AS total_ms,
+    SUM(plays)::bigint AS plays
+FROM artist_plays
+GROUP BY normalized_name
+ORDER BY rank, artist
+LIMIT %(limit)s;
+"""
+
+DEVICES_SQL = f"""
+SELECT
+    COALESCE(tp.device_name, 'unknown') AS device,
+    COUNT(*) AS plays,
+    ROUND(COALESCE(SUM(t.duration_ms) FILTER (WHERE tp.skipped IS NOT TRUE), 0) / 60000.0, 1)::float8 AS minutes
+FROM track_plays tp
+JOIN tracks t ON t.id = tp.track_id
+WHERE {PLAYED_IN_WINDOW}
+GROUP BY 1
+ORDER BY minutes DESC, plays DESC;
+"""
+
+REDISCOVERIES_SQL = f"""
+SELECT
+    tp.id AS track_play_id,
+    t.id AS track_id,
+    t.title,
+    {TRACK_ARTISTS} AS artist,
+    tp.played_at,
+    tp.days_since_last_play
+FROM track_plays tp
+JOIN tracks t ON t.id = tp.track_id
+WHERE tp.rediscovery
+AND tp.played_at >= now() - %(since)s
+AND {LONG_ENOUGH_PLAY}
+ORDER BY tp.days_since_last_play DESC, tp.played_at DESC
+LIMIT %(limit)s;
+"""
+
+# Plays in the order they were stored. Keyset pages start after
+# %(after_id)s, which only reads the rows returned through the primary key;
+# %(offset)s serves the older page-number form and is 0 otherwise.
+HISTORY_SQL = f"""
+SELECT
+    tp.id AS track_play_id,
+    tp.played_at,
+    t.id AS track_id,
+    t.title,
+    {TRACK_ARTISTS} AS artist,
+    u.username,
+    tp.device_name,
+    tp.skipped,
+    tp.skip_score
+FROM track_plays tp
+JOIN tracks t ON t.id = tp.track_id
+JOIN users u ON u.id = tp.user_id
+WHERE tp.id > %(after_id)s
+AND {PLAYED_IN_WINDOW}
+ORDER BY tp.id
+LIMIT %(limit)s
+OFFSET %(offset)s;
+"""
+
+# Albums are found through album_tracks, so plays of tracks that are not on
+# any album are left out. A track on several albums counts for each of them.
+# Most and least played tracks only consider tracks played in the window.
+TOP_ALBUMS_SQL = f"""
+WITH album_plays AS (
+    SELECT
+        alt.album_id,
+        tp.track_id,
+        tp.skipped,
+        {LISTENED_MS} AS listened_ms
+    FROM track_plays tp
+    JOIN tracks t ON t.id = tp.track_id
+    JOIN album_tracks alt ON alt.track_id = tp.track_id
+    WHERE {PLAYED_IN_WINDOW}
+),
+
+track_counts AS (
+    SELECT
+        album_id,
+        track_id,
+        COUNT(*) AS plays,
+        ROW_NUMBER() OVER (PARTITION BY album_id ORDER BY COUNT(*) DESC, track_id) AS most_rank,
+        ROW_NUMBER() OVER (PARTITION BY album_id ORDER BY COUNT(*), track_id) AS least_rank
+    FROM album_plays
+    GROUP BY album_id, track_id
+),
+
+album_totals AS (
+    SELECT
+        album_id,
+        COUNT(*) AS plays,
+        ROUND((SUM(listened_ms) / 60000.0)::numeric, 1)::float8 AS minutes,
+        ROUND(COUNT(*) FILTER (WHERE skipped)::numeric
+            / NULLIF(COUNT(*) FILTER (WHERE skipped IS NOT NULL), 0), 3)::float8 AS skip_rate
+    FROM album_plays
+    GROUP BY album_id
+)
+
+SELECT
+    al.id AS album_id,
+    al.title AS album,
+    (SELECT STRING_AGG(a.name, ' & ' ORDER BY a.name)
+     FROM artist_albums aa
+     JOIN artists a ON a.id = aa.artist_id
+     WHERE aa.album_id = al.id) AS artist,
+    tot.plays,
+    tot.minutes,
+    tot.skip_rate,
+    most.title AS most_played_track,
+    least.title AS least_played_track
+FROM album_totals tot
+JOIN albums al ON al.id = tot.album_id
+JOIN track_counts mc ON mc.album_id = tot.album_id AND mc.most_rank = 1
+JOIN tracks most ON most.id = mc.track_id
+JOIN track_counts lc ON lc.album_id = tot.album_id AND lc.least_rank = 1
+JOIN tracks least ON least.id = lc.track_id
+ORDER BY
+    CASE WHEN %(sort)s = 'plays' THEN tot.plays END DESC,
+    CASE WHEN %(sort)s = 'skip_rate' THEN tot.skip_rate END DESC NULLS LAST,
+    tot.minutes DESC, tot.plays DESC, al.title
+LIMIT %(limit)s;
+"""
+
+# Artist names are unique, but may differ only in case.
+ARTISTS_BY_NAME_SQL = """
+SELECT
+    a.id AS artist_id,
+    a.name AS artist,
+    (SELECT COUNT(*)
+     FROM artist_tracks at
+     JOIN track_plays tp ON tp.track_id = at.track_id
+     WHERE at.artist_id = a.id) AS plays
+FROM artists a
+WHERE LOWER(a.name) = LOWER(%(name)s)
+ORDER BY plays DESC, a.name;
+"""
+
+ARTIST_BY_ID_SQL = """
+SELECT a.id AS artist_id, a.name AS artist
+FROM artists a
+WHERE a.id = %(artist_id)s;
+"""
+
+ARTIST_PLAYS = f"""
+    track_plays tp
+    JOIN tracks t ON t.id = tp.track_id
+    JOIN artist_tracks at ON at.track_id = tp.track_id
+    WHERE at.artist_id = %(artist_id)s
+    AND {PLAYED_IN_WINDOW}
+"""
+
+ARTIST_SUMMARY_SQL = f"""
+SELECT
+    COUNT(*) AS plays,
+    ROUND((COALESCE(SUM({LISTENED_MS}), 0) / 60000.0)::numeric, 1)::float8 AS minutes,
+    ROUND(COUNT(*) FILTER (WHERE tp.skipped)::numeric
+        / NULLIF(COUNT(*) FILTER (WHERE tp.skipped IS NOT NULL), 0), 3)::float8 AS skip_rate,
+    MIN(tp.played_at) AS first_played,
+    MAX(tp.played_at) AS last_played
+FROM {ARTIST_PLAYS};
+"""
+
+ARTIST_TIMELINE_SQL = f"""
+SELECT
+    date_trunc(%(granularity)s, tp.played_at AT TIME ZONE %(tz)s)::date AS bucket,
+    COUNT(*) AS plays,
+    ROUND((COALESCE(SUM({LISTENED_MS}), 0) / 60000.0)::numeric, 1)::float8 AS minutes
+FROM {ARTIST_PLAYS}
+GROUP BY 1
+ORDER BY 1;
+"""
+
+ARTIST_TOP_TRACKS_SQL = f"""
+SELECT
+    t.id AS track_id,
+    t.title,
+    COUNT(*) AS plays,
+    ROUND((COALESCE(SUM({LISTENED_MS}), 0) / 60000.0)::numeric, 1)::float8 AS minutes
+FROM {ARTIST_PLAYS}
+GROUP BY t.id, t.title
+ORDER BY plays DESC, minutes DESC, t.title
+LIMIT %(limit)s;
+"""
+
+ARTIST_TRACKS_SQL = f"""
+SELECT
+    t.id AS track_id,
+    t.title,
+    COUNT(*) AS plays,
+    ROUND((COALESCE(SUM({LISTENED_MS}), 0) / 60000.0)::numeric, 1)::float8 AS minutes,
+    COUNT(*) FILTER (WHERE tp.skipped) AS skips,
+    MIN(tp.played_at) AS first_played,
+    MAX(tp.played_at) AS last_played
+FROM {ARTIST_PLAYS}
+GROUP BY t.id, t.title
+ORDER BY plays DESC, minutes DESC, t.title;
+"""
+
+# Navidrome plays come from track_plays; other sources only have the play
+# counts of their library exports. matched_* covers the entries that are
+# linked to a local track.
+CROSS_PLATFORM_SQL = f"""
+SELECT
+    'navidrome' AS source,
+    COUNT(*) AS plays,
+    COUNT(DISTINCT tp.track_id) AS tracks,
+    NULL::bigint AS matched_tracks,
+    NULL::bigint AS matched_plays,
+    MAX(tp.played_at) AS last_played
+FROM track_plays tp
+WHERE {LONG_ENOUGH_PLAY}
+
+UNION ALL
+
+SELECT
+    et.source,
+    SUM(et.play_count) AS plays,
+    COUNT(*) AS tracks,
+    COUNT(et.track_id) AS matched_tracks,
+    COALESCE(SUM(et.play_count) FILTER (WHERE et.track_id IS NOT NULL), 0) AS matched_plays,
+    MAX(et.last_played_at) AS last_played
+FROM external_tracks et
+GROUP BY et.source
+
+ORDER BY plays DESC;
+"""
+
+# The listened fraction of a play is 1 - skip_score. Plays without a score
+# (recorded before it was stored, or never evaluated) are only counted.
+# Ordered-set aggregates skip NULLs, so they do not affect the percentiles.
+COMPLETION_SQL = f"""
+SELECT
+    COUNT(tp.skip_score) AS plays,
+    COUNT(*) - COUNT(tp.skip_score) AS unscored_plays,
+    PERCENTILE_CONT(ARRAY[0.1, 0.25, 0.5, 0.75, 0.9])
+        WITHIN GROUP (ORDER BY 1 - tp.skip_score) AS percentiles
+FROM track_plays tp
+WHERE tp.played_at >= now() - %(since)s
+AND {LONG_ENOUGH_PLAY};
+"""
+
+# Bucket 0 is [0%, 10%) listened, bucket 9 is [90%, 100%].
+COMPLETION_HISTOGRAM_SQL = f"""
+SELECT
+    LEAST(FLOOR((1 - tp.skip_score) * 10), 9)::int AS bucket,
+    COUNT(*) AS plays
+FROM track_plays tp
+WHERE tp.skip_score IS NOT NULL
+AND tp.played_at >= now() - %(since)s
+AND {LONG_ENOUGH_PLAY}
+GROUP BY 1
+ORDER BY 1;
+"""
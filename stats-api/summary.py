@@ -0,0 +1,32 @@
+"""
+Print a plain-text listening summary to the terminal.
+
+Shows the total time listened, plays, unique artists, skip rate and the top
+tracks, artists and genres of a window, from the same queries as
+GET /compare. Without a window it covers the last 30 days, like /dashboard.
+
+Usage: python summary.py [--from YYYY-MM-DD] [--to YYYY-MM-DD] [--limit 5]
+"""
+import argparse
+from datetime import date, timedelta
+
+from app import app, period_summary
+from reports import render_summary_text
+
+if __name__ == "__main__":
+    parser = argparse.ArgumentParser(description="Print a plain-text listening summary")
+    parser.add_argument("--from", dest="date_from", type=date.fromisoformat,
+                        help="first local day (default: 29 days before --to)")
+    parser.add_argument("--to", dest="date_to", type=date.fromisoformat,
+                        help="last local day (default: today)")
+    parser.add_argument("--limit", type=int, default=5, help="entries per top list (default: 5)")
+    args = parser.parse_args()
+
+    date_to = args.date_to or app.db_reader.local_today()
+    date_from = args.date_from or date_to - timedelta(days=29)
+    if date_from > date_to:
+        parser.error("--from must not be after --to")
+    if args.limit < 1:
+        parser.error("--limit must be at least 1")
+
+    print(render_summary_text(period_summary(app.db_reader, date_from, date_to, limit=args.limit)), end="")
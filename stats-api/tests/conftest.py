@@ -0,0 +1,140 @@
+import os
+import sys
+from contextlib import closing
+from datetime import datetime
+from typing import Optional
+from unittest import mock
+
+import psycopg2
+import pytest
+
+# The services import their modules by plain name from their own directory;
+# the Dockerfile copies the shared listener package next to them.
+ROOT = os.path.dirname(os.path.dirname(os.path.abspath(__file__)))
+sys.path.insert(0, ROOT)
+sys.path.insert(1, os.path.join(os.path.dirname(ROOT), "listener"))
+
+SCHEMA_FILE = os.path.join(os.path.dirname(ROOT), "db_init.sql")
+
+# CORS is set up when app.py is imported; test_cors.py relies on this origin.
+os.environ["CORS_ALLOWED_ORIGINS"] = "https://dash.example.com"
+
+# app.py connects to Postgres on import. Endpoint tests replace
+# app.db_reader with a mock; tests that need a database use the fixtures below.
+with mock.patch("psycopg2.connect"), mock.patch("psycopg2.pool.ThreadedConnectionPool"):
+    import app as stats_api  # noqa: E402
+
+
+@pytest.fixture(autouse=True)
+def open_api(monkeypatch):
+    """Run every test without an API key, with an empty response cache and a fresh rate limit."""
+    monkeypatch.setattr(stats_api, "STATS_API_KEY", None)
+    monkeypatch.setattr(stats_api, "response_cache", stats_api.TTLCache(60, 256))
+    monkeypatch.setattr(stats_api, "query_limiter", stats_api.RateLimiter(stats_api.QUERY_RATE_LIMIT))
+
+
+@pytest.fixture
+def reader(monkeypatch):
+    """A mocked DatabaseReader that the endpoints read from."""
+    reader = mock.Mock(spec=stats_api.DatabaseReader)
+    reader.min_play_ms = None
+    monkeypatch.setattr(stats_api.app, "db_reader", reader, raising=False)
+    return reader
+
+
+@pytest.fixture
+def client():
+    return stats_api.app.test_client()
+
+
+@pytest.fixture
+def database_url():
+    """
+    TEST_DATABASE_URL, after recreating its public schema from db_init.sql.
+    Tests using it are skipped when it is not set.
+    """
+    url = os.getenv("TEST_DATABASE_URL")
+    if not url:
+        pytest.skip("TEST_DATABASE_URL is not set")
+
+    with open(SCHEMA_FILE, encoding="utf-8") as f:
+        # psql meta-commands such as \restrict are not SQL.
+        schema = "".join(line for line in f if not line.startswith("\\"))
+    # The dump clears the search_path for its session, so it gets its own.
+    with closing(psycopg2.connect(url)) as conn:
+        conn.autocommit = True
+        with conn.cursor() as cur:
+            cur.execute("DROP SCHEMA IF EXISTS public CASCADE; CREATE SCHEMA public;")
+            cur.execute(schema)
+    return url
+
+
+@pytest.fixture
+def db(database_url):
+    """An autocommit connection to the freshly created test database."""
+    with closing(psycopg2.connect(database_url)) as conn:
+        conn.autocommit = True
+        yield conn
+
+
+@pytest.fixture
+def db_reader(db):
+    """A DatabaseReader on the test database that keeps every play."""
+    return stats_api.DatabaseReader(db, min_play_ms=0)
+
+
+class Seed:
+    """Inserts rows into the test database, creating users and artists by name."""
+
+    def __init__(self, conn):
+        self.conn = conn
+        self.users = {}
+        self.artists = {}
+
+    def _insert(self, sql: str, params: tuple) -> int:
+        with self.conn.cursor() as cur:
+            cur.execute(sql, params)
+            return cur.fetchone()[0]
+
+    def user(self, username: str = "admin") -> int:
+        if username not in self.users:
+            self.users[username] = self._insert("INSERT INTO users (username) VALUES (%s) RETURNING id;", (username,))
+        return self.users[username]
+
+    def artist(self, name: str) -> int:
+        if name not in self.artists:
+            self.artists[name] = self._insert("INSERT INTO artists (name) VALUES (%s) RETURNING id;", (name,))
+        return self.artists[name]
+
+    def track(self, title: str, artists: tuple = ("Radiohead",), duration_ms: Optional[int] = 240000) -> int:
+        track_id = self._insert("INSERT INTO tracks (title, duration_ms) VALUES (%s, %s) RETURNING id;",
+                                (title, duration_ms))
+        with self.conn.cursor() as cur:
+            for name in artists:
+                cur.execute("INSERT INTO artist_tracks (artist_id, track_id) VALUES (%s, %s);",
+                            (self.artist(name), track_id))
+        return track_id
+
+    def genres(self, artist: str, *names: str):
+        with self.conn.cursor() as cur:
+            for name in names:
+                cur.execute("INSERT INTO genres (name) VALUES (%s) ON CONFLICT (name) DO NOTHING;", (name,))
+                cur.execute(
+                    "INSERT INTO artist_genres (artist_id, genre_id) SELECT %s, id FROM genres WHERE name = %s;",
+                    (self.artist(artist), name),
+                )
+
+    def play(self, track_id: int, played_at: datetime, skipped: bool = False,
+             skip_score: Optional[float] = None, user: str = "admin", device_name: Optional[str] = None) -> int:
+        return self._insert(
+            """
+            INSERT INTO track_plays (track_id, played_at, skipped, skip_score, user_id, device_name)
+            VALUES (%s, %s, %s, %s, %s, %s) RETURNING id;
+            """,
+            (track_id, played_at, skipped, skip_score, self.user(user), device_name),
+        )
+
+
+@pytest.fixture
+def seed(db):
+    return Seed(db)
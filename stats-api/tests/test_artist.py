@@ -0,0 +1,19 @@
+def test_ambiguous_name_is_a_conflict_listing_the_candidates(client, reader):
+    matches = [{"artist_id": 3, "artist": "Nirvana", "plays": 40}, {"artist_id": 9, "artist": "nirvana", "plays": 2}]
+    reader.artists_by_name.return_value = matches
+
+    response = client.get("/artist?name=Nirvana")
+
+    assert response.status_code == 409
+    assert response.get_json()["matches"] == matches
+    reader.artist_summary.assert_not_called()
+
+
+def test_unknown_name_is_not_found(client, reader):
+    reader.artists_by_name.return_value = []
+
+    assert client.get("/artist?name=Nobody").status_code == 404
+
+
+def test_name_or_id_is_required(client, reader):
+    assert client.get("/artist").status_code == 400
@@ -0,0 +1,34 @@
+from datetime import datetime, timedelta, timezone
+
+import app as stats_api
+
+START = datetime(2024, 3, 1, 18, 0, tzinfo=timezone.utc)
+
+
+def test_leaderboard_ranks_by_time_not_play_count(db_reader, seed, client, monkeypatch):
+    monkeypatch.setattr(stats_api.app, "db_reader", db_reader, raising=False)
+    storm = seed.track("Storm", artists=("Godspeed You! Black Emperor",), duration_ms=1200000)
+    punk = seed.track("Judy Is a Punk", artists=("Ramones",), duration_ms=92000)
+    for hour in range(2):
+        seed.play(storm, START + timedelta(hours=hour))
+    for minute in range(4):
+        seed.play(punk, START + timedelta(days=1, minutes=2 * minute))
+    # Half listened, so it adds half the track's length.
+    seed.play(punk, START + timedelta(days=2), skipped=True, skip_score=0.5)
+
+    body = client.get("/artist-leaderboard").get_json()
+
+    assert [(row["rank"], row["artist"], row["total_ms"], row["plays"]) for row in body["artists"]] == [
+        (1, "Godspeed You! Black Emperor", 2400000, 2),
+        (2, "Ramones", 414000, 5),
+    ]
+    by_plays = sorted(body["artists"], key=lambda row: row["plays"], reverse=True)
+    assert [row["artist"] for row in by_plays] != [row["artist"] for row in body["artists"]]
+
+
+def test_limit_is_passed_to_the_reader(client, reader):
+    reader.artist_leaderboard.return_value = []
+
+    client.get("/artist-leaderboard?limit=5")
+
+    reader.artist_leaderboard.assert_called_once_with(5)
@@ -0,0 +1,43 @@
+from datetime import datetime, timedelta, timezone
+
+import pytest
+
+import app as stats_api
+
+START = datetime(2024, 3, 1, 18, 0, tzinfo=timezone.utc)
+
+
+def normalized(db, name: str) -> str:
+    with db.cursor() as cur:
+        cur.execute("SELECT normalize_artist_name(%s);", (name,))
+        return cur.fetchone()[0]
+
+
+@pytest.mark.parametrize("variants, key", [
+    (("The Beatles", "Beatles", "the beatles", "  THE   Beatles ", "The\tBeatles"), "beatles"),
+    (("Massive Attack", "massive  attack", "MASSIVE ATTACK"), "massive attack"),
+    (("The The", "the the"), "the"),
+])
+def test_name_variants_collapse_to_one_key(db, variants, key):
+    assert {normalized(db, name) for name in variants} == {key}
+
+
+def test_the_is_only_dropped_as_a_leading_word(db):
+    assert normalized(db, "Theatre of Tragedy") == "theatre of tragedy"
+    assert normalized(db, "Bathe the Cat") == "bathe the cat"
+
+
+def test_top_artists_count_variants_as_one(db_reader, seed, db, monkeypatch):
+    monkeypatch.setattr(stats_api, "USER_TIMEZONE", "UTC")
+    help_track = seed.track("Help!", artists=("The Beatles",))
+    something = seed.track("Something", artists=("Beatles",))
+    seed.play(help_track, START)
+    for hour in range(1, 3):
+        seed.play(something, START + timedelta(hours=hour))
+
+    rows = db_reader.top_artists(None, None, limit=10)
+
+    assert [(row["artist"], row["plays"]) for row in rows] == [("Beatles", 3)]
+    with db.cursor() as cur:
+        cur.execute("SELECT name FROM artists ORDER BY id;")
+        assert [name for (name,) in cur.fetchall()] == ["The Beatles", "Beatles"]
@@ -0,0 +1,52 @@
+from datetime import datetime, timedelta, timezone
+
+import pytest
+
+import app as stats_api
+
+START = datetime(2024, 3, 1, 18, 0, tzinfo=timezone.utc)
+
+
+@pytest.fixture
+def radiohead(db_reader, seed, monkeypatch):
+    monkeypatch.setattr(stats_api, "USER_TIMEZONE", "UTC")
+    monkeypatch.setattr(stats_api.app, "db_reader", db_reader, raising=False)
+    reckoner = seed.track("Reckoner", duration_ms=290000)
+    nude = seed.track("Nude", duration_ms=255000)
+    collaboration = seed.track("Everything in Its Right Place", artists=("Radiohead", "Björk"), duration_ms=251000)
+    other = seed.track("Hyperballad", artists=("Björk",), duration_ms=321000)
+    for hour in range(3):
+        seed.play(reckoner, START + timedelta(hours=hour))
+    seed.play(nude, START + timedelta(days=1))
+    seed.play(nude, START + timedelta(days=1, hours=1), skipped=True)
+    seed.play(collaboration, START + timedelta(days=2))
+    seed.play(other, START + timedelta(days=2, hours=1))
+    return seed.artist("Radiohead")
+
+
+def test_lists_every_played_track_of_the_artist(client, radiohead):
+    body = client.get(f"/artists/{radiohead}/tracks").get_json()
+
+    assert body["artist"] == "Radiohead"
+    assert [(row["title"], row["plays"], row["minutes"], row["skips"]) for row in body["tracks"]] == [
+        ("Reckoner", 3, 14.5, 0),
+        ("Nude", 2, 4.3, 1),
+        ("Everything in Its Right Place", 1, 4.2, 0),
+    ]
+    assert datetime.fromisoformat(body["tracks"][0]["first_played"]) == START
+    assert datetime.fromisoformat(body["tracks"][0]["last_played"]) == START + timedelta(hours=2)
+
+
+def test_window_limits_the_plays(client, radiohead):
+    body = client.get(f"/artists/{radiohead}/tracks?from=2024-03-02&to=2024-03-02").get_json()
+
+    assert [(row["title"], row["plays"]) for row in body["tracks"]] == [("Nude", 2)]
+
+
+def test_unknown_artist(client, reader):
+    reader.artist_by_id.return_value = None
+
+    response = client.get("/artists/999/tracks")
+
+    assert response.status_code == 404
+    reader.artist_tracks.assert_not_called()
@@ -0,0 +1,53 @@
+from datetime import date
+
+import pytest
+
+import app as stats_api
+
+KEY = "s3cret-key"
+
+
+@pytest.fixture
+def api_key(monkeypatch):
+    monkeypatch.setattr(stats_api, "STATS_API_KEY", KEY)
+
+
+@pytest.fixture
+def weekdays(reader):
+    reader.plays_by_weekday.return_value = [{"weekday": day, "plays": 0, "minutes": 0.0} for day in range(1, 8)]
+    return reader
+
+
+def test_open_without_a_configured_key(client, weekdays):
+    assert client.get("/by-weekday").status_code == 200
+
+
+def test_authorized_with_bearer_key(client, weekdays, api_key):
+    response = client.get("/by-weekday", headers={"Authorization": f"Bearer {KEY}"})
+
+    assert response.status_code == 200
+
+
+@pytest.mark.parametrize("authorization", [None, "Bearer wrong-key", f"Basic {KEY}", KEY, "Bearer "])
+def test_unauthorized_without_the_key(client, weekdays, api_key, authorization):
+    headers = {"Authorization": authorization} if authorization else {}
+
+    response = client.get("/by-weekday", headers=headers)
+
+    assert response.status_code == 401
+    assert response.headers["WWW-Authenticate"] == "Bearer"
+    weekdays.plays_by_weekday.assert_not_called()
+
+
+@pytest.mark.parametrize("path", ["/healthz", "/openapi.json"])
+def test_public_paths_need_no_key(client, api_key, path):
+    assert client.get(path).status_code == 200
+
+
+def test_cached_responses_also_need_the_key(client, weekdays, api_key):
+    client.get("/by-weekday?from=2024-03-01", headers={"Authorization": f"Bearer {KEY}"})
+
+    response = client.get("/by-weekday?from=2024-03-01")
+
+    assert response.status_code == 401
+    weekdays.plays_by_weekday.assert_called_once_with(date(2024, 3, 1), None)
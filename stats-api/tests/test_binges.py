@@ -0,0 +1,57 @@
+from datetime import datetime, timedelta, timezone
+
+import app as stats_api
+
+START = datetime(2024, 6, 1, 20, 0, tzinfo=timezone.utc)
+SLACK = timedelta(seconds=30)
+
+
+def plays(*entries) -> list[dict]:
+    """(track_id, minutes after START, user_id) per play, 3-minute tracks."""
+    return [{
+        "user_id": user_id,
+        "track_id": track_id,
+        "title": f"Track {track_id}",
+        "artist": "Radiohead",
+        "duration_ms": 180000,
+        "played_at": START + timedelta(minutes=minutes),
+    } for track_id, minutes, user_id in entries]
+
+
+def test_one_clear_binge():
+    sequence = plays(
+        (1, 0, 1), (2, 3, 1),
+        # Track 3 four times back to back, the last one 30 seconds late.
+        (3, 6, 1), (3, 9, 1), (3, 12, 1), (3, 15.5, 1),
+        (4, 18.5, 1), (3, 21.5, 1),
+    )
+
+    binges = stats_api.detect_binges(sequence, SLACK, min_count=3)
+
+    assert binges == [{
+        "track_id": 3,
+        "title": "Track 3",
+        "artist": "Radiohead",
+        "count": 4,
+        "started_at": (START + timedelta(minutes=6)).isoformat(),
+        "ended_at": (START + timedelta(minutes=15.5)).isoformat(),
+    }]
+
+
+def test_gap_longer_than_track_and_slack_ends_run():
+    sequence = plays((3, 0, 1), (3, 3, 1), (3, 7, 1), (3, 10, 1))
+
+    assert stats_api.detect_binges(sequence, SLACK, min_count=3) == []
+    assert [b["count"] for b in stats_api.detect_binges(sequence, SLACK, min_count=2)] == [2, 2]
+
+
+def test_runs_do_not_span_users():
+    sequence = plays((3, 0, 1), (3, 3, 1), (3, 6, 2))
+
+    assert stats_api.detect_binges(sequence, SLACK, min_count=3) == []
+
+
+def test_most_recent_binge_first():
+    sequence = plays((1, 0, 1), (1, 3, 1), (2, 6, 1), (2, 9, 1))
+
+    assert [b["track_id"] for b in stats_api.detect_binges(sequence, SLACK, min_count=2)] == [2, 1]
@@ -0,0 +1,80 @@
+import pytest
+
+import app as stats_api
+from app import TTLCache
+
+
+@pytest.fixture
+def clock(monkeypatch):
+    now = [1000.0]
+    monkeypatch.setattr(stats_api.time, "monotonic", lambda: now[0])
+    return now
+
+
+@pytest.fixture
+def weekdays(reader, monkeypatch):
+    monkeypatch.setattr(stats_api, "RESPONSE_CACHE_TTL", 60)
+    reader.plays_by_weekday.return_value = [{"weekday": day, "plays": day, "minutes": 0.0} for day in range(1, 8)]
+    return reader
+
+
+def test_entries_expire_after_ttl(clock):
+    cache = TTLCache(ttl=60, max_entries=10)
+    cache.set("key", "value")
+
+    clock[0] += 59
+    assert cache.get("key") == "value"
+    clock[0] += 1
+    assert cache.get("key") is None
+
+
+def test_oldest_entry_is_dropped_when_full(clock):
+    cache = TTLCache(ttl=60, max_entries=2)
+    for key in ("a", "b", "c"):
+        cache.set(key, key.upper())
+
+    assert [cache.get(key) for key in ("a", "b", "c")] == [None, "B", "C"]
+
+
+def test_identical_request_within_ttl_is_served_from_cache(client, weekdays):
+    first = client.get("/by-weekday?from=2024-03-01&to=2024-03-31")
+    second = client.get("/by-weekday?to=2024-03-31&from=2024-03-01")
+
+    assert second.get_json() == first.get_json()
+    weekdays.plays_by_weekday.assert_called_once()
+
+
+def test_request_after_ttl_queries_again(client, weekdays, clock):
+    client.get("/by-weekday?from=2024-03-01")
+    clock[0] += 61
+    client.get("/by-weekday?from=2024-03-01")
+
+    assert weekdays.plays_by_weekday.call_count == 2
+
+
+def test_other_parameters_are_cached_separately(client, weekdays):
+    client.get("/by-weekday?from=2024-03-01")
+    client.get("/by-weekday?from=2024-04-01")
+
+    assert weekdays.plays_by_weekday.call_count == 2
+
+
+def test_nocache_bypasses_the_cache(client, weekdays):
+    client.get("/by-weekday?from=2024-03-01")
+    client.get("/by-weekday?from=2024-03-01&nocache=1")
+
+    assert weekdays.plays_by_weekday.call_count == 2
+
+
+def test_nocache_response_is_not_stored(client, weekdays):
+    client.get("/by-weekday?from=2024-03-01&nocache=1")
+    client.get("/by-weekday?from=2024-03-01")
+
+    assert weekdays.plays_by_weekday.call_count == 2
+
+
+def test_errors_are_not_cached(client, weekdays):
+    client.get("/by-weekday?from=2024-13-01")
+    client.get("/by-weekday?from=2024-13-01")
+
+    assert stats_api.response_cache._entries == {}
@@ -0,0 +1,78 @@
+from datetime import date
+
+import pytest
+
+from app import InvalidParameter, list_changes, parse_period, percent_change
+
+TODAY = date(2024, 8, 15)
+
+
+@pytest.mark.parametrize("value, expected", [
+    ("2024-03", (date(2024, 3, 1), date(2024, 3, 31))),
+    ("2024-02", (date(2024, 2, 1), date(2024, 2, 29))),
+    ("2024-03-15", (date(2024, 3, 15), date(2024, 3, 15))),
+    ("2024-Q1", (date(2024, 1, 1), date(2024, 3, 31))),
+    ("2024-Q4", (date(2024, 10, 1), date(2024, 12, 31))),
+    ("2024-Q1..2024-Q2", (date(2024, 1, 1), date(2024, 6, 30))),
+    ("2024-01..2024-06", (date(2024, 1, 1), date(2024, 6, 30))),
+    ("2024-01-05..2024-02-10", (date(2024, 1, 5), date(2024, 2, 10))),
+    ("last-30d", (date(2024, 7, 17), date(2024, 8, 15))),
+    ("previous-30d", (date(2024, 6, 17), date(2024, 7, 16))),
+    ("last-1w", (date(2024, 8, 9), date(2024, 8, 15))),
+])
+def test_parse_period(value, expected):
+    assert parse_period(value, TODAY) == expected
+
+
+@pytest.mark.parametrize("value", ["2024-13", "2024-Q5", "2024-1-5", "last-30x", "yesterday"])
+def test_parse_period_rejects_invalid_bounds(value):
+    with pytest.raises(InvalidParameter):
+        parse_period(value, TODAY)
+
+
+def test_percent_change():
+    assert percent_change(200, 250) == 25.0
+    assert percent_change(3, 2) == -33.3
+    assert percent_change(10, 10) == 0.0
+    assert percent_change(0, 5) is None
+
+
+def test_list_changes_keeps_rank_order():
+    changes = list_changes(["Radiohead", "Björk", "Portishead"], ["Portishead", "Massive Attack", "Radiohead"])
+
+    assert changes == {"new": ["Massive Attack"], "dropped": ["Björk"]}
+
+
+def test_endpoint_reports_deltas_and_list_changes(client, reader):
+    reader.local_today.return_value = TODAY
+    totals = {
+        date(2024, 1, 1): {"minutes": 600.0, "plays": 200, "skips": 20, "unique_artists": 40},
+        date(2024, 7, 1): {"minutes": 900.0, "plays": 250, "skips": 50, "unique_artists": 30},
+    }
+    artists = {
+        date(2024, 1, 1): [{"artist": "Radiohead", "plays": 50}, {"artist": "Björk", "plays": 20}],
+        date(2024, 7, 1): [{"artist": "Radiohead", "plays": 40}, {"artist": "Portishead", "plays": 35}],
+    }
+    reader.listening_totals.side_effect = lambda date_from, date_to: dict(totals[date_from])
+    reader.top_artists.side_effect = lambda date_from, date_to, limit: artists[date_from]
+    reader.top_tracks.return_value = []
+    reader.top_genres.return_value = [{"genre": "trip hop", "plays": 30}, {"genre": "art rock", "plays": 10}]
+
+    body = client.get("/compare?a=2024-01..2024-06&b=2024-07..2024-12").get_json()
+
+    assert (body["a"]["from"], body["a"]["to"]) == ("2024-01-01", "2024-06-30")
+    assert (body["b"]["from"], body["b"]["to"]) == ("2024-07-01", "2024-12-31")
+    assert body["delta"] == {"minutes": 300.0, "plays": 50, "unique_artists": -10, "skip_rate": 0.1}
+    assert body["delta_pct"]["plays"] == 25.0
+    assert body["top_artists"] == {"new": ["Portishead"], "dropped": ["Björk"]}
+    assert body["top_genres"] == {"new": [], "dropped": []}
+    assert body["a"]["top_genres"][0]["share"] == 0.75
+
+
+def test_endpoint_rejects_a_period_that_ends_before_it_starts(client, reader):
+    reader.local_today.return_value = TODAY
+
+    response = client.get("/compare?a=2024-06..2024-01&b=2024-07")
+
+    assert response.status_code == 400
+    reader.listening_totals.assert_not_called()
@@ -0,0 +1,34 @@
+from config import CORS_ALLOWED_ORIGINS
+
+ALLOWED = "https://dash.example.com"
+PREFLIGHT = {"Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "Authorization"}
+
+
+def test_test_origin_is_configured():
+    assert CORS_ALLOWED_ORIGINS == [ALLOWED]
+
+
+def test_preflight_from_allowed_origin(client):
+    response = client.options("/top-albums", headers={"Origin": ALLOWED, **PREFLIGHT})
+
+    assert response.status_code == 200
+    assert response.headers["Access-Control-Allow-Origin"] == ALLOWED
+    assert "GET" in response.headers["Access-Control-Allow-Methods"]
+    assert "authorization" in response.headers["Access-Control-Allow-Headers"].lower()
+
+
+def test_preflight_from_other_origin(client):
+    response = client.options("/top-albums", headers={"Origin": "https://evil.example.com", **PREFLIGHT})
+
+    assert "Access-Control-Allow-Origin" not in response.headers
+    assert "Access-Control-Allow-Methods" not in response.headers
+    assert "Access-Control-Allow-Headers" not in response.headers
+
+
+def test_preflight_needs_no_api_key(client, monkeypatch):
+    monkeypatch.setattr("app.STATS_API_KEY", "secret")
+
+    response = client.options("/top-albums", headers={"Origin": ALLOWED, **PREFLIGHT})
+
+    assert response.status_code == 200
+    assert response.headers["Access-Control-Allow-Origin"] == ALLOWED
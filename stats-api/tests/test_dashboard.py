@@ -0,0 +1,68 @@
+from datetime import date, datetime, timezone
+from unittest import mock
+
+import psycopg2.pool
+import pytest
+
+import app as stats_api
+from app import build_dashboard
+
+
+@pytest.fixture
+def db_pool(database_url):
+    pool = psycopg2.pool.ThreadedConnectionPool(1, stats_api.DASHBOARD_WORKERS, database_url)
+    yield pool
+    pool.closeall()
+
+
+def test_dashboard_sections_from_seeded_plays(db_pool, seed, monkeypatch):
+    monkeypatch.setattr(stats_api, "USER_TIMEZONE", "UTC")
+    teardrop = seed.track("Teardrop", artists=("Massive Attack",), duration_ms=330000)
+    angel = seed.track("Angel", artists=("Massive Attack",), duration_ms=380000)
+    reckoner = seed.track("Reckoner", duration_ms=290000)
+    seed.genres("Massive Attack", "trip hop")
+    seed.genres("Radiohead", "art rock")
+    seed.play(teardrop, datetime(2024, 3, 1, 10, 0, tzinfo=timezone.utc))
+    seed.play(angel, datetime(2024, 3, 1, 10, 6, tzinfo=timezone.utc))
+    seed.play(teardrop, datetime(2024, 3, 2, 11, 0, tzinfo=timezone.utc))
+    seed.play(reckoner, datetime(2024, 3, 2, 12, 0, tzinfo=timezone.utc), skipped=True)
+
+    dashboard = build_dashboard(db_pool, date(2024, 3, 1), date(2024, 3, 31), limit=10, min_play_ms=0)
+
+    assert set(dashboard) == {"top_tracks", "top_artists", "top_genres", "daily", "skip_rate", "totals"}
+    assert [(row["title"], row["plays"]) for row in dashboard["top_tracks"]] == [("Teardrop", 2), ("Angel", 1)]
+    assert [(row["artist"], row["plays"]) for row in dashboard["top_artists"]] == [("Massive Attack", 3)]
+    assert [(row["genre"], row["plays"]) for row in dashboard["top_genres"]] == [("trip hop", 3)]
+    assert [(row["day"], row["plays"], row["skips"], row["minutes"]) for row in dashboard["daily"]] == [
+        ("2024-03-01", 2, 0, 11.8),
+        ("2024-03-02", 2, 1, 5.5),
+    ]
+    assert dashboard["skip_rate"] == 0.25
+    assert dashboard["totals"]["plays"] == 4
+
+
+def test_empty_window_has_a_skip_rate_of_zero(db_pool):
+    dashboard = build_dashboard(db_pool, date(2024, 3, 1), date(2024, 3, 31), limit=10, min_play_ms=0)
+
+    # The same as period_summary, so clients check one value for "no plays".
+    assert dashboard["skip_rate"] == 0.0
+    assert dashboard["top_tracks"] == []
+
+
+def test_endpoint_defaults_to_the_last_30_days(client, reader, monkeypatch):
+    reader.local_today.return_value = date(2024, 3, 31)
+    sections = {"top_tracks": [], "top_artists": [], "top_genres": [], "daily": [], "skip_rate": 0.0,
+                "totals": {"plays": 0}}
+    monkeypatch.setattr(stats_api, "build_dashboard", mock.Mock(return_value=sections))
+
+    body = client.get("/dashboard").get_json()
+
+    assert (body["from"], body["to"]) == ("2024-03-02", "2024-03-31")
+    stats_api.build_dashboard.assert_called_once_with(
+        stats_api.app.db_pool, date(2024, 3, 2), date(2024, 3, 31), 10, stats_api.MIN_PLAY_MS)
+
+
+def test_endpoint_rejects_an_inverted_window(client, reader):
+    response = client.get("/dashboard?from=2024-03-31&to=2024-03-01")
+
+    assert response.status_code == 400
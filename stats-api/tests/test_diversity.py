@@ -0,0 +1,49 @@
+import math
+from datetime import date
+
+import pytest
+
+from app import listening_diversity
+
+CONCENTRATED = date(2024, 1, 1)
+SPREAD = date(2024, 2, 1)
+
+
+def rows(bucket: date, plays: int, minutes: dict, by: str = "genre") -> list[dict]:
+    return [{"bucket": bucket, "plays": plays, by: name, "minutes": value} for name, value in minutes.items()]
+
+
+@pytest.mark.parametrize("by", ["genre", "artist"])
+def test_concentrated_period_scores_lower_than_spread_one(by):
+    concentrated, spread = listening_diversity(
+        rows(CONCENTRATED, 60, {"trip hop": 180.0, "art rock": 10.0, "jazz": 10.0}, by)
+        + rows(SPREAD, 60, {"trip hop": 50.0, "art rock": 50.0, "jazz": 50.0, "ambient": 50.0}, by),
+        min_plays=50, by=by,
+    )
+
+    assert concentrated["entropy"] < spread["entropy"]
+    assert concentrated["gini_simpson"] < spread["gini_simpson"]
+    assert concentrated[f"top_{by}"] == "trip hop"
+    assert concentrated[f"top_{by}_share"] == 0.9
+
+
+def test_even_spread_reaches_the_maximum():
+    (bucket,) = listening_diversity(rows(SPREAD, 60, {"a": 5.0, "b": 5.0, "c": 5.0, "d": 5.0}), min_plays=50)
+
+    assert bucket["entropy"] == round(math.log2(4), 3)
+    assert bucket["gini_simpson"] == 0.75
+    assert bucket["genres"] == 4
+
+
+def test_single_genre_scores_zero():
+    (bucket,) = listening_diversity(rows(CONCENTRATED, 60, {"trip hop": 200.0}), min_plays=50)
+
+    assert (bucket["entropy"], bucket["gini_simpson"]) == (0.0, 0.0)
+
+
+def test_buckets_with_few_plays_are_not_scored():
+    (bucket,) = listening_diversity(rows(CONCENTRATED, 10, {"trip hop": 20.0, "jazz": 20.0}), min_plays=50)
+
+    assert bucket["low_confidence"]
+    assert (bucket["entropy"], bucket["gini_simpson"]) == (None, None)
+    assert bucket["top_genre_share"] == 0.5
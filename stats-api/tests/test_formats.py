@@ -0,0 +1,73 @@
+import csv
+import io
+
+import pytest
+
+from formats import display_width, render, render_csv, render_markdown, render_table, truncate
+
+
+@pytest.mark.parametrize("text, width", [
+    ("Radiohead", 9),
+    ("坂本龍一", 8),
+    ("Ｔｅｓｔ", 8),
+    ("Sigur Ro\u0301s", 9),
+    ("", 0),
+])
+def test_display_width(text, width):
+    assert display_width(text) == width
+
+
+def test_truncate_counts_wide_characters_twice():
+    assert truncate("坂本龍一 - Merry Christmas", 6) == "坂本…"
+    assert truncate("Airbag", 6) == "Airbag"
+    assert truncate("Paranoid Android", 8) == "Paranoi…"
+
+
+def test_table_aligns_wide_characters():
+    rows = [{"artist": "坂本龍一", "plays": 7}, {"artist": "Björk", "plays": 12}]
+
+    lines = render_table(["artist", "plays"], rows).splitlines()
+
+    assert lines == [
+        "artist    plays",
+        "--------  -----",
+        "坂本龍一      7",
+        "Björk        12",
+    ]
+
+
+def test_table_truncates_and_flattens_long_cells():
+    rows = [{"title": "Subterranean\nHomesick Alien", "plays": None}]
+
+    lines = render_table(["title", "plays"], rows, max_cell_width=15).splitlines()
+
+    assert lines[2] == "Subterranean H…"
+
+
+def test_csv_quotes_separators_and_quotes():
+    rows = [{"title": 'Say "Yes", Maybe', "artists": ["Simon", "Garfunkel"], "skipped": False}]
+
+    text = render_csv(["title", "artists", "skipped"], rows)
+
+    assert text == 'title,artists,skipped\n"Say ""Yes"", Maybe","Simon, Garfunkel",false\n'
+    assert list(csv.reader(io.StringIO(text)))[1] == ['Say "Yes", Maybe', "Simon, Garfunkel", "false"]
+
+
+def test_markdown_escapes_pipes_backslashes_and_newlines():
+    rows = [{"title": "Either|Or", "album": "C:\\Music\nDisc 1"}]
+
+    assert render_markdown(["title", "album"], rows).splitlines() == [
+        "| title | album |",
+        "| --- | --- |",
+        "| Either\\|Or | C:\\\\Music Disc 1 |",
+    ]
+
+
+def test_render_flattens_nested_rows():
+    rows = [{"day": "2024-03-01", "top_track": {"title": "Teardrop", "plays": 3}}]
+
+    assert render("csv", rows) == "day,top_track.title,top_track.plays\n2024-03-01,Teardrop,3\n"
+
+
+def test_empty_table():
+    assert render("table", []) == "(no rows)\n"
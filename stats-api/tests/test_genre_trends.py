@@ -0,0 +1,58 @@
+from datetime import date, datetime, timedelta, timezone
+
+import app as stats_api
+from app import genre_trends
+
+JANUARY = datetime(2024, 1, 10, 20, 0, tzinfo=timezone.utc)
+FEBRUARY = datetime(2024, 2, 10, 20, 0, tzinfo=timezone.utc)
+
+
+def row(bucket: date, genre, genre_plays: float) -> dict:
+    return {"bucket": bucket, "plays": 0, "genre": genre, "minutes": 0.0, "genre_plays": genre_plays}
+
+
+def test_shares_per_bucket_with_other():
+    rows = [
+        row(date(2024, 1, 1), "trip hop", 6),
+        row(date(2024, 1, 1), "art rock", 3),
+        row(date(2024, 1, 1), "jazz", 1),
+        row(date(2024, 2, 1), "art rock", 4),
+        row(date(2024, 2, 1), "trip hop", 1),
+    ]
+
+    genres, buckets = genre_trends(rows, top=2)
+
+    assert genres == ["art rock", "trip hop"]
+    assert buckets == [
+        {"bucket": "2024-01-01", "plays": 10, "shares": {"art rock": 0.3, "trip hop": 0.6, "other": 0.1}},
+        {"bucket": "2024-02-01", "plays": 5, "shares": {"art rock": 0.8, "trip hop": 0.2, "other": 0.0}},
+    ]
+
+
+def test_bucket_without_known_genres_has_no_shares():
+    genres, buckets = genre_trends([row(date(2024, 1, 1), None, 0)], top=5)
+
+    assert genres == []
+    assert buckets == [{"bucket": "2024-01-01", "plays": 0, "shares": {"other": 0.0}}]
+
+
+def test_two_months_of_shifting_genres(db_reader, seed, client, monkeypatch):
+    monkeypatch.setattr(stats_api, "USER_TIMEZONE", "UTC")
+    monkeypatch.setattr(stats_api.app, "db_reader", db_reader, raising=False)
+    teardrop = seed.track("Teardrop", artists=("Massive Attack",))
+    reckoner = seed.track("Reckoner", artists=("Radiohead",))
+    seed.genres("Massive Attack", "trip hop")
+    seed.genres("Radiohead", "art rock")
+    for month, trip_hop, art_rock in ((JANUARY, 3, 1), (FEBRUARY, 1, 3)):
+        for i in range(trip_hop):
+            seed.play(teardrop, month + timedelta(hours=i))
+        for i in range(art_rock):
+            seed.play(reckoner, month + timedelta(days=1, hours=i))
+
+    body = client.get("/genre-trends?from=2024-01-01&to=2024-02-29&bucket=month&top=2").get_json()
+
+    assert body["genres"] == ["art rock", "trip hop"]
+    assert [(b["bucket"], b["plays"], b["shares"]) for b in body["buckets"]] == [
+        ("2024-01-01", 4, {"art rock": 0.25, "trip hop": 0.75, "other": 0.0}),
+        ("2024-02-01", 4, {"art rock": 0.75, "trip hop": 0.25, "other": 0.0}),
+    ]
@@ -0,0 +1,77 @@
+from datetime import datetime, timedelta, timezone
+
+import pytest
+
+START = datetime(2024, 3, 1, 12, 0, tzinfo=timezone.utc)
+
+
+def play(track_play_id: int) -> dict:
+    return {
+        "track_play_id": track_play_id,
+        "played_at": START + timedelta(minutes=4 * track_play_id),
+        "track_id": track_play_id % 3,
+        "title": f"Track {track_play_id % 3}",
+        "artist": "Radiohead",
+        "username": "admin",
+        "device_name": "web",
+        "skipped": False,
+        "skip_score": 0.0,
+    }
+
+
+@pytest.fixture
+def plays(reader):
+    """Stored plays, served by the mocked reader like HISTORY_SQL does."""
+    plays = [play(track_play_id) for track_play_id in (1, 2, 3, 5, 8, 9, 12)]
+
+    def history(after_id, limit, offset, date_from, date_to):
+        newer = [dict(p) for p in plays if p["track_play_id"] > after_id]
+        return newer[offset:offset + limit]
+
+    reader.history.side_effect = history
+    return plays
+
+
+def test_cursor_walks_every_play_once(client, plays):
+    seen, cursor, pages = [], 0, 0
+    while cursor is not None:
+        body = client.get(f"/history?after_id={cursor}&limit=3").get_json()
+        seen += [p["track_play_id"] for p in body["plays"]]
+        cursor = body["next_cursor"]
+        pages += 1
+
+    assert seen == [1, 2, 3, 5, 8, 9, 12]
+    assert pages == 3
+
+
+def test_full_last_page_has_no_cursor(client, plays):
+    body = client.get("/history?after_id=5&limit=3").get_json()
+
+    assert [p["track_play_id"] for p in body["plays"]] == [8, 9, 12]
+    assert body["next_cursor"] is None
+
+
+def test_next_page_includes_plays_stored_since(client, plays, reader):
+    first = client.get("/history?limit=10").get_json()
+    assert first["next_cursor"] is None
+    last_id = first["plays"][-1]["track_play_id"]
+
+    plays.append(play(13))
+    repeated = client.get("/history?limit=10").get_json()
+    after = client.get(f"/history?after_id={last_id}&limit=10").get_json()
+
+    assert [p["track_play_id"] for p in repeated["plays"]][-1] == 13
+    assert [p["track_play_id"] for p in after["plays"]] == [13]
+    assert reader.history.call_count == 3
+
+
+def test_page_parameters_still_work(client, plays):
+    body = client.get("/history?page=2&page_size=3").get_json()
+
+    assert [p["track_play_id"] for p in body["plays"]] == [5, 8, 9]
+    assert (body["page"], body["page_size"], body["next_cursor"]) == (2, 3, 9)
+
+
+@pytest.mark.parametrize("query", ["after_id=3&page=2", "limit=501", "page_size=0"])
+def test_invalid_pagination(client, plays, query):
+    assert client.get(f"/history?{query}").status_code == 400
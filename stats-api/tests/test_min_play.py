@@ -0,0 +1,87 @@
+from datetime import datetime, timedelta, timezone
+
+import pytest
+
+import app as stats_api
+import sql_queries
+
+START = datetime(2024, 3, 10, 18, 0, tzinfo=timezone.utc)
+
+
+@pytest.fixture
+def plays(seed, monkeypatch):
+    monkeypatch.setattr(stats_api, "USER_TIMEZONE", "UTC")
+    monkeypatch.setattr(stats_api, "MIN_PLAY_MS", 0)
+    teardrop = seed.track("Teardrop", artists=("Massive Attack",), duration_ms=330000)
+    intro = seed.track("Intro", artists=("The xx",), duration_ms=12000)
+    for hour in range(2):
+        seed.play(teardrop, START + timedelta(hours=hour))
+    # 8 of 12 seconds: too short to count as skipped, but listened for less than 10 s.
+    for minute in range(3):
+        seed.play(intro, START + timedelta(days=1, minutes=minute), skip_score=0.333)
+
+
+@pytest.fixture
+def recent_plays(seed, monkeypatch):
+    monkeypatch.setattr(stats_api, "USER_TIMEZONE", "UTC")
+    monkeypatch.setattr(stats_api, "MIN_PLAY_MS", 0)
+    monkeypatch.setattr(stats_api.app, "db_reader", stats_api.DatabaseReader(seed.conn), raising=False)
+    teardrop = seed.track("Teardrop", artists=("Massive Attack",), duration_ms=330000)
+    intro = seed.track("Intro", artists=("The xx",), duration_ms=12000)
+    yesterday = datetime.now(timezone.utc) - timedelta(days=1)
+    for hour in range(2):
+        seed.play(teardrop, yesterday + timedelta(hours=hour), skip_score=0.0)
+    for minute in range(3):
+        seed.play(intro, yesterday + timedelta(hours=3, minutes=minute), skip_score=0.333)
+
+
+def top_tracks(reader) -> list[tuple]:
+    return [(row["title"], row["plays"]) for row in reader.top_tracks(None, None, limit=10)]
+
+
+def test_plays_below_min_play_ms_are_left_out(db, plays):
+    assert top_tracks(stats_api.DatabaseReader(db, min_play_ms=0)) == [("Intro", 3), ("Teardrop", 2)]
+    assert top_tracks(stats_api.DatabaseReader(db, min_play_ms=10000)) == [("Teardrop", 2)]
+
+
+def test_plays_without_skip_score_are_kept(db, plays):
+    assert top_tracks(stats_api.DatabaseReader(db, min_play_ms=400000)) == [("Teardrop", 2)]
+
+
+def test_query_parameter_overrides_the_setting(db, plays, client, monkeypatch):
+    monkeypatch.setattr(stats_api.app, "db_reader", stats_api.DatabaseReader(db), raising=False)
+
+    def compared_top_tracks(query: str) -> list[tuple]:
+        body = client.get(f"/compare?a=2024-03&b=2024-04{query}").get_json()
+        return [(row["title"], row["plays"]) for row in body["a"]["top_tracks"]]
+
+    assert compared_top_tracks("") == [("Intro", 3), ("Teardrop", 2)]
+    assert compared_top_tracks("&min_play_ms=10000") == [("Teardrop", 2)]
+
+
+
+@pytest.mark.parametrize("name", [
+    "SKIPS_BY_TRACK_SQL", "SKIPS_BY_ARTIST_SQL", "BINGE_DAYS_BY_TRACK_SQL", "BINGE_DAYS_BY_ARTIST_SQL",
+    "FAVORITE_TRACKS_SQL", "FAVORITE_ARTISTS_SQL", "FORGOTTEN_TRACKS_SQL", "FORGOTTEN_ARTISTS_SQL",
+    "HEATMAP_SQL", "DISCOVERIES_SQL", "ARTIST_LEADERBOARD_SQL", "REDISCOVERIES_SQL", "CROSS_PLATFORM_SQL",
+    "COMPLETION_SQL", "COMPLETION_HISTOGRAM_SQL", "FIRST_PLAY_DAY_SQL", "ON_THIS_DAY_SQL",
+    "ON_THIS_DAY_TRACKS_SQL",
+])
+def test_play_queries_apply_min_play_ms(name):
+    assert sql_queries.LONG_ENOUGH_PLAY in getattr(sql_queries, name)
+
+
+def test_skips_leave_out_short_plays(client, recent_plays):
+    def skipped_tracks(query: str) -> list[tuple]:
+        body = client.get(f"/skips?min_plays=1{query}").get_json()
+        return sorted((row["title"], row["plays"]) for row in body["items"])
+
+    assert skipped_tracks("") == [("Intro", 3), ("Teardrop", 2)]
+    assert skipped_tracks("&min_play_ms=10000") == [("Teardrop", 2)]
+
+
+def test_completion_leaves_out_short_plays(client, recent_plays):
+    assert client.get("/completion").get_json()["plays"] == 5
+    body = client.get("/completion?min_play_ms=10000").get_json()
+    assert body["plays"] == 2
+    assert body["percentiles"]["p50"] == 1.0
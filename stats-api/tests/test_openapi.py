@@ -0,0 +1,55 @@
+import json
+import re
+
+import app as stats_api
+from openapi import PUBLIC_PATHS, undocumented
+
+
+def registered_routes() -> dict:
+    """Registered routes as OpenAPI paths, with their lowercase methods."""
+    routes = {}
+    for rule in stats_api.app.url_map.iter_rules():
+        if rule.endpoint == "static":
+            continue
+        path = re.sub(r"<(?:\w+:)?(\w+)>", r"{\1}", rule.rule)
+        routes[path] = {method.lower() for method in rule.methods} - {"head", "options"}
+    return routes
+
+
+def served_document(client) -> dict:
+    response = client.get("/openapi.json")
+    assert response.status_code == 200
+    return json.loads(response.get_data(as_text=True))
+
+
+def test_document_lists_every_registered_route(client):
+    paths = served_document(client)["paths"]
+
+    for path, methods in registered_routes().items():
+        assert path in paths, f"{path} is not documented"
+        assert set(paths[path]) == methods, f"{path} documents {set(paths[path])}, serves {methods}"
+
+
+def test_document_lists_no_unknown_routes(client):
+    assert set(served_document(client)["paths"]) == set(registered_routes())
+
+
+def test_path_parameters_are_declared(client):
+    for path, operations in served_document(client)["paths"].items():
+        names = set(re.findall(r"{(\w+)}", path))
+        for operation in operations.values():
+            declared = {p["name"] for p in operation.get("parameters", []) if p["in"] == "path"}
+            assert declared == names, path
+
+
+def test_protected_routes_require_the_bearer_token(client):
+    document = served_document(client)
+
+    assert document["openapi"].startswith("3.")
+    for path, operations in document["paths"].items():
+        for operation in operations.values():
+            assert ("security" in operation) == (path not in PUBLIC_PATHS), path
+
+
+def test_undocumented_finds_missing_routes():
+    assert undocumented(["/history", "/artists/<int:artist_id>/tracks", "/not-there"]) == ["/not-there"]
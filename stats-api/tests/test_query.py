@@ -0,0 +1,78 @@
+from unittest import mock
+
+import pytest
+
+import app as stats_api
+
+AUTH = {"Authorization": "Bearer secret"}
+
+
+@pytest.fixture
+def query_reader(monkeypatch, reader):
+    """Enable POST /query with a mocked read-only reader and an API key."""
+    query_reader = mock.Mock(spec=stats_api.DatabaseReader)
+    query_reader.run_readonly_query.return_value = [{"plays": 3}]
+    monkeypatch.setattr(stats_api, "DATABASE_URL_READONLY", "postgresql://stats_query@db/music")
+    monkeypatch.setattr(stats_api, "STATS_API_KEY", "secret")
+    monkeypatch.setattr(stats_api.app, "query_reader", query_reader, raising=False)
+    return query_reader
+
+
+@pytest.mark.parametrize("dsn, key", [(None, None), (None, "secret"), ("postgresql://stats_query@db/music", None)])
+def test_query_is_disabled_without_readonly_dsn_and_key(client, reader, monkeypatch, dsn, key):
+    monkeypatch.setattr(stats_api, "DATABASE_URL_READONLY", dsn)
+    monkeypatch.setattr(stats_api, "STATS_API_KEY", key)
+
+    response = client.post("/query", json={"sql": "SELECT 1"}, headers=AUTH)
+
+    assert response.status_code == 404
+    reader.run_readonly_query.assert_not_called()
+
+
+def test_query_runs_on_readonly_connection(client, reader, query_reader):
+    response = client.post("/query", json={"sql": "SELECT count(*) AS plays FROM track_plays"}, headers=AUTH)
+
+    assert response.status_code == 200
+    assert response.get_json() == [{"plays": 3}]
+    query_reader.run_readonly_query.assert_called_once_with("SELECT count(*) AS plays FROM track_plays")
+    reader.run_readonly_query.assert_not_called()
+
+
+def test_query_requires_api_key(client, query_reader):
+    response = client.post("/query", json={"sql": "SELECT 1"})
+
+    assert response.status_code == 401
+    query_reader.run_readonly_query.assert_not_called()
+
+
+def test_query_rejects_statements_other_than_select(client, query_reader):
+    response = client.post("/query", json={"sql": "DELETE FROM track_plays"}, headers=AUTH)
+
+    assert response.status_code == 400
+    query_reader.run_readonly_query.assert_not_called()
+
+
+@pytest.mark.parametrize("sql, expected", [
+    ("SELECT 1", True),
+    ("  select * from track_plays;  ", True),
+    ("WITH t AS (SELECT 1) SELECT * FROM t", True),
+    ("SELECT 1; DROP TABLE track_plays", False),
+    ("UPDATE track_plays SET skipped = true", False),
+    ("", False),
+    (";", False),
+])
+def test_is_single_select(sql, expected):
+    assert stats_api.is_single_select(sql) is expected
+
+
+def test_rate_limiter_allows_limit_per_window(monkeypatch):
+    now = [100.0]
+    monkeypatch.setattr(stats_api.time, "monotonic", lambda: now[0])
+    limiter = stats_api.RateLimiter(2, window=60)
+
+    assert limiter.allow("a") and limiter.allow("a")
+    assert not limiter.allow("a")
+    assert limiter.allow("b")
+
+    now[0] += 60
+    assert limiter.allow("a")
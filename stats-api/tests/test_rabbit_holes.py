@@ -0,0 +1,146 @@
+from datetime import datetime, timedelta, timezone
+
+import app as stats_api
+from app import detect_rabbit_holes
+
+START = datetime(2024, 6, 1, 20, 0, tzinfo=timezone.utc)
+GAP = timedelta(minutes=30)
+ARTISTS = {1: "Radiohead", 2: "Portishead", 3: "Thom Yorke"}
+
+
+def plays(*entries) -> list[dict]:
+    """(track_id, minutes after START, artist ids) per play of user 1; see plays_of for others."""
+    return plays_of(*((track_id, minutes, artist_ids, 1) for track_id, minutes, artist_ids in entries))
+
+
+def plays_of(*entries) -> list[dict]:
+    return [{
+        "user_id": user_id,
+        "track_id": track_id,
+        "played_at": START + timedelta(minutes=minutes),
+        "artist_ids": list(artist_ids),
+        "artist_names": [ARTISTS[artist_id] for artist_id in artist_ids],
+    } for track_id, minutes, artist_ids, user_id in entries]
+
+
+def summary(holes: list[dict]) -> list[tuple]:
+    return [(hole["artist"], hole["plays"], hole["tracks"]) for hole in holes]
+
+
+def test_run_with_one_detour_is_a_rabbit_hole():
+    sequence = plays((1, 0, [1]), (2, 4, [1]), (10, 8, [2]), (3, 12, [1]), (1, 16, [1]), (2, 20, [1]))
+
+    holes = detect_rabbit_holes(sequence, min_plays=5, max_detour=1, gap=GAP, now=START + timedelta(days=1))
+
+    assert holes == [{
+        "artist_id": 1,
+        "artist": "Radiohead",
+        "plays": 5,
+        "tracks": 3,
+        "started_at": START.isoformat(),
+        "ended_at": (START + timedelta(minutes=20)).isoformat(),
+        "still_active": False,
+    }]
+
+
+def test_longer_detour_ends_the_run():
+    sequence = plays((1, 0, [1]), (2, 4, [1]), (10, 8, [2]), (11, 12, [2]), (3, 16, [1]), (1, 20, [1]), (2, 24, [1]))
+
+    assert detect_rabbit_holes(sequence, min_plays=5, max_detour=1, gap=GAP) == []
+    assert summary(detect_rabbit_holes(sequence, min_plays=5, max_detour=2, gap=GAP)) == [("Radiohead", 5, 3)]
+
+
+def test_one_track_on_repeat_is_a_binge_not_a_rabbit_hole():
+    sequence = plays(*((1, minutes, [1]) for minutes in range(0, 20, 4)))
+
+    assert detect_rabbit_holes(sequence, min_plays=5, max_detour=1, gap=GAP) == []
+
+
+def test_long_pause_ends_the_run():
+    sequence = plays((1, 0, [1]), (2, 4, [1]), (3, 8, [1]), (1, 60, [1]), (2, 64, [1]))
+
+    holes = detect_rabbit_holes(sequence, min_plays=3, max_detour=1, gap=GAP)
+
+    assert summary(holes) == [("Radiohead", 3, 3)]
+    assert holes[0]["ended_at"] == (START + timedelta(minutes=8)).isoformat()
+
+
+def test_collaboration_counts_for_both_artists():
+    sequence = plays((1, 0, [1]), (2, 4, [1]), (5, 8, [1, 3]), (6, 12, [3]), (7, 16, [3]))
+
+    holes = detect_rabbit_holes(sequence, min_plays=3, max_detour=1, gap=GAP, now=START + timedelta(minutes=20))
+
+    # Most recent first; only the run reaching the latest play is still going.
+    assert summary(holes) == [("Thom Yorke", 3, 3), ("Radiohead", 3, 3)]
+    assert [hole["still_active"] for hole in holes] == [True, False]
+
+
+def test_runs_of_different_users_are_kept_apart():
+    sequence = plays_of((1, 0, [1], 1), (2, 4, [1], 1), (3, 8, [1], 1),
+                        (1, 1, [1], 2), (2, 5, [1], 2), (3, 9, [1], 2))
+
+    holes = detect_rabbit_holes(sequence, min_plays=3, max_detour=1, gap=GAP, now=START + timedelta(minutes=12))
+
+    assert summary(holes) == [("Radiohead", 3, 3), ("Radiohead", 3, 3)]
+    assert all(hole["still_active"] for hole in holes)
+
+
+def test_endpoint_finds_rabbit_holes_in_stored_plays(db, seed, client, monkeypatch):
+    monkeypatch.setattr(stats_api, "USER_TIMEZONE", "UTC")
+    monkeypatch.setattr(stats_api.app, "db_reader", stats_api.DatabaseReader(db, min_play_ms=0), raising=False)
+    airbag, lucky, reckoner = (seed.track(title) for title in ("Airbag", "Lucky", "Reckoner"))
+    roads = seed.track("Roads", artists=("Portishead",))
+    for minutes, track_id, skipped in ((0, airbag, False), (4, lucky, False), (8, roads, False),
+                                       (12, reckoner, False), (16, airbag, True), (20, lucky, False),
+                                       (24, reckoner, False)):
+        seed.play(track_id, START + timedelta(minutes=minutes), skipped=skipped)
+
+    body = client.get("/rabbit-holes?from=2024-06-01&to=2024-06-01").get_json()
+
+    # The skipped play is left out, and is no detour either.
+    assert summary(body["rabbit_holes"]) == [("Radiohead", 5, 3)]
+    hole = body["rabbit_holes"][0]
+    assert hole["artist_id"] == seed.artist("Radiohead")
+    assert datetime.fromisoformat(hole["started_at"]) == START
+    assert datetime.fromisoformat(hole["ended_at"]) == START + timedelta(minutes=24)
+    assert hole["still_active"] is False
+
+
+def test_play_without_artists_has_empty_artist_lists(db_reader, seed):
+    untagged = seed.track("Untitled", artists=())
+    seed.play(untagged, START)
+
+    assert db_reader.ordered_artist_plays(None, None)[0]["artist_ids"] == []
+
+
+def test_recorded_endpoint_passes_the_filter(client, reader):
+    reader.recorded_rabbit_holes.return_value = [{
+        "rabbit_hole_id": "0b7e4c1e-8f0a-4d55-a2c3-6a1f2b3c4d5e", "username": "admin", "artist_id": 1,
+        "artist": "Radiohead", "plays": 6, "tracks": 3, "started_at": START,
+        "ended_at": START + timedelta(minutes=24), "still_active": True,
+    }]
+
+    body = client.get("/rabbit-holes/recorded?active=1&limit=5").get_json()
+
+    reader.recorded_rabbit_holes.assert_called_once_with(True, 5)
+    assert body["rabbit_holes"][0]["started_at"] == START.isoformat()
+
+
+def test_recorded_rabbit_holes_end_after_the_session_gap(db, db_reader, seed):
+    radiohead, portishead = seed.artist("Radiohead"), seed.artist("Portishead")
+    now = datetime.now(timezone.utc)
+    with db.cursor() as cur:
+        for artist_id, minutes_ago, active in ((radiohead, 600, False), (portishead, 120, True),
+                                               (radiohead, 5, True)):
+            cur.execute("""
+                INSERT INTO rabbit_holes (user_id, artist_id, started_at, ended_at, plays, tracks, still_active)
+                VALUES (%s, %s, %s, %s, 5, 3, %s);
+            """, (seed.user(), artist_id, now - timedelta(minutes=minutes_ago + 20),
+                  now - timedelta(minutes=minutes_ago), active))
+
+    holes = db_reader.recorded_rabbit_holes(active_only=False, limit=10)
+
+    # The tracker never saw a play after Portishead's, but that was two hours ago.
+    assert [(hole["artist"], hole["still_active"]) for hole in holes] == [
+        ("Radiohead", True), ("Portishead", False), ("Radiohead", False)]
+    assert [hole["artist"] for hole in db_reader.recorded_rabbit_holes(active_only=True, limit=10)] == ["Radiohead"]
@@ -0,0 +1,53 @@
+from unittest import mock
+
+import pytest
+
+import app as stats_api
+from sql_queries import BY_WEEKDAY_SQL
+
+REPLICA = "postgresql://stats@replica.internal:5432/music"
+
+
+@pytest.fixture
+def connections(monkeypatch):
+    """
+    Record the connections create_app opens, and put the app back as it was
+    afterwards.
+    """
+    for name in ("db_reader", "db_pool", "query_reader"):
+        monkeypatch.setattr(stats_api.app, name, getattr(stats_api.app, name, None), raising=False)
+    opened = {}
+
+    def wait_for_db(params, logger):
+        opened[params.get("dsn") or params["host"]] = mock.MagicMock()
+        return opened[params.get("dsn") or params["host"]]
+
+    monkeypatch.setattr(stats_api, "wait_for_db", wait_for_db)
+    monkeypatch.setattr(stats_api, "ThreadedConnectionPool", mock.Mock())
+    monkeypatch.setattr(stats_api, "DATABASE_URL_READONLY", None)
+    return opened
+
+
+def test_handlers_read_from_the_stats_dsn(connections, client, monkeypatch):
+    monkeypatch.setattr(stats_api, "STATS_DATABASE_URL", REPLICA)
+
+    stats_api.create_app()
+    replica = connections[REPLICA]
+    cur = replica.cursor.return_value.__enter__.return_value
+    cur.fetchall.return_value = [{"weekday": day, "plays": 0, "minutes": 0.0} for day in range(1, 8)]
+    response = client.get("/by-weekday")
+
+    assert response.status_code == 200
+    assert list(connections) == [REPLICA]
+    assert cur.execute.call_args.args[0] == BY_WEEKDAY_SQL
+    assert stats_api.ThreadedConnectionPool.call_args.kwargs["dsn"] == REPLICA
+
+
+def test_postgres_settings_without_a_stats_dsn(connections, monkeypatch):
+    monkeypatch.setattr(stats_api, "STATS_DATABASE_URL", None)
+
+    stats_api.create_app()
+
+    assert list(connections) == [stats_api.DB_CONFIG["host"]]
+    assert stats_api.app.db_reader.conn is connections[stats_api.DB_CONFIG["host"]]
+    assert "dsn" not in stats_api.ThreadedConnectionPool.call_args.kwargs
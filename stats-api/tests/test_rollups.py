@@ -0,0 +1,96 @@
+import importlib.util
+import os
+from datetime import date, datetime, timedelta, timezone
+
+import pytest
+
+import app as stats_api
+
+TZ = "America/New_York"
+DAYS = [date(2024, 3, 1) + timedelta(days=n) for n in range(4)]
+
+
+def tracker_sql():
+    """The tracker's queries, which maintain the rollups."""
+    path = os.path.join(os.path.dirname(__file__), "..", "..", "tracker", "sql_queries.py")
+    spec = importlib.util.spec_from_file_location("tracker_sql_queries", path)
+    module = importlib.util.module_from_spec(spec)
+    spec.loader.exec_module(module)
+    return module
+
+
+def refresh_rollups(db, through: date):
+    with db.cursor() as cur:
+        cur.execute(tracker_sql().REFRESH_ROLLUPS_SQL, {"since": None, "through": through, "tz": TZ})
+
+
+def rounded(value):
+    """
+    Round floats in nested results. Split plays add up in a different order
+    from the rollups than from raw plays, which may change the last bits.
+    """
+    if isinstance(value, float):
+        return round(value, 9)
+    if isinstance(value, dict):
+        return {key: rounded(item) for key, item in value.items()}
+    if isinstance(value, list):
+        return [rounded(item) for item in value]
+    return value
+
+
+def results(reader: stats_api.DatabaseReader) -> dict:
+    window = (DAYS[0], DAYS[-1])
+    return rounded({
+        "top_artists": reader.top_artists(*window, limit=10),
+        "top_genres": reader.top_genres(*window, limit=10),
+        "daily_summary": reader.daily_summary(*window),
+        # Genres and artists with equal minutes have no fixed order.
+        "genre_minutes": sorted(reader.genre_minutes("week", *window), key=repr),
+        "artist_minutes": sorted(reader.artist_minutes("day", *window), key=repr),
+        "unbounded_top_artists": reader.top_artists(None, None, limit=10),
+    })
+
+
+@pytest.fixture
+def plays(seed, monkeypatch):
+    monkeypatch.setattr(stats_api, "USER_TIMEZONE", TZ)
+    teardrop = seed.track("Teardrop", artists=("Massive Attack",), duration_ms=330000)
+    hyperballad = seed.track("Hyperballad", artists=("Björk",), duration_ms=321000)
+    collaboration = seed.track("Nude", artists=("Radiohead", "Björk"), duration_ms=255000)
+    seed.genres("Massive Attack", "trip hop")
+    seed.genres("Björk", "art pop", "electronic")
+    seed.genres("Radiohead", "art rock", "electronic")
+    for n, day in enumerate(DAYS):
+        # 03:30 UTC falls on the previous local day in New York.
+        late = datetime(day.year, day.month, day.day, 3, 30, tzinfo=timezone.utc)
+        seed.play(teardrop, late)
+        seed.play(hyperballad, late + timedelta(hours=14), skipped=n % 2 == 1)
+        for i in range(n):
+            # Half listened, which min_play_ms can leave out.
+            seed.play(collaboration, late + timedelta(hours=16, minutes=5 * i), skip_score=0.5)
+
+
+def test_rollups_give_the_same_results_as_raw_plays(db, db_reader, plays):
+    raw = results(db_reader)
+
+    refresh_rollups(db, through=DAYS[-1])
+
+    assert results(db_reader) == raw
+
+
+def test_partial_rollups_are_combined_with_raw_plays(db, db_reader, plays):
+    raw = results(db_reader)
+
+    refresh_rollups(db, through=DAYS[1])
+
+    assert results(db_reader) == raw
+
+
+def test_rollups_are_not_used_with_min_play_ms(db, plays):
+    reader = stats_api.DatabaseReader(db, min_play_ms=300000)
+    raw = results(reader)
+
+    refresh_rollups(db, through=DAYS[-1])
+
+    assert results(reader) == raw
+    assert "Radiohead" not in [row["artist"] for row in raw["top_artists"]]
@@ -0,0 +1,51 @@
+from datetime import datetime, timedelta, timezone
+
+import pytest
+
+import app as stats_api
+from app import shuffle_likelihood
+
+ALBUM = ("Airbag", "Paranoid Android", "Subterranean Homesick Alien", "Exit Music (For a Film)")
+
+
+def test_album_played_in_order_is_not_shuffled():
+    assert shuffle_likelihood(album_pairs=3, in_order_pairs=3) == 0.0
+
+
+def test_album_played_out_of_order_is_shuffled():
+    assert shuffle_likelihood(album_pairs=3, in_order_pairs=0) == 1.0
+    assert shuffle_likelihood(album_pairs=3, in_order_pairs=1) == 0.667
+
+
+def test_too_few_album_transitions_are_not_judged():
+    assert shuffle_likelihood(album_pairs=2, in_order_pairs=0, min_pairs=3) is None
+
+
+@pytest.fixture
+def album(seed, db):
+    """OK Computer's first four tracks, in album order."""
+    tracks = [seed.track(title, duration_ms=200000) for title in ALBUM]
+    with db.cursor() as cur:
+        cur.execute("INSERT INTO albums (title) VALUES ('OK Computer') RETURNING id;")
+        album_id = cur.fetchone()[0]
+        for number, track_id in enumerate(tracks, 1):
+            cur.execute("INSERT INTO album_tracks (album_id, track_id, track_number) VALUES (%s, %s, %s);",
+                        (album_id, track_id, number))
+    return tracks
+
+
+def play_session(seed, tracks: list[int], start: datetime):
+    for position, track_id in enumerate(tracks):
+        seed.play(track_id, start + timedelta(seconds=200 * position))
+
+
+def test_sessions_are_scored_by_album_order(db_reader, seed, album, client, monkeypatch):
+    monkeypatch.setattr(stats_api, "USER_TIMEZONE", "UTC")
+    monkeypatch.setattr(stats_api.app, "db_reader", db_reader, raising=False)
+    play_session(seed, album, datetime(2024, 3, 1, 20, 0, tzinfo=timezone.utc))
+    play_session(seed, [album[2], album[0], album[3], album[1]], datetime(2024, 3, 2, 20, 0, tzinfo=timezone.utc))
+
+    body = client.get("/shuffle?min_pairs=3").get_json()
+
+    assert [(s["album_pairs"], s["in_order_pairs"], s["shuffle_likelihood"], s["shuffled"])
+            for s in body["sessions"]] == [(3, 3, 0.0, False), (3, 0, 1.0, True)]
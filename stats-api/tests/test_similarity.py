@@ -0,0 +1,65 @@
+from datetime import date
+
+import pytest
+
+from app import jaccard_similarity
+
+TODAY = date(2024, 8, 15)
+
+
+@pytest.mark.parametrize("a, b, expected", [
+    ({1, 2, 3}, {1, 2, 3}, 1.0),
+    ({1, 2}, {3, 4}, 0.0),
+    ({1, 2, 3}, {2, 3, 4}, 0.5),
+    ({1}, set(), 0.0),
+])
+def test_jaccard_similarity(a, b, expected):
+    assert jaccard_similarity(a, b) == expected
+
+
+def test_jaccard_similarity_of_two_empty_sets_is_undefined():
+    assert jaccard_similarity(set(), set()) is None
+
+
+def track(track_id: int, title: str, plays: int) -> dict:
+    return {"track_id": track_id, "title": title, "artist": "Radiohead", "plays": plays}
+
+
+def test_endpoint_lists_common_and_unique_tracks(client, reader):
+    reader.local_today.return_value = TODAY
+    played = {
+        date(2024, 1, 1): [track(1, "Airbag", 5), track(2, "Lucky", 3), track(3, "No Surprises", 1)],
+        date(2024, 4, 1): [track(2, "Lucky", 4), track(3, "No Surprises", 2), track(4, "Reckoner", 1)],
+    }
+    reader.top_tracks.side_effect = lambda date_from, date_to, limit: played[date_from]
+
+    body = client.get("/similarity?a=2024-Q1&period_b=2024-Q2").get_json()
+
+    assert body["a"] == {"from": "2024-01-01", "to": "2024-03-31", "tracks": 3}
+    assert body["b"] == {"from": "2024-04-01", "to": "2024-06-30", "tracks": 3}
+    assert body["similarity"] == 0.5
+    # Most played in both periods together first.
+    assert [row["title"] for row in body["common"]["tracks"]] == ["Lucky", "No Surprises"]
+    assert body["common"]["tracks"][0]["plays_a"] == 3
+    assert body["common"]["tracks"][0]["plays_b"] == 4
+    assert [row["title"] for row in body["only_a"]["tracks"]] == ["Airbag"]
+    assert [row["title"] for row in body["only_b"]["tracks"]] == ["Reckoner"]
+
+
+def test_endpoint_without_plays_has_no_similarity(client, reader):
+    reader.local_today.return_value = TODAY
+    reader.top_tracks.return_value = []
+
+    body = client.get("/similarity?a=2024-Q1&b=2024-Q2").get_json()
+
+    assert body["similarity"] is None
+    assert body["common"] == {"count": 0, "tracks": []}
+
+
+def test_endpoint_requires_both_periods(client, reader):
+    reader.local_today.return_value = TODAY
+
+    response = client.get("/similarity?a=2024-Q1")
+
+    assert response.status_code == 400
+    reader.top_tracks.assert_not_called()
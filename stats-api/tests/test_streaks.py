@@ -0,0 +1,37 @@
+from datetime import date
+
+import app as stats_api
+
+TODAY = date(2024, 6, 15)
+
+
+def streak(start: date, end: date) -> dict:
+    return {"start_day": start, "end_day": end, "length": (end - start).days + 1}
+
+
+def test_streak_ending_yesterday_is_current():
+    summary = stats_api.summarize_streaks([
+        streak(date(2024, 5, 1), date(2024, 5, 10)),
+        streak(date(2024, 6, 12), date(2024, 6, 14)),
+    ], TODAY)
+
+    assert summary == {
+        "current_streak": 3,
+        "longest_streak": {"length": 10, "from": "2024-05-01", "to": "2024-05-10"},
+        "active_days": 13,
+    }
+
+
+def test_streak_ending_before_yesterday_is_over():
+    summary = stats_api.summarize_streaks([streak(date(2024, 6, 10), date(2024, 6, 13))], TODAY)
+
+    assert summary["current_streak"] == 0
+    assert summary["longest_streak"]["length"] == 4
+
+
+def test_streak_including_today():
+    assert stats_api.summarize_streaks([streak(TODAY, TODAY)], TODAY)["current_streak"] == 1
+
+
+def test_no_listening():
+    assert stats_api.summarize_streaks([], TODAY) == {"current_streak": 0, "longest_streak": None, "active_days": 0}
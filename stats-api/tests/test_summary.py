@@ -0,0 +1,61 @@
+from datetime import date, datetime, timedelta, timezone
+
+import app as stats_api
+from app import period_summary
+from reports import render_summary_text
+
+START = datetime(2024, 3, 10, 18, 0, tzinfo=timezone.utc)
+
+
+def test_summary_of_seeded_plays(db_reader, seed, monkeypatch):
+    monkeypatch.setattr(stats_api, "USER_TIMEZONE", "UTC")
+    teardrop = seed.track("Teardrop", artists=("Massive Attack",), duration_ms=330000)
+    reckoner = seed.track("Reckoner", duration_ms=290000)
+    seed.genres("Massive Attack", "trip hop")
+    seed.genres("Radiohead", "art rock")
+    for hour in range(3):
+        seed.play(teardrop, START + timedelta(hours=hour))
+    seed.play(reckoner, START + timedelta(days=1))
+    seed.play(reckoner, START + timedelta(days=1, hours=1), skipped=True)
+
+    text = render_summary_text(period_summary(db_reader, date(2024, 3, 1), date(2024, 3, 31), limit=5))
+    lines = text.splitlines()
+
+    assert lines[0] == "Listening from 2024-03-01 to 2024-03-31"
+    assert "Time listened:   0h 21m" in lines
+    assert "Plays:           5" in lines
+    assert "Unique artists:  2" in lines
+    assert "Skip rate:       20.0%" in lines
+    top_tracks = lines[lines.index("Top tracks") + 1:lines.index("Top artists") - 1]
+    assert top_tracks[0].startswith("  1.  Massive Attack - Teardrop  3 plays")
+    assert top_tracks[1].startswith("  2.  Radiohead - Reckoner       1 plays")
+    top_genres = lines[lines.index("Top genres") + 1:]
+    assert top_genres == ["  1.  trip hop  3 plays  75%", "  2.  art rock  1 plays  25%"]
+
+
+def test_empty_period():
+    summary = {"from": "2024-03-01", "to": "2024-03-31", "minutes": 0.0, "plays": 0, "unique_artists": 0,
+               "skip_rate": 0.0, "top_tracks": [], "top_artists": [], "top_genres": []}
+
+    lines = render_summary_text(summary).splitlines()
+
+    assert "Time listened:   0h 00m" in lines
+    assert lines[lines.index("Top artists") + 1] == "  -"
+    assert lines[-1] == "  -"
+
+
+def test_columns_are_aligned():
+    summary = {"from": "2024-03-01", "to": "2024-03-31", "minutes": 754.6, "plays": 1234, "unique_artists": 87,
+               "skip_rate": 0.125, "top_tracks": [], "top_genres": [],
+               "top_artists": [{"artist": "Massive Attack", "plays": 120, "minutes": 480.2},
+                               {"artist": "Björk", "plays": 9, "minutes": 40.0}]}
+
+    lines = render_summary_text(summary).splitlines()
+
+    assert "Time listened:   12h 35m" in lines
+    assert "Plays:           1,234" in lines
+    assert "Skip rate:       12.5%" in lines
+    assert lines[lines.index("Top artists") + 1:lines.index("Top artists") + 3] == [
+        "  1.  Massive Attack  120 plays  480 min",
+        "  2.  Björk             9 plays   40 min",
+    ]
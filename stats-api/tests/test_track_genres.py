@@ -0,0 +1,46 @@
+from datetime import datetime, timedelta, timezone
+
+START = datetime(2024, 3, 1, 18, 0, tzinfo=timezone.utc)
+
+
+def play_genres(db) -> list[tuple]:
+    with db.cursor() as cur:
+        cur.execute("""
+            SELECT tp.id, g.name
+            FROM track_plays tp
+            JOIN track_genres tg ON tg.track_id = tp.track_id
+            JOIN genres g ON g.id = tg.genre_id
+            ORDER BY tp.id, g.name;
+        """)
+        return cur.fetchall()
+
+
+def test_one_row_per_play_and_genre(db, seed):
+    # Both artists are "electronic"; it still counts once per play.
+    track = seed.track("Nude", artists=("Radiohead", "Björk"))
+    seed.genres("Radiohead", "art rock", "electronic")
+    seed.genres("Björk", "art pop", "electronic")
+    first = seed.play(track, START)
+    second = seed.play(track, START + timedelta(hours=1))
+
+    assert play_genres(db) == [
+        (first, "art pop"), (first, "art rock"), (first, "electronic"),
+        (second, "art pop"), (second, "art rock"), (second, "electronic"),
+    ]
+
+
+def test_tracks_without_genres_have_no_rows(db, seed):
+    seed.play(seed.track("Untitled"), START)
+
+    assert play_genres(db) == []
+
+
+def test_top_genres_count_each_play_once_per_genre(db_reader, seed):
+    track = seed.track("Nude", artists=("Radiohead", "Björk"))
+    seed.genres("Radiohead", "art rock", "electronic")
+    seed.genres("Björk", "art pop", "electronic")
+    seed.play(track, START)
+
+    rows = db_reader.top_genres(None, None, limit=10)
+
+    assert [(row["genre"], row["plays"]) for row in rows] == [("art pop", 1), ("art rock", 1), ("electronic", 1)]
@@ -0,0 +1,20 @@
+import pytest
+
+
+def normalized(db, title: str) -> str:
+    with db.cursor() as cur:
+        cur.execute("SELECT normalize_track_title(%s);", (title,))
+        return cur.fetchone()[0]
+
+
+@pytest.mark.parametrize("variants, key", [
+    (("Help!", "Help! (Remastered 2009)", "HELP!  - 2009 Remaster", "Help! [Live]", " help! "), "help!"),
+    (("Teardrop", "Teardrop (Mad Professor Mix) [2019 Remaster]", "Teardrop - Live at Glastonbury"), "teardrop"),
+])
+def test_title_variants_collapse_to_one_key(db, variants, key):
+    assert {normalized(db, title) for title in variants} == {key}
+
+
+def test_hyphens_inside_words_are_kept(db):
+    assert normalized(db, "Anti-Hero") == "anti-hero"
+    assert normalized(db, "Re-Offender") == "re-offender"
@@ -0,0 +1,56 @@
+from datetime import date, datetime, timezone
+
+import app as stats_api
+
+# Monday 2024-03-04 03:30 UTC is still Sunday evening in New York.
+LATE_SUNDAY = datetime(2024, 3, 4, 3, 30, tzinfo=timezone.utc)
+MONDAY_NOON = datetime(2024, 3, 4, 17, 0, tzinfo=timezone.utc)
+
+
+def plays_per_weekday(rows: list[dict]) -> dict:
+    return {stats_api.WEEKDAYS[row["weekday"] - 1]: row["plays"] for row in rows if row["plays"]}
+
+
+def test_plays_are_bucketed_by_local_weekday(db_reader, seed, monkeypatch):
+    monkeypatch.setattr(stats_api, "USER_TIMEZONE", "America/New_York")
+    track = seed.track("Reckoner", duration_ms=290000)
+    seed.play(track, LATE_SUNDAY)
+    seed.play(track, MONDAY_NOON)
+
+    rows = db_reader.plays_by_weekday(None, None)
+
+    assert [row["weekday"] for row in rows] == [1, 2, 3, 4, 5, 6, 7]
+    assert plays_per_weekday(rows) == {"Sun": 1, "Mon": 1}
+    assert rows[6]["minutes"] == 4.8
+
+
+def test_same_plays_in_utc_fall_on_one_day(db_reader, seed, monkeypatch):
+    monkeypatch.setattr(stats_api, "USER_TIMEZONE", "UTC")
+    track = seed.track("Reckoner")
+    seed.play(track, LATE_SUNDAY)
+    seed.play(track, MONDAY_NOON)
+
+    assert plays_per_weekday(db_reader.plays_by_weekday(None, None)) == {"Mon": 2}
+
+
+def test_window_uses_local_days(db_reader, seed, monkeypatch):
+    monkeypatch.setattr(stats_api, "USER_TIMEZONE", "America/New_York")
+    track = seed.track("Reckoner")
+    seed.play(track, LATE_SUNDAY)
+    seed.play(track, MONDAY_NOON)
+
+    rows = db_reader.plays_by_weekday(date(2024, 3, 4), date(2024, 3, 4))
+
+    assert plays_per_weekday(rows) == {"Mon": 1}
+
+
+def test_endpoint_names_weekdays(client, reader):
+    reader.plays_by_weekday.return_value = [
+        {"weekday": day, "plays": 2 if day == 7 else 0, "minutes": 8.0 if day == 7 else 0.0} for day in range(1, 8)
+    ]
+
+    body = client.get("/by-weekday?from=2024-03-01&to=2024-03-31").get_json()
+
+    assert [row["weekday"] for row in body["weekdays"]] == ["Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"]
+    assert body["weekdays"][6] == {"weekday": "Sun", "plays": 2, "minutes": 8.0}
+    reader.plays_by_weekday.assert_called_once_with(date(2024, 3, 1), date(2024, 3, 31))
@@ -1,18 +1,360 @@
-from dotenv import load_dotenv
+"""
+Settings of the tracker.
+
+Variables from a .env file in the working directory, or the file given with
+--env-file, are added to the environment first; variables that are already
+set keep their value. The file holds KEY=value lines; see parse_env_file.
+
+Every setting is read from the environment variable of the same name unless
+it is empty, then from the optional TOML file given with --config or TRACKER_CONFIG (keys in
+lowercase, e.g. `poll_interval = 5`), and otherwise takes its default. The
+flags of listener.py override all of them.
+
+Invalid values do not stop the import; they are collected in ERRORS so that
+every problem can be reported at once, and the setting keeps its default.
+
+Usage: python config.py [--env-file PATH] [--config PATH] print | check
+"""
+import argparse
 import os
+import re
+import tomllib
+from typing import Any, Callable, Optional
+from zoneinfo import ZoneInfo, ZoneInfoNotFoundError
+
+# Problems found while reading the settings, one message each.
+ERRORS: list[str] = []
+
+_ENV_LINE = re.compile(r"(?:export\s+)?([A-Za-z_][A-Za-z0-9_]*)\s*=\s*(.*)")
+_ENV_ESCAPES = {"n": "\n", "t": "\t"}
+
+
+def _env_value(raw: str) -> str:
+    """
+    :param raw: Everything after the = of a line, without leading whitespace
+    :return: The value without its quotes or trailing comment
+    :raises ValueError: If a quote is not closed or text follows it
+    """
+    if raw.startswith("'"):
+        end = raw.find("'", 1)
+        if end < 0:
+            raise ValueError("unterminated single quote")
+        value, rest = raw[1:end], raw[end + 1:]
+    elif raw.startswith('"'):
+        match = re.match(r'"((?:[^"\\]|\\.)*)"(.*)', raw)
+        if not match:
+            raise ValueError("unterminated double quote")
+        value = re.sub(r"\\(.)", lambda m: _ENV_ESCAPES.get(m.group(1), m.group(1)), match.group(1))
+        rest = match.group(2)
+    else:
+        # A # only starts a comment after whitespace, so URLs keep their fragments.
+        return re.split(r"\s+#", raw, maxsplit=1)[0].strip()
+    if rest.strip() and not rest.strip().startswith("#"):
+        raise ValueError("unexpected text after the closing quote")
+    return value
+
+
+def parse_env_file(path: str) -> tuple[dict[str, str], list[str]]:
+    """
+    Read the variables of a .env file.
+
+    Every line is empty, a # comment or KEY=value, optionally preceded by
+    `export`. Everything after the first = is the value, so values may
+    contain = themselves. Values are taken literally in single quotes, with
+    \\n, \\t, \\" and \\\\ escapes in double quotes, and otherwise up to a #
+    that follows whitespace, with surrounding whitespace removed.
+
+    :param path: The file
+    :return: The variables, and a "path:line: problem" message for each
+        malformed line, which is left out
+    :rtype: tuple[dict[str, str], list[str]]
+    :raises OSError: If the file cannot be read
+    """
+    variables, problems = {}, []
+    with open(path, encoding="utf-8") as f:
+        for number, line in enumerate(f, start=1):
+            line = line.strip()
+            if not line or line.startswith("#"):
+                continue
+            match = _ENV_LINE.fullmatch(line)
+            if not match:
+                problems.append(f"{path}:{number}: expected KEY=value, got {line!r}")
+                continue
+            try:
+                variables[match.group(1)] = _env_value(match.group(2))
+            except ValueError as e:
+                problems.append(f"{path}:{number}: {match.group(1)}: {e}")
+    return variables, problems
+
+
+# The settings below are resolved on import, so --env-file and --config are
+# picked out of the command line before the scripts parse their own flags.
+# Abbreviations are off, or --env would be taken for --env-file.
+_config_parser = argparse.ArgumentParser(add_help=False, allow_abbrev=False)
+_config_parser.add_argument("--env-file")
+_config_parser.add_argument("--config")
+_config_args = _config_parser.parse_known_args()[0]
+
+# The .env file that was loaded, if any. Malformed lines are reported in
+# ERRORS and skipped.
+ENV_FILE = _config_args.env_file or (".env" if os.path.isfile(".env") else None)
+if ENV_FILE:
+    try:
+        _env, _env_problems = parse_env_file(ENV_FILE)
+    except OSError as e:
+        ERRORS.append(f"env file {ENV_FILE}: {e.strerror or e}")
+        ENV_FILE = None
+    else:
+        ERRORS.extend(_env_problems)
+        for _name, _value in _env.items():
+            os.environ.setdefault(_name, _value)
+
+CONFIG_FILE = _config_args.config or os.getenv("TRACKER_CONFIG")
+
+# Effective value and source ("env", "file" or "default") of every setting.
+SETTINGS: dict[str, tuple[Any, str]] = {}
+
+# Settings whose values are never printed.
+SECRETS = {"POSTGRES_PASSWORD", "NAVIDROME_PASSWORD", "WEBHOOK_SECRET",
+           "DISCORD_WEBHOOK_URL", "SLACK_WEBHOOK_URL", "LASTFM_API_SECRET", "LASTFM_SESSION_KEY"}
+
+
+def _load_file(path: Optional[str]) -> dict:
+    if not path:
+        return {}
+    try:
+        with open(path, "rb") as f:
+            return tomllib.load(f)
+    except (OSError, tomllib.TOMLDecodeError) as e:
+        ERRORS.append(f"config file {path}: {e}")
+        return {}
+
+
+_file = _load_file(CONFIG_FILE)
+
+
+def _setting(name: str, default: Any, parse: Callable[[Any], Any] = str,
+             check: Optional[Callable[[Any], bool]] = None, requirement: str = "") -> Any:
+    """
+    Resolve one setting from the environment, the config file or its default.
+
+    :param name: Environment variable; the file key is its lowercase form
+    :param default: Value when neither is set; it is parsed like the others
+    :param parse: Converts the raw value, raising ValueError or TypeError
+    :param check: Further validation of the parsed value
+    :param requirement: What check expects, for the error message
+    :return: The parsed value, or the parsed default if it was invalid
+    """
+    # An empty variable, like the blank entries of the .env template, counts as unset.
+    source, raw = "env", os.getenv(name)
+    if raw == "":
+        raw = None
+    if raw is None and name.lower() in _file:
+        source, raw = "file", _file[name.lower()]
+    if raw is None:
+        source, raw = "default", default
+
+    value, problem = None, None
+    if raw is not None:
+        try:
+            value = parse(raw)
+        except (TypeError, ValueError):
+            problem = f"{name}={raw!r} cannot be parsed"
+        else:
+            if check is not None and not check(value):
+                problem = f"{name}={raw!r} {requirement}"
+    if problem:
+        ERRORS.append(problem)
+        source, value = "default", None if default is None else parse(default)
+
+    SETTINGS[name] = (value, source)
+    return value
+
+
+def _bool(raw: Any) -> bool:
+    if isinstance(raw, bool):
+        return raw
+    value = str(raw).strip().lower()
+    if value in ("1", "true", "yes"):
+        return True
+    if value in ("", "0", "false", "no"):
+        return False
+    raise ValueError(raw)
+
+
+def _int_list(raw: Any) -> list[int]:
+    if isinstance(raw, list):
+        return [int(n) for n in raw]
+    return [int(n) for n in str(raw).split(",") if n.strip()]
+
+
+def _str_list(raw: Any) -> list[str]:
+    if isinstance(raw, list):
+        return [str(item).strip() for item in raw if str(item).strip()]
+    return [item.strip() for item in str(raw).split(",") if item.strip()]
+
+
+def _is_timezone(name: str) -> bool:
+    try:
+        ZoneInfo(name)
+    except (ZoneInfoNotFoundError, ValueError):
+        return False
+    return True
+
+
+def _at_least(minimum: float) -> Callable[[float], bool]:
+    return lambda value: value >= minimum
+
+
+def _is_port(value: int) -> bool:
+    return 0 <= value <= 65535
 
-load_dotenv()
 
 DB_CONFIG = {
-    "host": os.getenv("POSTGRES_HOST", "localhost"),
-    "port": int(os.getenv("POSTGRES_PORT", 5432)),
-    "dbname": os.getenv("POSTGRES_DB"),
-    "user": os.getenv("POSTGRES_USER"),
-    "password": os.getenv("POSTGRES_PASSWORD"),
+    "host": _setting("POSTGRES_HOST", "localhost"),
+    "port": _setting("POSTGRES_PORT", 5432, int, _is_port, "must be a port number"),
+    "dbname": _setting("POSTGRES_DB", None),
+    "user": _setting("POSTGRES_USER", None),
+    "password": _setting("POSTGRES_PASSWORD", None),
 }
 
-LOCAL_MUSICSTREAM_URL = os.getenv("LOCAL_MUSICSTREAM_URL", "http://localhost:5217")
-NAVIDROME_USER = os.getenv("NAVIDROME_USER", "admin")
-NAVIDROME_PASSWORD = os.getenv("NAVIDROME_PASSWORD", "admin")
+LOCAL_MUSICSTREAM_URL = _setting("LOCAL_MUSICSTREAM_URL", "http://localhost:5217")
+NAVIDROME_USER = _setting("NAVIDROME_USER", "admin")
+NAVIDROME_PASSWORD = _setting("NAVIDROME_PASSWORD", "admin")
+# Read on every poll and preferred over NAVIDROME_PASSWORD when set.
+NAVIDROME_PASSWORD_FILE = _setting("NAVIDROME_PASSWORD_FILE", None)
+# JSON file of recorded getNowPlaying responses to replay instead of asking
+# Navidrome, one per poll (empty = poll Navidrome).
+FETCH_FROM_FILE = _setting("FETCH_FROM_FILE", None)
+
+USER_TIMEZONE = _setting("USER_TIMEZONE", "UTC", check=_is_timezone, requirement="must be an IANA time zone")
+
+# Log statements instead of executing them.
+DRY_RUN = _setting("DRY_RUN", False, _bool)
+
+# Seconds between polls while Navidrome is reachable.
+POLL_INTERVAL = _setting("POLL_INTERVAL", 2, float, _at_least(0.1), "must be at least 0.1")
+
+# A play is skipped when less than SKIP_THRESHOLD of the track was played;
+# tracks so short that the rest is under MIN_SKIP_MS count as skipped only
+# when more than MIN_SKIP_MS were left.
+SKIP_THRESHOLD = _setting("SKIP_THRESHOLD", 0.9, float, lambda value: 0 < value <= 1, "must be in (0, 1]")
+MIN_SKIP_MS = _setting("MIN_SKIP_MS", 5000, int, _at_least(0), "must not be negative")
+
+# A play is a rediscovery when its track was last played this many days ago.
+REDISCOVERY_DAYS = _setting("REDISCOVERY_DAYS", 90, int, _at_least(1), "must be at least 1")
+
+# A binge session is at least BINGE_MIN_TRACKS unskipped plays of one user
+# within BINGE_WINDOW_MINUTES.
+BINGE_MIN_TRACKS = _setting("BINGE_MIN_TRACKS", 10, int, _at_least(2), "must be at least 2")
+BINGE_WINDOW_MINUTES = _setting("BINGE_WINDOW_MINUTES", 30, int, _at_least(1), "must be at least 1")
+
+# A rabbit hole is at least RABBIT_HOLE_MIN_PLAYS unskipped plays of one
+# artist covering two or more tracks, with at most RABBIT_HOLE_MAX_DETOUR
+# plays of other artists between two of them and no more than
+# SESSION_GAP_MINUTES between plays (the same setting as the stats-api's).
+RABBIT_HOLE_MIN_PLAYS = _setting("RABBIT_HOLE_MIN_PLAYS", 5, int, _at_least(2), "must be at least 2")
+RABBIT_HOLE_MAX_DETOUR = _setting("RABBIT_HOLE_MAX_DETOUR", 1, int, _at_least(0), "must not be negative")
+SESSION_GAP_MINUTES = _setting("SESSION_GAP_MINUTES", 30, int, _at_least(1), "must be at least 1")
+
+# Play counts that are recorded as milestones overall, per artist and per track.
+MILESTONE_COUNTS = _setting("MILESTONE_COUNTS", "100,500,1000", _int_list,
+                            lambda counts: all(n > 0 for n in counts), "counts must be positive")
+
+# Longest interval between polls while nothing is playing; the interval
+# grows towards it after a run of empty polls (empty = fixed interval).
+POLL_MAX_INTERVAL = _setting("POLL_MAX_INTERVAL", None, float, _at_least(0.1), "must be at least 0.1")
+
+# Seconds by which each poll is randomly moved earlier or later.
+POLL_JITTER = _setting("POLL_JITTER", 0, float, _at_least(0), "must not be negative")
+
+# Seconds a SIGTERM/SIGINT waits for the current poll to finish before exiting anyway.
+SHUTDOWN_GRACE_SECONDS = _setting("SHUTDOWN_GRACE_SECONDS", 15, float, _at_least(0), "must not be negative")
+
+# Port for GET /healthz, /readyz and /metrics (0 disables them), and how
+# many seconds the main loop may be late for its next poll or retry before
+# /healthz starts failing.
+HEALTH_PORT = _setting("HEALTH_PORT", 8080, int, _is_port, "must be a port number")
+HEALTH_STALE_SECONDS = _setting("HEALTH_STALE_SECONDS", 120, int, _at_least(1), "must be at least 1")
+
+# OTLP/HTTP endpoint to export traces to, e.g. http://otel-collector:4318
+# (empty = no tracing).
+OTEL_EXPORTER_OTLP_ENDPOINT = _setting("OTEL_EXPORTER_OTLP_ENDPOINT", None)
+
+# host:port of a StatsD/DogStatsD agent to also send metrics to over UDP
+# (empty = Prometheus /metrics only).
+STATSD_ADDR = _setting("STATSD_ADDR", None, check=lambda addr: bool(re.fullmatch(r"[^:\s]+:\d+", addr)),
+                       requirement="must be host:port")
+
+# URLs that receive a signed JSON POST with the plays and milestones of
+# each poll that stored something (empty = no webhooks). With
+# WEBHOOK_SECRET set, requests carry an HMAC-SHA256 of the body.
+WEBHOOK_URLS = _setting("WEBHOOK_URLS", "", _str_list,
+                        lambda urls: all(re.match(r"https?://", url) for url in urls),
+                        "must be http(s) URLs")
+WEBHOOK_SECRET = _setting("WEBHOOK_SECRET", None)
+
+# Discord and Slack incoming webhooks that get chat messages (empty = off).
+# Each message type can be switched off; with NOTIFY_PLAYS_EVERY_MINUTES
+# above 0, plays are batched into at most one message per that many minutes.
+DISCORD_WEBHOOK_URL = _setting("DISCORD_WEBHOOK_URL", None, check=lambda url: url.startswith("https://"),
+                               requirement="must be an https URL")
+SLACK_WEBHOOK_URL = _setting("SLACK_WEBHOOK_URL", None, check=lambda url: url.startswith("https://"),
+                             requirement="must be an https URL")
+NOTIFY_PLAYS = _setting("NOTIFY_PLAYS", True, _bool)
+NOTIFY_PLAYS_EVERY_MINUTES = _setting("NOTIFY_PLAYS_EVERY_MINUTES", 0, float, _at_least(0), "must not be negative")
+NOTIFY_MILESTONES = _setting("NOTIFY_MILESTONES", True, _bool)
+NOTIFY_DAILY_SUMMARY = _setting("NOTIFY_DAILY_SUMMARY", True, _bool)
+
+# Last.fm scrobbling of LASTFM_SCROBBLE_USER's plays; on when the API key,
+# secret and a session key (python scrobbler.py session) are all set.
+LASTFM_BASE = _setting("LASTFM_BASE", "http://ws.audioscrobbler.com/2.0")
+LASTFM_API_KEY = _setting("LASTFM_API_KEY", None)
+LASTFM_API_SECRET = _setting("LASTFM_API_SECRET", None)
+LASTFM_SESSION_KEY = _setting("LASTFM_SESSION_KEY", None)
+LASTFM_SCROBBLE_USER = _setting("LASTFM_SCROBBLE_USER", NAVIDROME_USER)
+
+DB_RETRY_ATTEMPTS = _setting("DB_RETRY_ATTEMPTS", 3, int, _at_least(1), "must be at least 1")
+DB_RETRY_DELAY = _setting("DB_RETRY_DELAY", 0.5, float, _at_least(0), "must not be negative")
+
+# Lowest level that is logged (debug, info, warning, error) and the output
+# format: one JSON object per line, or "text" for plain key=value lines.
+LOG_LEVEL = _setting("LOG_LEVEL", "info", lambda raw: str(raw).upper(),
+                     lambda level: level in ("DEBUG", "INFO", "WARNING", "ERROR"),
+                     "must be debug, info, warning or error")
+LOG_FORMAT = _setting("LOG_FORMAT", "json", lambda raw: str(raw).lower(),
+                      lambda fmt: fmt in ("json", "text"), "must be json or text")
+
+ENVIRONMENT = _setting("ENVIRONMENT", "dev")
+
+
+def describe() -> list[str]:
+    """
+    :return: One "NAME=value  # source" line per setting, secrets redacted
+    :rtype: list[str]
+    """
+    lines = []
+    for name, (value, source) in SETTINGS.items():
+        if name in SECRETS and value:
+            value = "<redacted>"
+        elif isinstance(value, list):
+            value = ",".join(str(item) for item in value)
+        lines.append(f"{name}={'' if value is None else value}  # {source}")
+    return lines
+
+
+if __name__ == "__main__":
+    parser = argparse.ArgumentParser(description="Show or check the tracker's configuration", allow_abbrev=False)
+    parser.add_argument("--env-file", help="file with environment variables (default: .env if present)")
+    parser.add_argument("--config", help="TOML file with settings (default: $TRACKER_CONFIG)")
+    subparsers = parser.add_subparsers(dest="command", required=True)
+    subparsers.add_parser("print", help="print the effective settings with their source, secrets redacted")
+    subparsers.add_parser("check", help="report every invalid setting and exit with status 2 if there are any")
+    args = parser.parse_args()
 
-ENVIRONMENT = os.getenv("ENVIRONMENT", "dev")
\ No newline at end of file
+    if args.command == "print":
+        print(f"# env file: {ENV_FILE or 'none'}")
+        print(f"# config file: {CONFIG_FILE or 'none'}")
+        print("\n".join(describe()))
+    if ERRORS:
+        parser.exit(2, "Invalid configuration:\n" + "".join(f"  {error}\n" for error in ERRORS))
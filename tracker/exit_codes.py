@@ -0,0 +1,81 @@
+"""
+Exit codes of the tracker's commands, for cron jobs and service managers
+that can only react to the status:
+
+    0  success, also when there was nothing new
+    1  anything else
+    2  invalid configuration
+    3  Navidrome rejected the credentials
+    4  database unavailable
+    5  Navidrome request failed
+    6  Last.fm request failed or was rejected
+
+Errors are raised where they occur and mapped to a code by `exit_code`;
+invalid configuration is reported before a command starts and exits with
+CONFIG directly.
+"""
+from typing import Callable, Optional
+
+import psycopg2
+
+from logger import log
+
+OK = 0
+FAILURE = 1
+CONFIG = 2
+AUTH = 3
+DATABASE = 4
+NAVIDROME = 5
+LASTFM = 6
+
+
+class NavidromeError(Exception):
+    """A Navidrome request that failed or got an unusable response."""
+
+
+class NavidromeAuthError(NavidromeError):
+    """Navidrome rejected the username or password."""
+
+
+class LastfmError(Exception):
+    """An error response from the Last.fm API, or a request that failed."""
+
+    def __init__(self, code: Optional[int], message: str):
+        super().__init__(f"Last.fm error {code}: {message}")
+        self.code = code
+
+
+def exit_code(error: BaseException) -> int:
+    """
+    :param error: Exception that ended a command
+    :return: The exit code for its class
+    :rtype: int
+    """
+    if isinstance(error, NavidromeAuthError):
+        return AUTH
+    if isinstance(error, psycopg2.OperationalError):
+        return DATABASE
+    if isinstance(error, NavidromeError):
+        return NAVIDROME
+    if isinstance(error, LastfmError):
+        return LASTFM
+    return FAILURE
+
+
+def run(main: Callable[[], object]) -> int:
+    """
+    Run a command's main function and turn the exception that ended it, if
+    any, into an exit code.
+
+    :param main: The command, without arguments
+    :return: The exit code, for sys.exit
+    :rtype: int
+    """
+    try:
+        main()
+    except Exception as e:
+        code = exit_code(e)
+        log.error("Exiting after error", error=str(e), error_type=type(e).__name__, exit_code=code,
+                  exc_info=code == FAILURE)
+        return code
+    return OK
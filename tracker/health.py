@@ -0,0 +1,189 @@
+"""
+Liveness, readiness and metrics endpoints for the tracker.
+
+The main loop reports its progress, polls, inserts and errors to `health`;
+a small HTTP server in a background thread answers GET /healthz and
+GET /readyz from it and serves the Prometheus metrics on GET /metrics.
+"""
+import json
+import threading
+from contextlib import closing
+from datetime import datetime, timedelta, timezone
+from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
+from typing import Callable, Optional
+
+import psycopg2
+
+from config import DB_CONFIG, HEALTH_STALE_SECONDS
+from logger import log
+from metrics import LAST_SUCCESSFUL_POLL, POLL_ERRORS, render
+from version import build_info
+
+
+# Seconds a readiness check waits for the database.
+DB_PING_TIMEOUT = 2
+
+
+def ping_database() -> Optional[str]:
+    """
+    Run SELECT 1 on a fresh connection, so the check does not interfere
+    with the main loop's transaction.
+
+    :return: None if the database answered, otherwise the error
+    :rtype: Optional[str]
+    """
+    try:
+        with closing(psycopg2.connect(**DB_CONFIG, connect_timeout=DB_PING_TIMEOUT)) as conn:
+            with conn.cursor() as cur:
+                cur.execute("SELECT 1;")
+    except psycopg2.Error as e:
+        return str(e).strip()
+    return None
+
+
+class TrackerHealth:
+    """
+    Thread-safe record of the main loop's progress, the last successful poll
+    and insert, and the current error.
+    """
+
+    def __init__(self, ping: Callable[[], Optional[str]] = ping_database):
+        self._lock = threading.Lock()
+        self.ping = ping
+        self.started_at = datetime.now(timezone.utc)
+        # The main loop promised to come round again by this time.
+        self.next_progress_by = self.started_at
+        self.last_poll_at: Optional[datetime] = None
+        self.last_insert_at: Optional[datetime] = None
+        self.last_error: Optional[str] = None
+        self.navidrome_ok = False
+
+    def loop_progressed(self, next_in: float):
+        """
+        Called by the main loop after every poll or retry.
+
+        :param next_in: Seconds it is going to wait before the next one
+        """
+        with self._lock:
+            self.next_progress_by = datetime.now(timezone.utc) + timedelta(seconds=next_in)
+
+    def poll_succeeded(self):
+        with self._lock:
+            self.last_poll_at = datetime.now(timezone.utc)
+            self.navidrome_ok = True
+            self.last_error = None
+        LAST_SUCCESSFUL_POLL.set_to_current_time()
+
+    def poll_failed(self, error: str):
+        with self._lock:
+            self.navidrome_ok = False
+            self.last_error = error
+        POLL_ERRORS.inc()
+
+    def insert_succeeded(self):
+        with self._lock:
+            self.last_insert_at = datetime.now(timezone.utc)
+
+    def error(self, error: str):
+        with self._lock:
+            self.last_error = error
+
+    def liveness(self) -> tuple[bool, dict]:
+        """
+        Alive while the main loop keeps going round: it is at most
+        HEALTH_STALE_SECONDS late for the poll or retry it announced last.
+        Navidrome or the database being down does not make it stale, as the
+        loop keeps retrying them.
+
+        :return: Whether the tracker is alive, and the report body
+        :rtype: tuple[bool, dict]
+        """
+        with self._lock:
+            now = datetime.now(timezone.utc)
+            overdue = max((now - self.next_progress_by).total_seconds(), 0)
+            alive = overdue <= HEALTH_STALE_SECONDS
+            return alive, {
+                "status": "ok" if alive else "stale",
+                "last_poll_at": _isoformat(self.last_poll_at),
+                "last_insert_at": _isoformat(self.last_insert_at),
+                "seconds_overdue": round(overdue, 1),
+                "stale_after_seconds": HEALTH_STALE_SECONDS,
+                "last_error": self.last_error,
+                "build": build_info(),
+            }
+
+    def readiness(self) -> tuple[bool, dict]:
+        """
+        Ready when the database answers a ping and the last Navidrome poll,
+        which also checks the credentials, succeeded.
+
+        :return: Whether the tracker is ready, and the report body
+        :rtype: tuple[bool, dict]
+        """
+        db_error = self.ping()
+        with self._lock:
+            ready = db_error is None and self.navidrome_ok
+            return ready, {
+                "status": "ok" if ready else "not ready",
+                "database": {"ok": db_error is None, "error": db_error},
+                "navidrome": {"ok": self.navidrome_ok, "last_poll_at": _isoformat(self.last_poll_at)},
+                "last_error": self.last_error,
+            }
+
+
+def _isoformat(value: Optional[datetime]) -> Optional[str]:
+    return value.isoformat() if value else None
+
+
+health = TrackerHealth()
+
+
+class HealthHandler(BaseHTTPRequestHandler):
+    CHECKS = {
+        "/healthz": TrackerHealth.liveness,
+        "/readyz": TrackerHealth.readiness,
+    }
+
+    def do_GET(self):
+        path = self.path.split("?", 1)[0]
+        if path == "/metrics":
+            self._send(200, *render())
+            return
+
+        check = self.CHECKS.get(path)
+        if check is None:
+            self._respond(404, {"error": "not found"})
+            return
+        ok, body = check(health)
+        self._respond(200 if ok else 503, body)
+
+    def _respond(self, status: int, body: dict):
+        self._send(status, json.dumps(body).encode(), "application/json")
+
+    def _send(self, status: int, payload: bytes, content_type: str):
+        self.send_response(status)
+        self.send_header("Content-Type", content_type)
+        self.send_header("Content-Length", str(len(payload)))
+        self.end_headers()
+        self.wfile.write(payload)
+
+    def log_message(self, format, *args):
+        # Probes hit these endpoints every few seconds; keep them out of the log.
+        pass
+
+
+def serve_health(port: int) -> Optional[ThreadingHTTPServer]:
+    """
+    Serve /healthz, /readyz and /metrics on `port` from a daemon thread.
+
+    :param port: TCP port; 0 disables the server
+    :return: The running server, or None when disabled
+    :rtype: Optional[ThreadingHTTPServer]
+    """
+    if not port:
+        return None
+
+    server = ThreadingHTTPServer(("0.0.0.0", port), HealthHandler)
+    threading.Thread(target=server.serve_forever, name="health", daemon=True).start()
+    log.info("Serving health checks", port=port)
+    return server
@@ -0,0 +1,132 @@
+"""
+Import play counts from an Apple Music library export.
+
+Apple Music (File > Library > Export Library...) writes Library.xml, a
+property list with every track's metadata, play count and last play date.
+Each played song is stored in external_tracks and matched to a local track
+by ISRC or by normalized title and artist where possible. The export only
+has counts, not individual plays, so nothing is added to track_plays.
+
+The import commits every batch and replaces the counts of entries it has
+seen before, so an interrupted run can simply be repeated, and importing a
+newer export updates the counts.
+
+Usage: python import_apple_music.py Library.xml [--dry-run]
+"""
+import argparse
+import plistlib
+import re
+import sys
+from contextlib import closing
+from datetime import timezone
+from typing import Iterator
+from xml.parsers.expat import ExpatError
+
+import psycopg2
+from psycopg2.extras import RealDictCursor
+
+from config import DB_CONFIG
+from exit_codes import FAILURE, run
+from logger import log
+from sql_queries import UPSERT_EXTERNAL_TRACK_SQL
+
+SOURCE = "apple_music"
+BATCH_SIZE = 500
+
+# Separators of several artists in one Apple Music artist field.
+ARTIST_SEPARATORS = re.compile(r"\s*(?:,|&|\bfeat\.?|\bft\.?|\bfeaturing\b)\s*", re.IGNORECASE)
+
+
+def artist_candidates(artist: str) -> list[str]:
+    """
+    The full artist field and each artist it lists, to match any of a local
+    track's artists.
+
+    :param artist: Artist field, e.g. "Artist A & Artist B feat. C"
+    :return: Distinct non-empty names, the full field first
+    :rtype: list[str]
+    """
+    names = [artist] + ARTIST_SEPARATORS.split(artist)
+    return list(dict.fromkeys(name.strip() for name in names if name.strip()))
+
+
+def read_library(path: str) -> Iterator[dict]:
+    """
+    Yield the played songs of a Library.xml export as external_tracks rows.
+
+    Podcasts, videos and tracks that were never played are left out.
+
+    :param path: Path of the exported Library.xml
+    :raises OSError: If the file cannot be read
+    :raises plistlib.InvalidFileException: If it is not a property list
+    :raises ExpatError: If it is not well-formed XML
+    """
+    with open(path, "rb") as f:
+        library = plistlib.load(f)
+
+    for track in library.get("Tracks", {}).values():
+        if not track.get("Play Count") or not track.get("Name"):
+            continue
+        if track.get("Podcast") or track.get("Has Video"):
+            continue
+
+        last_played = track.get("Play Date UTC")
+        yield {
+            "source": SOURCE,
+            "external_id": track.get("Persistent ID") or str(track["Track ID"]),
+            "title": track["Name"],
+            "artist": track.get("Artist"),
+            "artists": artist_candidates(track.get("Artist") or ""),
+            "album": track.get("Album"),
+            "duration_ms": track.get("Total Time"),
+            "isrc": track.get("ISRC"),
+            "play_count": track["Play Count"],
+            # plistlib returns naive datetimes in UTC.
+            "last_played_at": last_played.replace(tzinfo=timezone.utc) if last_played else None,
+        }
+
+
+def import_library(path: str, dry_run: bool = False) -> tuple[int, int]:
+    """
+    Store the played songs of a Library.xml export in external_tracks.
+
+    :param path: Path of the exported Library.xml
+    :param dry_run: Only read the file and report what would be imported
+    :return: Number of entries imported and how many matched a local track
+    :rtype: tuple[int, int]
+    """
+    entries = list(read_library(path))
+    if dry_run:
+        log.info("(DRY RUN) Would import Apple Music tracks",
+                 tracks=len(entries),
+                 plays=sum(entry["play_count"] for entry in entries))
+        return len(entries), 0
+
+    imported = matched = 0
+    with closing(psycopg2.connect(**DB_CONFIG)) as conn:
+        for start in range(0, len(entries), BATCH_SIZE):
+            with conn, conn.cursor(cursor_factory=RealDictCursor) as cur:
+                for entry in entries[start:start + BATCH_SIZE]:
+                    cur.execute(UPSERT_EXTERNAL_TRACK_SQL, entry)
+                    if cur.fetchone()["track_id"] is not None:
+                        matched += 1
+                    imported += 1
+            log.info("Imported Apple Music tracks", done=imported, total=len(entries), matched=matched)
+
+    return imported, matched
+
+
+if __name__ == "__main__":
+    parser = argparse.ArgumentParser(description="Import play counts from an Apple Music Library.xml export")
+    parser.add_argument("path", help="exported Library.xml")
+    parser.add_argument("--dry-run", action="store_true",
+                        help="only read the file and report how many tracks and plays it has")
+    args = parser.parse_args()
+
+    def main():
+        try:
+            import_library(args.path, dry_run=args.dry_run)
+        except (OSError, plistlib.InvalidFileException, ExpatError) as e:
+            parser.exit(FAILURE, f"Cannot read {args.path}: {e}\n")
+
+    sys.exit(run(main))
@@ -3,20 +3,95 @@ Listener for MusicStream local API
 to track currently playing songs 
 and log play events to the database.
 """
+import argparse
+import json
+import os
+import signal
+import sys
+import threading
 import time
-from dataclasses import dataclass
+import uuid
+from contextlib import closing
+from dataclasses import dataclass, field
+from typing import Callable, Optional
 from json import JSONDecodeError
 from enum import Enum
-from datetime import datetime
+from datetime import date, datetime, timedelta
+from zoneinfo import ZoneInfo
 import requests
 import psycopg2
+import psycopg2.errors
 from psycopg2.extras import RealDictCursor
-from config import DB_CONFIG, LOCAL_MUSICSTREAM_URL, NAVIDROME_USER, NAVIDROME_PASSWORD
+from config import (
+    CONFIG_FILE,
+    ENV_FILE,
+    ERRORS as CONFIG_ERRORS,
+    DB_CONFIG,
+    LOCAL_MUSICSTREAM_URL,
+    NAVIDROME_USER,
+    NAVIDROME_PASSWORD,
+    NAVIDROME_PASSWORD_FILE,
+    FETCH_FROM_FILE,
+    POLL_INTERVAL,
+    POLL_MAX_INTERVAL,
+    POLL_JITTER,
+    SKIP_THRESHOLD,
+    MIN_SKIP_MS,
+    SHUTDOWN_GRACE_SECONDS,
+    HEALTH_PORT,
+    DB_RETRY_ATTEMPTS,
+    DB_RETRY_DELAY,
+    DRY_RUN,
+    MILESTONE_COUNTS,
+    REDISCOVERY_DAYS,
+    BINGE_MIN_TRACKS,
+    BINGE_WINDOW_MINUTES,
+    RABBIT_HOLE_MIN_PLAYS,
+    RABBIT_HOLE_MAX_DETOUR,
+    SESSION_GAP_MINUTES,
+    USER_TIMEZONE,
+)
+from exit_codes import CONFIG, NavidromeAuthError, NavidromeError, run
+from health import health, serve_health
 from logger import log
-from sql_queries import INSERT_SQL
+from metrics import DB_WRITE_DURATION, PLAYS_INSERTED, PLAYS_SKIPPED, POLL_INTERVAL_SECONDS, statsd, timed_get
+from notifiers import Notifications
+from scheduler import PollScheduler
+from scrobbler import Scrobbler
+from sd_notify import ServiceNotifier
+from sql_queries import (
+    INSERT_SQL,
+    UPSERT_LOCAL_TRACK_SQL,
+    TRACK_GENRES_SQL,
+    COMPUTE_MONTHLY_DISCOVERIES_SQL,
+    RECORD_MILESTONES_SQL,
+    DAILY_SUMMARY_SQL,
+    SCROBBLE_CANDIDATES_SQL,
+    MARK_SCROBBLES_PENDING_SQL,
+    UPDATE_SCROBBLE_SQL,
+    NOTIFY_REDISCOVERY_SQL,
+    STORE_RAW_JSON_SQL,
+    INSERT_SKIP_EVENT_SQL,
+    ROLLUP_STATE_SQL,
+    REFRESH_ROLLUPS_SQL,
+    RECENT_USER_PLAYS_SQL,
+    CREATE_BINGE_SESSION_SQL,
+    EXTEND_BINGE_SESSION_SQL,
+    NOTIFY_BINGE_SQL,
+    RECENT_USER_ARTIST_PLAYS_SQL,
+    ACTIVE_RABBIT_HOLES_SQL,
+    CREATE_RABBIT_HOLE_SQL,
+    EXTEND_RABBIT_HOLE_SQL,
+    END_RABBIT_HOLES_SQL,
+)
+from tracing import setup_tracing, tracer
+from version import version_string
 
 # Models and State
 
+# Namespace for the synthetic ids of local files without a MusicBrainz id.
+LOCAL_TRACK_NAMESPACE = uuid.UUID("6f1c5e2a-4b7d-4c1e-9a53-0d8b7f2e6c41")
+
 @dataclass
 class Song:
     title: str
@@ -24,11 +99,31 @@ class Song:
     album: str
     duration: int
     mbid: str
+    is_local: bool = False
+    # The now-playing entry as Navidrome returned it.
+    raw: Optional[dict] = field(default=None, repr=False)
 
     @property
     def track_key(self) -> str:
         return f"{self.artist} - {self.title}"
 
+@dataclass
+class SkipDecision:
+    """
+    Inputs and outcome of the skip check for one play, kept for tuning.
+
+    rule is "ratio" when the share played was compared to the threshold,
+    "remaining_ms" when short tracks were judged by the time left unplayed,
+    and "unknown_duration" when there was nothing to compare against.
+    """
+    skipped: bool
+    rule: str
+    played_ms: int
+    threshold: float
+    min_skip_ms: int
+    expected_ms: Optional[int] = None
+    ratio: Optional[float] = None
+
 @dataclass
 class PlaybackState:
     user_id: str = "local_user"
@@ -43,9 +138,10 @@ class ApiState(Enum):
 
 @dataclass
 class HealthStatus:
-    poll_interval: int
+    poll_interval: float
     last_health_log: int
-    DEFAULT_POLL_INTERVAL = 2
+    # Interval to return to once Navidrome is reachable again.
+    base_poll_interval: float = POLL_INTERVAL
     HEALTH_LOG_INTERVAL = 60
 
 # Key: (user_id, client_id)
@@ -66,48 +162,98 @@ def now_ms() -> int:
 def playback_key(user_id, client_id):
     return (user_id, client_id)
 
+def rabbit_hole_run(plays: list[dict], artist_id: int, max_detour: int, gap: timedelta) -> list[dict]:
+    """
+    Collect the plays of an artist that form one run with the newest play:
+    at most max_detour plays of other artists between two of them and no
+    more than gap between their starts.
+
+    :param plays: Unskipped plays of one user with artist_ids, newest first,
+        the first one of the artist
+    :return: The artist's plays in the run, newest first
+    :rtype: list[dict]
+    """
+    run = [plays[0]]
+    detour = 0
+    for play in plays[1:]:
+        if artist_id not in play["artist_ids"]:
+            detour += 1
+            if detour > max_detour:
+                break
+            continue
+        if run[-1]["played_at"] - play["played_at"] > gap:
+            break
+        run.append(play)
+        detour = 0
+    return run
+
 # Classes
 
 class MusicStreamClient:
     from config import LOCAL_MUSICSTREAM_URL
 
-    def __init__(self, health_status: HealthStatus):
+    # Subsonic error codes for wrong credentials and unsupported token auth.
+    AUTH_ERROR_CODES = (40, 41)
+
+    def __init__(self, health_status: HealthStatus, password_file: Optional[str] = None):
         self.health_status = health_status
+        self.password_file = password_file
         self.state = ApiState.UP
+        # Whether Navidrome has accepted the credentials at least once.
+        self.authenticated = False
+
+    def _password(self) -> str:
+        """
+        Return the Navidrome password, re-reading the password file if one is
+        configured so an external process can rotate it without a restart.
+
+        :return: Password with surrounding whitespace removed
+        :rtype: str
+        :raises OSError: If the password file cannot be read
+        """
+        if not self.password_file:
+            return NAVIDROME_PASSWORD
+        with open(self.password_file, "r", encoding="utf-8") as f:
+            return f.read().strip()
 
     def fetch_songs(self) -> None:
         currentPlaybacks.clear()
         try:
-            url = f"{LOCAL_MUSICSTREAM_URL}/rest/getNowPlaying"
-            params = {'u': NAVIDROME_USER, 'p': NAVIDROME_PASSWORD, 'f': 'json', 'v': '1.8.0', 'c': 'music-analytics'}
-            resp = requests.get(url, params=params, timeout=5)
-            resp.raise_for_status()
-            if self.state == ApiState.DOWN:
-                log.info("Navidrome is back online")
-                self.health_status.poll_interval = HealthStatus.DEFAULT_POLL_INTERVAL
-            self.state = ApiState.UP
-            self.health_status.last_health_log = now_ms()
-        except requests.RequestException as e:
-            self._handle_down(e)
+            data = self._fetch()
+        except NavidromeError as e:
+            # Logged where it happened; the next poll tries again.
+            health.poll_failed(str(e))
             return None
-
-        try:
-            data = resp.json()
-        except JSONDecodeError as e:
-            log.error("Invalid JSON from Navidrome", error=str(e), data=resp.text)
+        if data is None:
             return None
 
         if not isinstance(data, dict):
             log.error("Unexpected JSON structure from Navidrome", data=data)
+            health.poll_failed("unexpected JSON structure from Navidrome")
+            return None
+
+        response = data.get("subsonic-response")
+        if isinstance(response, dict) and response.get("status") == "failed":
+            error = response.get("error") or {}
+            log.error("Navidrome rejected the request", code=error.get("code"), message=error.get("message"))
+            health.poll_failed(f"Navidrome error {error.get('code')}: {error.get('message')}")
+            # Wrong credentials from the start are a misconfiguration; later
+            # they may be a password rotation in progress, so keep polling.
+            if error.get("code") in self.AUTH_ERROR_CODES and not self.authenticated:
+                raise NavidromeAuthError(error.get("message") or "wrong username or password")
             return None
-        
+
         try:
             entries = data["subsonic-response"]["nowPlaying"].get("entry", [])
-            if not entries:
-                log.info("No song currently playing (empty entries)")
-                return None
         except (KeyError, TypeError) as e:
             log.error("Missing expected fields in Navidrome response", error=str(e), data=data)
+            health.poll_failed(f"missing fields in Navidrome response: {e}")
+            return None
+
+        health.poll_succeeded()
+        self.authenticated = True
+        if not entries:
+            log.debug("No song currently playing (empty entries)")
             return None
 
         log.debug("Fetched data from Navidrome", entries=entries)
@@ -115,16 +261,63 @@ class MusicStreamClient:
         for entry in entries:
             self._handle_entry(entry)
 
-    
+    def _fetch(self) -> Optional[dict]:
+        """
+        Request getNowPlaying from Navidrome.
+
+        :return: The decoded response, None if the password file could not be read
+        :rtype: Optional[dict]
+        :raises NavidromeError: If the request fails or the response is not JSON
+        """
+        try:
+            password = self._password()
+        except OSError as e:
+            log.error("Could not read Navidrome password file", path=self.password_file, error=str(e))
+            health.poll_failed(f"could not read password file: {e}")
+            return None
+
+        try:
+            url = f"{LOCAL_MUSICSTREAM_URL}/rest/getNowPlaying"
+            params = {'u': NAVIDROME_USER, 'p': password, 'f': 'json', 'v': '1.8.0', 'c': 'music-analytics'}
+            resp = timed_get("getNowPlaying", url, params=params, timeout=5)
+            resp.raise_for_status()
+            if self.state == ApiState.DOWN:
+                log.info("Navidrome is back online")
+                self.health_status.poll_interval = self.health_status.base_poll_interval
+            self.state = ApiState.UP
+            self.health_status.last_health_log = now_ms()
+        except requests.RequestException as e:
+            self._handle_down(e)
+            raise NavidromeError(f"Navidrome request failed: {e}") from e
+
+        try:
+            data = resp.json()
+        except JSONDecodeError as e:
+            log.error("Invalid JSON from Navidrome", error=str(e), data=resp.text)
+            raise NavidromeError(f"invalid JSON from Navidrome: {e}") from e
+        return data
+
     def _handle_entry(self, entry):
         navidrome_user_id = entry["username"]
         client_id = entry["playerName"]
+        title = entry.get("title") or entry.get("path") or "Unknown title"
+        artist = entry.get("artist") or ""
+        album = entry.get("album") or ""
+        mbid = entry.get("musicBrainzId")
+        is_local = not mbid
+        if is_local:
+            # Untagged files have no MusicBrainz id. Derive a stable one so
+            # repeated plays of the same file end up on the same track.
+            mbid = str(uuid.uuid5(LOCAL_TRACK_NAMESPACE, f"{artist}\x1f{album}\x1f{title}"))
+
         song = Song(
-            title=entry["title"],
-            artist=entry["artist"],
-            album=entry["album"],
-            duration=entry["duration"]*1000,
-            mbid=entry["musicBrainzId"]
+            title=title,
+            artist=artist,
+            album=album,
+            duration=(entry.get("duration") or 0) * 1000,
+            mbid=mbid,
+            is_local=is_local,
+            raw=entry,
         )
 
         key = playback_key(navidrome_user_id, client_id)
@@ -146,34 +339,522 @@ class MusicStreamClient:
             log.debug("MusicStream API still offline")
 
 
+def load_fixture(path: str) -> list[dict]:
+    """
+    Read recorded getNowPlaying responses for FixtureClient.
+
+    The file holds a JSON list with one step per poll. A step is either a
+    Subsonic response ({"subsonic-response": {...}}) or
+    {"polls": n, "response": {...}} to serve the same response n times.
+    A single response instead of a list is one step.
+
+    :param path: JSON file
+    :return: One response per poll
+    :rtype: list[dict]
+    :raises OSError: If the file cannot be read
+    :raises ValueError: If it is not JSON of that shape
+    """
+    with open(path, "r", encoding="utf-8") as f:
+        steps = json.load(f)
+    if isinstance(steps, dict):
+        steps = [steps]
+    if not isinstance(steps, list):
+        raise ValueError(f"{path}: expected a list of responses")
+
+    responses = []
+    for number, step in enumerate(steps, 1):
+        if isinstance(step, dict) and "response" in step:
+            polls, response = step.get("polls", 1), step["response"]
+        else:
+            polls, response = 1, step
+        if not isinstance(polls, int) or polls < 1 or not isinstance(response, dict):
+            raise ValueError(f"{path}: step {number} needs a response object and polls of at least 1")
+        responses.extend([response] * polls)
+    return responses
+
+
+class FixtureClient(MusicStreamClient):
+    """
+    Replays recorded getNowPlaying responses instead of asking Navidrome,
+    for development and demos. Each poll takes the next response; after the
+    last one nothing is playing any more, so the songs still playing are
+    finalized. Everything after the request, from parsing to skip detection
+    and storage, is the same as with Navidrome.
+
+    Play lengths still come from the clock: a song in n consecutive
+    responses has played for about n - 1 poll intervals.
+    """
+
+    EMPTY_RESPONSE = {"subsonic-response": {"status": "ok", "nowPlaying": {}}}
+
+    def __init__(self, health_status: HealthStatus, path: str):
+        super().__init__(health_status=health_status)
+        self.path = path
+        self.responses = load_fixture(path)
+        self.position = 0
+        log.info("Replaying Navidrome responses from file", path=path, polls=len(self.responses))
+
+    def _fetch(self) -> Optional[dict]:
+        if self.position >= len(self.responses):
+            return self.EMPTY_RESPONSE
+        data = self.responses[self.position]
+        self.position += 1
+        if self.position == len(self.responses):
+            log.info("Fixture replayed, nothing is playing from the next poll on", path=self.path)
+        return data
+
+
 class DatabaseWriter:
-    def __init__(self, conn):
+    # Errors after which the same statement may succeed on the same connection.
+    TRANSIENT_ERRORS = (
+        psycopg2.errors.SerializationFailure,
+        psycopg2.errors.DeadlockDetected,
+    )
+
+    def __init__(self, conn, dry_run: bool = False, notifications: Optional[Notifications] = None):
         self.conn = conn
-        
-    def insert_track_play(self, song: Song, played_at: datetime, user_id: str, skipped: bool):
+        self.dry_run = dry_run
+        self.notifications = notifications or Notifications([])
+        # Plays stored over the lifetime of this writer.
+        self.plays_inserted = 0
+
+    def _execute(self, sql: str, params: dict) -> list[dict]:
+        """
+        Execute and commit a statement, retrying transient failures with backoff.
+
+        Connection failures are not retried here; they propagate so the main
+        loop can reconnect. In dry-run mode the statement is only logged.
+
+        :param sql: SQL statement
+        :param params: Statement parameters
+        :return: Rows returned by the statement, if any
+        :rtype: list[dict]
+        """
+        if self.dry_run:
+            log.info("(DRY RUN) Would execute statement",
+                     sql=" ".join(sql.split()),
+                     params={k: str(v) for k, v in params.items()})
+            return []
+
+        delay = DB_RETRY_DELAY
+        for attempt in range(1, DB_RETRY_ATTEMPTS + 1):
+            try:
+                with DB_WRITE_DURATION.time(), tracer.start_as_current_span("db.execute") as span:
+                    # Skip building attributes for the no-op tracer.
+                    if span.is_recording():
+                        span.set_attributes({"db.system": "postgresql", "db.statement": " ".join(sql.split())})
+                    with self.conn.cursor(cursor_factory=RealDictCursor) as cur:
+                        cur.execute(sql, params)
+                        rows = cur.fetchall() if cur.description else []
+                        span.set_attribute("db.rows_affected", cur.rowcount)
+                    self.conn.commit()
+                return rows
+            except self.TRANSIENT_ERRORS as e:
+                self.conn.rollback()
+                if attempt == DB_RETRY_ATTEMPTS:
+                    raise
+                log.warning("Transient database error, retrying",
+                            attempt=attempt,
+                            retry_in=delay,
+                            error=str(e))
+                time.sleep(delay)
+                delay *= 2
+
+    def known_genres(self, mbid: str) -> list[str]:
+        """
+        Look up the genres already stored for a track's artists.
+
+        :param mbid: MusicBrainz id of the track
+        :type mbid: str
+        :return: Genre names, empty if the track or its genres are not known yet
+        :rtype: list[str]
+        """
+        with self.conn.cursor() as cur:
+            cur.execute(TRACK_GENRES_SQL, {"mbid": mbid})
+            genres = [row[0] for row in cur.fetchall()]
+        self.conn.rollback()
+        return genres
+
+    @tracer.start_as_current_span("insert_track_play")
+    def insert_track_play(self, song: Song, played_at: datetime, user_id: str, skipped: bool,
+                          skip_score: Optional[float] = None, device_name: Optional[str] = None,
+                          skip_decision: Optional[SkipDecision] = None):
         try:
-            with self.conn.cursor(cursor_factory=RealDictCursor) as cur:
-                cur.execute(INSERT_SQL, {
+            if self.dry_run:
+                log.info("(DRY RUN) Would record track play",
+                         title=song.title,
+                         artist=song.artist,
+                         album=song.album,
+                         mbid=song.mbid,
+                         is_local=song.is_local,
+                         genres=self.known_genres(song.mbid),
+                         user_id=user_id,
+                         device_name=device_name,
+                         played_at=played_at.isoformat(),
+                         skipped=skipped,
+                         skip_score=skip_score)
+
+            if song.is_local:
+                self._execute(UPSERT_LOCAL_TRACK_SQL, {
+                    "title": song.title,
+                    "duration_ms": song.duration or None,
                     "mbid": song.mbid,
-                    "username": user_id,
-                    "played_at": played_at,
-                    "skipped": skipped
+                    "artist_names": [song.artist] if song.artist else [],
                 })
-            self.conn.commit()
-            log.debug("Inserted track play", track_title=song.title, played_at=played_at.isoformat())
+
+            rows = self._execute(INSERT_SQL, {
+                "mbid": song.mbid,
+                "username": user_id,
+                "played_at": played_at,
+                "skipped": skipped,
+                "skip_score": skip_score,
+                "device_name": device_name,
+                "rediscovery_days": REDISCOVERY_DAYS
+            })
+            if not self.dry_run:
+                log.debug("Inserted track play", track_title=song.title, played_at=played_at.isoformat())
+                health.insert_succeeded()
+                if rows:
+                    self.plays_inserted += 1
+                    PLAYS_INSERTED.inc()
+                    statsd.incr("track.inserted")
+                    if skipped:
+                        PLAYS_SKIPPED.inc()
+                        statsd.incr("track.skipped")
+                    self.notifications.add_play(song.title, song.artist, song.album, played_at,
+                                                user_id, device_name, skipped)
+        except (psycopg2.OperationalError, psycopg2.InterfaceError):
+            # Lost connection: keep the playback state so the play is
+            # finalized again once the main loop has reconnected.
+            raise
+        except psycopg2.Error as e:
+            log.error("Dropping track play after database error",
+                      track_key=song.track_key,
+                      played_at=played_at.isoformat(),
+                      error=str(e),
+                      exc_info=True)
+            self.conn.rollback()
+            return
+
+        try:
+            if rows and song.raw:
+                self._execute(STORE_RAW_JSON_SQL, {"mbid": song.mbid, "raw_json": json.dumps(song.raw)})
+            if rows and skip_decision:
+                self.record_skip_event(rows[0]["id"], skip_decision)
+            if rows and rows[0]["rediscovery"]:
+                self._announce_rediscovery(song, rows[0], played_at)
+            if rows and not skipped:
+                self.detect_binge_session(rows[0]["id"])
+                self.detect_rabbit_holes(rows[0]["id"])
+            self.notifications.add_milestones(self.record_milestones(song.mbid))
+        except (psycopg2.OperationalError, psycopg2.InterfaceError):
+            # Finalizing the play again after reconnecting is harmless, the
+            # insert ignores plays that are already stored.
+            raise
         except psycopg2.Error as e:
-            log.error("Error inserting track play", error=str(e), exc_info=True)
+            # The play itself is stored; missed milestones are picked up by
+            # the next check for the same track or by a recompute.
+            log.error("Post-insert checks failed", track_key=song.track_key, error=str(e))
             self.conn.rollback()
 
+    @tracer.start_as_current_span("record_skip_event")
+    def record_skip_event(self, track_play_id: int, decision: SkipDecision):
+        """
+        Store the inputs of a play's skip decision in skip_events.
+
+        :param track_play_id: The play the decision was made for
+        :param decision: Inputs and outcome of the skip check
+        """
+        self._execute(INSERT_SKIP_EVENT_SQL, {
+            "track_play_id": track_play_id,
+            "rule": decision.rule,
+            "expected_ms": decision.expected_ms,
+            "played_ms": decision.played_ms,
+            "ratio": decision.ratio,
+            "threshold": decision.threshold,
+            "min_skip_ms": decision.min_skip_ms,
+            "skipped": decision.skipped,
+        })
+
+    def _announce_rediscovery(self, song: Song, play: dict, played_at: datetime):
+        """
+        Log a rediscovered track and publish it on the track_rediscovered channel.
+        """
+        log.info("Track rediscovered",
+                 track_key=song.track_key,
+                 days_since_last_play=play["days_since_last_play"])
+        self._execute(NOTIFY_REDISCOVERY_SQL, {"payload": json.dumps({
+            "track_play_id": play["id"],
+            "mbid": song.mbid,
+            "title": song.title,
+            "artist": song.artist,
+            "played_at": played_at.isoformat(),
+            "days_since_last_play": play["days_since_last_play"],
+        })})
+
+    def detect_binge_session(self, track_play_id: int) -> Optional[str]:
+        """
+        Check whether a new play completes a binge session: BINGE_MIN_TRACKS
+        unskipped plays of the same user, ending with this one, within
+        BINGE_WINDOW_MINUTES.
+
+        Such a window that overlaps an existing session extends it, so a
+        long binge stays one session. A new session is published on the
+        binge_detected channel.
+
+        :param track_play_id: The play that was just stored
+        :return: Id of the new or extended session, None if the play does
+            not complete a binge
+        :rtype: Optional[str]
+        """
+        plays = self._execute(RECENT_USER_PLAYS_SQL, {"track_play_id": track_play_id, "count": BINGE_MIN_TRACKS})
+        if len(plays) < BINGE_MIN_TRACKS:
+            return None
+        if plays[0]["played_at"] - plays[-1]["played_at"] >= timedelta(minutes=BINGE_WINDOW_MINUTES):
+            return None
+
+        play_ids = [play["id"] for play in plays]
+        session_id = next((play["binge_session_id"] for play in plays if play["binge_session_id"]), None)
+        if session_id:
+            self._execute(EXTEND_BINGE_SESSION_SQL, {"session_id": session_id, "play_ids": play_ids})
+            return session_id
+
+        session_id = self._execute(CREATE_BINGE_SESSION_SQL, {"play_ids": play_ids})[0]["id"]
+        log.info("Binge session detected",
+                 binge_session_id=session_id,
+                 started_at=plays[-1]["played_at"].isoformat(),
+                 plays=len(plays))
+        self._execute(NOTIFY_BINGE_SQL, {"payload": json.dumps({
+            "binge_session_id": session_id,
+            "started_at": plays[-1]["played_at"].isoformat(),
+            "plays": len(plays),
+            "window_minutes": BINGE_WINDOW_MINUTES,
+        })})
+        return session_id
+
+    def detect_rabbit_holes(self, track_play_id: int) -> list[str]:
+        """
+        Record the rabbit holes a new unskipped play starts or continues, and
+        end the ones it breaks off.
+
+        A rabbit hole is a run of RABBIT_HOLE_MIN_PLAYS plays of one artist
+        (see rabbit_hole_run) covering two or more tracks. It stays
+        still_active while the run can go on, so the next play of the artist
+        extends it instead of starting another one.
+
+        :param track_play_id: The play that was just stored
+        :return: Ids of the rabbit holes the play started or extended
+        :rtype: list[str]
+        """
+        # Enough plays to find a new run, and to tell that an older one broke off.
+        count = RABBIT_HOLE_MIN_PLAYS * (RABBIT_HOLE_MAX_DETOUR + 1)
+        plays = self._execute(RECENT_USER_ARTIST_PLAYS_SQL, {"track_play_id": track_play_id, "count": count})
+        if not plays:
+            return []
+        latest = plays[0]
+        gap = timedelta(minutes=SESSION_GAP_MINUTES)
+        active = {hole["artist_id"]: hole
+                  for hole in self._execute(ACTIVE_RABBIT_HOLES_SQL, {"user_id": latest["user_id"]})}
+        found, ended = [], []
+
+        for artist_id in latest["artist_ids"]:
+            run = rabbit_hole_run(plays, artist_id, RABBIT_HOLE_MAX_DETOUR, gap)
+            hole = active.pop(artist_id, None)
+            if hole and any(play["played_at"] == hole["ended_at"] for play in run[1:]):
+                self._execute(EXTEND_RABBIT_HOLE_SQL, {"rabbit_hole_id": hole["id"], "ended_at": latest["played_at"]})
+                found.append(hole["id"])
+                continue
+            if hole:
+                ended.append(hole["id"])
+            if len(run) < RABBIT_HOLE_MIN_PLAYS or len({play["track_id"] for play in run}) < 2:
+                continue
+            created = self._execute(CREATE_RABBIT_HOLE_SQL, {
+                "artist_id": artist_id, "play_ids": [play["id"] for play in run],
+            })[0]
+            log.info("Rabbit hole detected",
+                     rabbit_hole_id=created["id"],
+                     artist_id=artist_id,
+                     started_at=created["started_at"].isoformat(),
+                     plays=created["plays"],
+                     tracks=created["tracks"])
+            found.append(created["id"])
+
+        # The artists of the other rabbit holes were not played this time.
+        for artist_id, hole in active.items():
+            detour = next((index for index, play in enumerate(plays) if play["played_at"] == hole["ended_at"]), None)
+            if detour is None or detour > RABBIT_HOLE_MAX_DETOUR or latest["played_at"] - hole["ended_at"] > gap:
+                ended.append(hole["id"])
+
+        if ended:
+            self._execute(END_RABBIT_HOLES_SQL, {"rabbit_hole_ids": ended})
+        return found
+
+    def record_milestones(self, mbid: Optional[str] = None) -> list[dict]:
+        """
+        Record play count milestones reached so far and log new ones.
+
+        :param mbid: Only check this track and its artists; None checks the
+            whole history
+        :return: Milestones that were added
+        :rtype: list[dict]
+        """
+        rows = self._execute(RECORD_MILESTONES_SQL, {"mbid": mbid, "counts": MILESTONE_COUNTS})
+        for row in rows:
+            log.info("Milestone reached",
+                     kind=row["kind"],
+                     subject=row["subject"],
+                     count=row["count"],
+                     played_at=row["played_at"].isoformat(),
+                     track_play_id=row["track_play_id"])
+        return rows
+
+    def compute_monthly_discoveries(self, month: date):
+        """
+        Store how many artists, tracks and genres were first played in a month.
+
+        :param month: First day of the local calendar month
+        :type month: date
+        """
+        self._execute(COMPUTE_MONTHLY_DISCOVERIES_SQL, {"month": month, "tz": USER_TIMEZONE})
+        log.info("Computed monthly discoveries", month=month.isoformat())
+
+    def daily_summary(self, day: date) -> Optional[dict]:
+        """
+        :param day: Local day that is already rolled up
+        :return: Plays, skips, duration_ms and top_artist of the day, None
+            if nothing was played
+        :rtype: Optional[dict]
+        """
+        rows = self._execute(DAILY_SUMMARY_SQL, {"day": day})
+        return rows[0] if rows else None
+
+    def scrobble_candidates(self, username: str, since: datetime, limit: int, max_attempts: int) -> list[dict]:
+        """
+        Plays of a user that Last.fm counts as scrobbles and that still need
+        to be submitted, oldest first.
+
+        :param max_attempts: Failed plays are retried until they have been
+            submitted this often
+        :return: Rows with track_play_id, played_at, title, artist, album,
+            duration_ms and mbid
+        :rtype: list[dict]
+        """
+        return self._execute(SCROBBLE_CANDIDATES_SQL, {
+            "username": username, "since": since, "limit": limit, "max_attempts": max_attempts,
+        })
+
+    def mark_scrobbles_pending(self, track_play_ids: list[int]):
+        self._execute(MARK_SCROBBLES_PENDING_SQL, {"track_play_ids": track_play_ids})
+
+    def update_scrobble(self, track_play_id: int, status: str, error: Optional[str] = None):
+        self._execute(UPDATE_SCROBBLE_SQL, {"track_play_id": track_play_id, "status": status, "error": error})
+
+    def refresh_rollups(self, since: Optional[date] = None, full: bool = False):
+        """
+        Recompute the daily rollups from a local day through yesterday.
+
+        The last day already rolled up is always recomputed as well, so plays
+        still running at midnight are picked up and no gap is left between
+        the old and new rollups. Without any rollups yet, with rollups
+        bucketed in another time zone than USER_TIMEZONE, or with full, the
+        whole history is recomputed.
+
+        :param since: First local day to recompute; None starts at the last
+            day already rolled up
+        :param full: Recompute the whole history
+        """
+        through = datetime.now(ZoneInfo(USER_TIMEZONE)).date() - timedelta(days=1)
+        rows = self._execute(ROLLUP_STATE_SQL, {})
+        refreshed_through = rows[0]["refreshed_through"] if rows else None
+        if rows and rows[0]["tz"] != USER_TIMEZONE:
+            log.info("Rollup time zone changed, recomputing all days",
+                     previous=rows[0]["tz"], tz=USER_TIMEZONE)
+            full = True
+
+        if full or refreshed_through is None:
+            since = None
+        elif since is None or since > refreshed_through:
+            since = refreshed_through
+
+        self._execute(REFRESH_ROLLUPS_SQL, {"since": since, "through": through, "tz": USER_TIMEZONE})
+        log.info("Refreshed rollups",
+                 since=since.isoformat() if since else None,
+                 through=through.isoformat())
+
+
+class MonthlyJobs:
+    """
+    Runs once per local calendar month, on the first poll after it begins,
+    and summarizes the month that just ended.
+    """
+
+    def __init__(self, db: DatabaseWriter):
+        self.db = db
+        self.last_month: date | None = None
+
+    def run_if_due(self):
+        month = datetime.now(ZoneInfo(USER_TIMEZONE)).date().replace(day=1)
+        if month == self.last_month:
+            return
+
+        previous_month = (month - timedelta(days=1)).replace(day=1)
+        try:
+            self.db.compute_monthly_discoveries(previous_month)
+        except psycopg2.Error as e:
+            log.error("Monthly discoveries job failed", month=previous_month.isoformat(), error=str(e))
+            self.db.conn.rollback()
+            return
+        self.last_month = month
+
+class DailyJobs:
+    """
+    Runs once per local calendar day, on the first poll after it begins,
+    brings the daily rollups up to yesterday and sends yesterday's summary.
+    The summary is left out on the first run after a start, so restarts do
+    not repeat it.
+    """
+
+    def __init__(self, db: DatabaseWriter):
+        self.db = db
+        self.last_day: date | None = None
+
+    def run_if_due(self):
+        day = datetime.now(ZoneInfo(USER_TIMEZONE)).date()
+        if day == self.last_day:
+            return
+
+        try:
+            self.db.refresh_rollups()
+        except psycopg2.Error as e:
+            log.error("Rollup refresh failed", day=day.isoformat(), error=str(e))
+            self.db.conn.rollback()
+            return
+        if self.last_day is not None:
+            self._send_summary(day - timedelta(days=1))
+        self.last_day = day
+
+    def _send_summary(self, day: date):
+        if not self.db.notifications.notifiers:
+            return
+        try:
+            summary = self.db.daily_summary(day)
+        except psycopg2.Error as e:
+            log.error("Daily summary failed", day=day.isoformat(), error=str(e))
+            self.db.conn.rollback()
+            return
+        if summary:
+            self.db.notifications.daily_summary(summary)
+
 class SongProcessor:
-    SKIP_THRESHOLD = 0.9
-    MIN_SKIP_MS = 5000
+    SKIP_THRESHOLD = SKIP_THRESHOLD
+    MIN_SKIP_MS = MIN_SKIP_MS
 
     def __init__(self, db: DatabaseWriter):
         self.db = db
 
     def process(self):
-        for key in lastPlaybacks.keys():
+        # Finalizing removes the playback from lastPlaybacks.
+        for key in list(lastPlaybacks):
             if key not in currentPlaybacks:
                 self._finalize_previous(key)
         for key, state in currentPlaybacks.items():
@@ -207,19 +888,20 @@ class SongProcessor:
                      accumulated_playtime=lastState.accumulated_playtime)
             return
 
+        decision = self._decide_skip(lastState)
+        skipped = decision.skipped
         if not lastState.song.duration:
-            skipped = False
+            skip_score = None
         else:
-            ratio = lastState.accumulated_playtime / lastState.song.duration
-            if (lastState.song.duration * (1 - self.SKIP_THRESHOLD)) <= self.MIN_SKIP_MS:
-                skipped = (lastState.song.duration - lastState.accumulated_playtime) > self.MIN_SKIP_MS
-            else:
-                skipped = ratio < self.SKIP_THRESHOLD
+            # 0.0 = played to the end, 1.0 = skipped right away
+            skip_score = round(min(max(1.0 - lastState.accumulated_playtime / lastState.song.duration, 0.0), 1.0), 3)
 
         log.info("Song ended",
                  track_key=lastState.song.track_key,
                  accumulated_playtime=lastState.accumulated_playtime,
                  skipped=skipped,
+                 skip_rule=decision.rule,
+                 skip_score=skip_score,
                  start_timestamp=lastState.start_ts,
                  end_timestamp=now_ms())
 
@@ -228,45 +910,221 @@ class SongProcessor:
             played_at=datetime.fromtimestamp(lastState.start_ts / 1000),
             user_id=lastState.user_id,
             skipped=skipped,
+            skip_score=skip_score,
+            device_name=lastState.client_id,
+            skip_decision=decision,
         )
 
         del lastPlaybacks[key]
 
+    def _decide_skip(self, state: PlaybackState) -> SkipDecision:
+        """
+        Decide whether a finished play was skipped.
+
+        Usually a play is skipped when less than SKIP_THRESHOLD of the track
+        was played. For tracks so short that the rest would be under
+        MIN_SKIP_MS, it is skipped only when more than MIN_SKIP_MS were left.
+        """
+        duration = state.song.duration
+        played = state.accumulated_playtime
+        decision = SkipDecision(
+            skipped=False,
+            rule="unknown_duration",
+            played_ms=played,
+            threshold=self.SKIP_THRESHOLD,
+            min_skip_ms=self.MIN_SKIP_MS,
+        )
+        if not duration:
+            return decision
+
+        decision.expected_ms = duration
+        decision.ratio = round(played / duration, 3)
+        if (duration * (1 - self.SKIP_THRESHOLD)) <= self.MIN_SKIP_MS:
+            decision.rule = "remaining_ms"
+            decision.skipped = (duration - played) > self.MIN_SKIP_MS
+        else:
+            decision.rule = "ratio"
+            decision.skipped = played / duration < self.SKIP_THRESHOLD
+        return decision
+
     def _reset(self, key: str, state: PlaybackState):
         lastPlaybacks[key] = state
         lastPlaybacks[key].start_ts = now_ms()
         lastPlaybacks[key].accumulated_playtime = 0
 
+# Shutdown
+
+class Shutdown:
+    """
+    Turns SIGTERM and SIGINT into a graceful stop: the main loop finishes the
+    poll it is in and returns. If that takes longer than the grace period, or
+    a second signal arrives, the process exits immediately with status 1.
+    """
+
+    def __init__(self, grace_seconds: float = SHUTDOWN_GRACE_SECONDS,
+                 on_request: Optional[Callable[[], None]] = None):
+        self.grace_seconds = grace_seconds
+        self.on_request = on_request
+        self._requested = threading.Event()
+
+    def install(self):
+        signal.signal(signal.SIGTERM, self._handle)
+        signal.signal(signal.SIGINT, self._handle)
+
+    @property
+    def requested(self) -> bool:
+        return self._requested.is_set()
+
+    def wait(self, seconds: float) -> bool:
+        """
+        Sleep for up to `seconds`, waking early when a shutdown is requested.
+
+        :return: Whether a shutdown was requested
+        :rtype: bool
+        """
+        return self._requested.wait(seconds)
+
+    def _handle(self, signum, frame):
+        name = signal.Signals(signum).name
+        if self.requested:
+            log.warning("Second signal received, exiting immediately", signal=name)
+            os._exit(1)
+
+        log.info("Shutdown requested, finishing current poll", signal=name, grace_seconds=self.grace_seconds)
+        self._requested.set()
+        if self.on_request:
+            self.on_request()
+        timer = threading.Timer(self.grace_seconds, self._force_exit)
+        timer.daemon = True
+        timer.start()
+
+    def _force_exit(self):
+        log.error("Shutdown grace period exceeded, exiting", grace_seconds=self.grace_seconds)
+        os._exit(1)
+
 # Main Loop
 
-def listen_forever():
+def listen_forever(password_file: Optional[str] = NAVIDROME_PASSWORD_FILE, dry_run: bool = DRY_RUN,
+                   jitter: float = POLL_JITTER, poll_interval: float = POLL_INTERVAL,
+                   max_poll_interval: Optional[float] = POLL_MAX_INTERVAL,
+                   fetch_from_file: Optional[str] = FETCH_FROM_FILE):
+    log.info("Starting tracker", version=version_string(), dry_run=dry_run, jitter=jitter,
+             poll_interval=poll_interval, max_poll_interval=max_poll_interval, config_file=CONFIG_FILE,
+             fetch_from_file=fetch_from_file)
+    log.debug("Loaded environment file", path=ENV_FILE)
+    if dry_run:
+        log.warning("(DRY RUN) Nothing will be written to the database")
     health_status = HealthStatus(
-        poll_interval=HealthStatus.DEFAULT_POLL_INTERVAL,
+        poll_interval=poll_interval,
         last_health_log=0,
+        base_poll_interval=poll_interval,
     )
-    client = MusicStreamClient(health_status=health_status)
+    if fetch_from_file:
+        client = FixtureClient(health_status=health_status, path=fetch_from_file)
+    else:
+        client = MusicStreamClient(health_status=health_status, password_file=password_file)
+    notifications = Notifications.configured()
+    scheduler = PollScheduler(poll_interval, jitter=jitter, max_interval=max_poll_interval)
+    notifier = ServiceNotifier(scheduler.longest_interval, healthy=lambda: health.liveness()[0])
+    shutdown = Shutdown(on_request=notifier.stopping)
+    shutdown.install()
+    serve_health(HEALTH_PORT)
+    setup_tracing()
 
-    while True:
+    while not shutdown.requested:
         try:
             log.info("Connecting to database...")
-            with psycopg2.connect(**DB_CONFIG) as conn:
-                db = DatabaseWriter(conn)
+            with closing(psycopg2.connect(**DB_CONFIG)) as conn:
+                db = DatabaseWriter(conn, dry_run=dry_run, notifications=notifications)
                 tracker = SongProcessor(db)
+                monthly_jobs = MonthlyJobs(db)
+                daily_jobs = DailyJobs(db)
+                scrobbler = None if dry_run else Scrobbler.configured(db)
 
-                while True:
-                    client.fetch_songs()
-                    tracker.process()
-                    time.sleep(health_status.poll_interval)
+                while not shutdown.requested:
+                    POLL_INTERVAL_SECONDS.set(max(scheduler.interval, health_status.poll_interval))
+                    poll_started = time.monotonic()
+                    inserted_before = db.plays_inserted
+                    with tracer.start_as_current_span("poll") as poll_span:
+                        monthly_jobs.run_if_due()
+                        daily_jobs.run_if_due()
+                        with tracer.start_as_current_span("fetch_now_playing") as span:
+                            client.fetch_songs()
+                            span.set_attribute("navidrome.entries", len(currentPlaybacks))
+                        with tracer.start_as_current_span("process_playbacks"):
+                            tracker.process()
+                        if scrobbler:
+                            with tracer.start_as_current_span("scrobble"):
+                                scrobbler.run_if_due(new_plays=db.plays_inserted > inserted_before)
+                        poll_span.set_attribute("tracker.plays_inserted", db.plays_inserted - inserted_before)
+                    notifications.flush()
+                    scheduler.record_poll(active=bool(currentPlaybacks))
+                    # Ready means connected to the database and Navidrome accepted the credentials.
+                    if health.navidrome_ok:
+                        notifier.poll_succeeded()
+                    poll_ms = (time.monotonic() - poll_started) * 1000
+                    statsd.timing("poll.duration_ms", poll_ms)
+                    log.debug("Poll finished",
+                              duration_ms=round(poll_ms),
+                              playing=len(currentPlaybacks),
+                              plays_inserted=db.plays_inserted - inserted_before)
+                    delay = scheduler.next_delay(minimum=health_status.poll_interval)
+                    health.loop_progressed(delay)
+                    shutdown.wait(delay)
         except psycopg2.OperationalError as e:
             log.warning("Database connection error, will retry", error=str(e), exc_info=True)
-            time.sleep(health_status.poll_interval)
+            health.error(f"database connection error: {e}")
+            health.loop_progressed(health_status.poll_interval)
+            shutdown.wait(health_status.poll_interval)
             continue
-        except KeyboardInterrupt:
-            log.info("Shutting down")
-            break
+        except NavidromeAuthError:
+            raise
         except Exception as e:
             log.error("Fatal error", error=str(e), exc_info=True)
-            time.sleep(5)
+            health.error(str(e))
+            health.loop_progressed(5)
+            shutdown.wait(5)
+
+    # Songs still playing have no end yet and are not recorded.
+    log.info("Tracker stopped", unfinished_playbacks=len(lastPlaybacks))
 
 if __name__ == "__main__":
-    listen_forever()
+    parser = argparse.ArgumentParser(description="Track Navidrome playback into the database", allow_abbrev=False)
+    parser.add_argument("--version", action="version", version=version_string())
+    parser.add_argument("--env-file", default=ENV_FILE,
+                        help="load environment variables from this file; already set variables win (default: .env if present)")
+    parser.add_argument("--config", default=CONFIG_FILE,
+                        help="TOML file with settings; environment variables take precedence (default: $TRACKER_CONFIG)")
+    parser.add_argument("--password-file", default=NAVIDROME_PASSWORD_FILE,
+                        help="read the Navidrome password from this file on every poll")
+    parser.add_argument("--dry-run", action="store_true", default=DRY_RUN,
+                        help="log the statements that would be executed instead of writing to the database")
+    parser.add_argument("--jitter", type=float, default=POLL_JITTER,
+                        help="move each poll randomly by up to this many seconds earlier or later")
+    parser.add_argument("--poll-interval", type=float, default=POLL_INTERVAL,
+                        help="seconds between polls while Navidrome is reachable")
+    parser.add_argument("--fetch-from-file", default=FETCH_FROM_FILE,
+                        help="replay getNowPlaying responses from this JSON file instead of asking Navidrome")
+    parser.add_argument("--max-poll-interval", type=float, default=POLL_MAX_INTERVAL,
+                        help="poll less often while nothing is playing, up to this many seconds between polls")
+    args = parser.parse_args()
+
+    problems = list(CONFIG_ERRORS)
+    if args.jitter < 0:
+        problems.append(f"--jitter={args.jitter} must not be negative")
+    if args.poll_interval < 0.1:
+        problems.append(f"--poll-interval={args.poll_interval} must be at least 0.1")
+    if args.max_poll_interval is not None and args.max_poll_interval < args.poll_interval:
+        problems.append(f"--max-poll-interval={args.max_poll_interval} must not be below the poll interval")
+    if args.fetch_from_file:
+        try:
+            load_fixture(args.fetch_from_file)
+        except (OSError, ValueError) as e:
+            problems.append(f"--fetch-from-file: {e}")
+    if problems:
+        parser.exit(CONFIG, "Invalid configuration:\n" + "".join(f"  {problem}\n" for problem in problems))
+
+    sys.exit(run(lambda: listen_forever(password_file=args.password_file, dry_run=args.dry_run,
+                                        jitter=args.jitter, poll_interval=args.poll_interval,
+                                        max_poll_interval=args.max_poll_interval,
+                                        fetch_from_file=args.fetch_from_file)))
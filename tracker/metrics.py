@@ -0,0 +1,117 @@
+"""
+Prometheus metrics for the tracker, served on /metrics next to the health
+checks. Navidrome requests and database statements are measured by the
+wrappers here and in DatabaseWriter._execute; the rest is updated where
+the tracker already reports to `health`.
+
+With STATSD_ADDR set, inserted and skipped plays, Navidrome request
+latency and poll duration are also sent to a StatsD agent through `statsd`.
+"""
+import socket
+import time
+from typing import Optional
+
+import requests
+from opentelemetry.trace import SpanKind
+from prometheus_client import CONTENT_TYPE_LATEST, Counter, Gauge, Histogram, generate_latest
+
+from config import STATSD_ADDR
+from logger import log
+from tracing import tracer
+from version import USER_AGENT
+
+NAVIDROME_REQUESTS = Counter(
+    "navidrome_requests", "Requests to the Navidrome API", ["endpoint", "status"])
+NAVIDROME_REQUEST_DURATION = Histogram(
+    "navidrome_request_duration_seconds", "Duration of Navidrome API requests", ["endpoint"])
+PLAYS_INSERTED = Counter("plays_inserted", "Plays stored in track_plays")
+PLAYS_SKIPPED = Counter("plays_skipped", "Stored plays that were marked as skipped")
+POLL_ERRORS = Counter("poll_errors", "Navidrome polls that failed")
+WEBHOOK_DELIVERIES = Counter(
+    "webhook_deliveries", "Webhook and chat notification requests by outcome after retries", ["service", "outcome"])
+SCROBBLES = Counter("scrobbles", "Plays submitted to Last.fm by outcome", ["status"])
+DB_WRITE_DURATION = Histogram("db_write_duration_seconds", "Duration of database statements, including commit")
+LAST_SUCCESSFUL_POLL = Gauge(
+    "last_successful_poll_timestamp_seconds", "Unix time of the last successful Navidrome poll")
+POLL_INTERVAL_SECONDS = Gauge(
+    "poll_interval_seconds", "Current poll interval; raised while Navidrome is unreachable or idle")
+
+
+class StatsDEmitter:
+    """
+    Sends metrics as StatsD datagrams over UDP, with DogStatsD tags.
+
+    Sending never blocks or raises: a datagram that cannot be sent is
+    dropped, as UDP would do anyway. Without an address every call is a no-op.
+    """
+
+    def __init__(self, addr: Optional[str], prefix: str = "tracker"):
+        self.prefix = prefix
+        self.sock = None
+        if not addr:
+            return
+        host, port = addr.rsplit(":", 1)
+        try:
+            self.sock = socket.socket(socket.AF_INET, socket.SOCK_DGRAM)
+            self.sock.setblocking(False)
+            # Resolves the host once; the agent does not have to be up yet.
+            self.sock.connect((host, int(port)))
+        except OSError as e:
+            log.warning("StatsD disabled, cannot resolve agent", addr=addr, error=str(e))
+            self.sock = None
+
+    def _send(self, name: str, value: float, kind: str, tags: Optional[dict] = None):
+        if self.sock is None:
+            return
+        line = f"{self.prefix}.{name}:{value}|{kind}"
+        if tags:
+            line += "|#" + ",".join(f"{key}:{tag}" for key, tag in tags.items())
+        try:
+            self.sock.send(line.encode())
+        except OSError:
+            pass
+
+    def incr(self, name: str, value: int = 1, tags: Optional[dict] = None):
+        self._send(name, value, "c", tags)
+
+    def timing(self, name: str, ms: float, tags: Optional[dict] = None):
+        self._send(name, round(ms), "ms", tags)
+
+
+statsd = StatsDEmitter(STATSD_ADDR)
+
+
+def timed_get(endpoint: str, url: str, **kwargs) -> requests.Response:
+    """
+    requests.get that records the request count by status and its duration
+    and identifies the tracker build in the User-Agent header. Each request
+    is a "navidrome.request" span.
+
+    :param endpoint: Label for the API endpoint, e.g. "getNowPlaying"
+    :param url: Request URL
+    :return: The response
+    :raises requests.RequestException: As raised by requests.get
+    """
+    start = time.perf_counter()
+    status = "error"
+    try:
+        with tracer.start_as_current_span("navidrome.request", kind=SpanKind.CLIENT,
+                                          attributes={"navidrome.endpoint": endpoint}) as span:
+            headers = {"User-Agent": USER_AGENT, **kwargs.pop("headers", {})}
+            response = requests.get(url, headers=headers, **kwargs)
+            status = str(response.status_code)
+            span.set_attribute("http.response.status_code", response.status_code)
+            return response
+    finally:
+        NAVIDROME_REQUESTS.labels(endpoint=endpoint, status=status).inc()
+        elapsed = time.perf_counter() - start
+        NAVIDROME_REQUEST_DURATION.labels(endpoint=endpoint).observe(elapsed)
+        statsd.timing("api.latency_ms", elapsed * 1000, {"endpoint": endpoint})
+
+
+def render() -> tuple[bytes, str]:
+    """
+    :return: All metrics in the Prometheus text format, and its content type
+    :rtype: tuple[bytes, str]
+    """
+    return generate_latest(), CONTENT_TYPE_LATEST
@@ -0,0 +1,49 @@
+"""
+Maintenance commands for the milestones table.
+
+The tracker records milestones as plays come in. After imports or the
+initial migration, rebuild them from the full history instead.
+
+Usage: python milestones.py recompute
+"""
+import argparse
+import sys
+from contextlib import closing
+
+import psycopg2
+from psycopg2.extras import RealDictCursor
+
+from config import DB_CONFIG, MILESTONE_COUNTS
+from exit_codes import run
+from logger import log
+from sql_queries import CLEAR_MILESTONES_SQL, RECORD_MILESTONES_SQL
+
+
+def recompute_milestones() -> int:
+    """
+    Replace all milestones with the ones derived from the current history.
+
+    Both steps run in one transaction, so readers never see an empty table
+    and a failed run leaves the old milestones in place.
+
+    :return: Number of milestones recorded
+    :rtype: int
+    """
+    with closing(psycopg2.connect(**DB_CONFIG)) as conn:
+        with conn, conn.cursor(cursor_factory=RealDictCursor) as cur:
+            cur.execute(CLEAR_MILESTONES_SQL)
+            cur.execute(RECORD_MILESTONES_SQL, {"mbid": None, "counts": MILESTONE_COUNTS})
+            rows = cur.fetchall()
+
+    log.info("Recomputed milestones", milestones=len(rows), counts=MILESTONE_COUNTS)
+    return len(rows)
+
+
+if __name__ == "__main__":
+    parser = argparse.ArgumentParser(description="Maintain play count milestones")
+    subparsers = parser.add_subparsers(dest="command", required=True)
+    subparsers.add_parser("recompute", help="rebuild the milestones table from the play history")
+    args = parser.parse_args()
+
+    if args.command == "recompute":
+        sys.exit(run(recompute_milestones))
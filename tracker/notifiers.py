@@ -0,0 +1,359 @@
+"""
+Notifications about new plays, milestones and the daily summary.
+
+DatabaseWriter collects the plays and milestones of each poll in
+`Notifications`, which hands them to every configured Notifier once the
+poll is over; DailyJobs passes on yesterday's summary. A Notifier decides
+what to send and in which format:
+
+- WebhookNotifier POSTs one JSON document per poll that stored plays to
+  every URL in WEBHOOK_URLS:
+
+      {"plays": [{"track": ..., "artist": ..., "album": ..., "played_at": ...,
+                  "context": {"user": ..., "device_name": ..., "skipped": ...}}],
+       "milestones": [{"kind": ..., "subject": ..., "count": ..., "played_at": ...}]}
+
+  With WEBHOOK_SECRET set, the X-Webhook-Signature header carries "sha256="
+  followed by the hex HMAC-SHA256 of the body under that secret.
+- DiscordNotifier and SlackNotifier post chat messages to an incoming
+  webhook: one per play or batch of plays, one per milestone and one for
+  the daily summary, each type switched by a NOTIFY_* setting.
+
+Requests are sent from a background thread so a slow receiver never holds
+up polling. Each one times out after WEBHOOK_TIMEOUT seconds and is retried
+up to WEBHOOK_RETRIES times with a doubling delay; a delivery that still
+fails is logged and counted in the webhook_deliveries metric, and dropped.
+"""
+import hashlib
+import hmac
+import json
+import queue
+import threading
+import time
+from datetime import date, datetime
+from typing import Optional
+from urllib.parse import urlparse
+
+import requests
+
+from config import (
+    DISCORD_WEBHOOK_URL,
+    NOTIFY_DAILY_SUMMARY,
+    NOTIFY_MILESTONES,
+    NOTIFY_PLAYS,
+    NOTIFY_PLAYS_EVERY_MINUTES,
+    SLACK_WEBHOOK_URL,
+    WEBHOOK_SECRET,
+    WEBHOOK_URLS,
+)
+from logger import log
+from metrics import WEBHOOK_DELIVERIES
+from version import USER_AGENT
+
+WEBHOOK_TIMEOUT = 5
+WEBHOOK_RETRIES = 3
+# Delay before the first retry in seconds; doubles with each further one.
+WEBHOOK_BACKOFF = 1.0
+
+# Plays listed in a batched chat message; the rest are only counted.
+CHAT_PLAYS_LISTED = 10
+
+
+def sign(body: bytes, secret: str) -> str:
+    """
+    :param body: Request body as sent
+    :param secret: Shared secret
+    :return: Value of the X-Webhook-Signature header
+    :rtype: str
+    """
+    return "sha256=" + hmac.new(secret.encode(), body, hashlib.sha256).hexdigest()
+
+
+def _json_default(value):
+    if isinstance(value, (date, datetime)):
+        return value.isoformat()
+    raise TypeError(f"{type(value).__name__} is not JSON serializable")
+
+
+class Delivery:
+    """
+    Background thread that POSTs queued requests with retries.
+    """
+
+    def __init__(self):
+        self._queue: queue.Queue = queue.Queue()
+        self._thread: Optional[threading.Thread] = None
+
+    def post(self, service: str, url: str, payload: dict, headers: Optional[dict] = None):
+        """
+        Queue a JSON POST; it is sent in the background.
+
+        :param service: Label for logs and metrics, e.g. "discord"
+        :param url: Target URL
+        :param payload: Request body, encoded as JSON
+        :param headers: Further headers, computed from the encoded body
+        """
+        body = json.dumps(payload, default=_json_default).encode()
+        if self._thread is None:
+            self._thread = threading.Thread(target=self._deliver_forever, name="notifications", daemon=True)
+            self._thread.start()
+        self._queue.put((service, url, body, headers(body) if callable(headers) else headers or {}))
+
+    def _deliver_forever(self):
+        while True:
+            self._deliver(*self._queue.get())
+
+    def _deliver(self, service: str, url: str, body: bytes, extra_headers: dict) -> bool:
+        headers = {"Content-Type": "application/json", "User-Agent": USER_AGENT, **extra_headers}
+
+        delay = WEBHOOK_BACKOFF
+        for attempt in range(WEBHOOK_RETRIES + 1):
+            try:
+                response = requests.post(url, data=body, headers=headers, timeout=WEBHOOK_TIMEOUT)
+                response.raise_for_status()
+                WEBHOOK_DELIVERIES.labels(service=service, outcome="delivered").inc()
+                return True
+            except requests.HTTPError as e:
+                error = f"HTTP {e.response.status_code}"
+            except requests.RequestException as e:
+                # The message would repeat the URL.
+                error = type(e).__name__
+            if attempt < WEBHOOK_RETRIES:
+                time.sleep(delay)
+                delay *= 2
+
+        # The URL may embed a token, so only its host is logged.
+        log.warning("Webhook delivery failed, dropping it",
+                    service=service,
+                    host=urlparse(url).hostname,
+                    attempts=WEBHOOK_RETRIES + 1,
+                    error=error)
+        WEBHOOK_DELIVERIES.labels(service=service, outcome="failed").inc()
+        return False
+
+
+class Notifier:
+    """
+    A service that hears about new plays. Subclasses override the events
+    they report; the rest are ignored.
+    """
+
+    name = "notifier"
+
+    def poll_finished(self, plays: list[dict], milestones: list[dict]):
+        """
+        Called after every poll, also when nothing new was stored, so that
+        batched messages can be sent on time.
+
+        :param plays: Plays stored during the poll, see `Notifications.add_play`
+        :param milestones: Milestones reached during the poll
+        """
+
+    def daily_summary(self, summary: dict):
+        """
+        :param summary: Totals of the previous local day, see
+            DatabaseWriter.daily_summary
+        """
+
+
+class WebhookNotifier(Notifier):
+    name = "webhook"
+
+    def __init__(self, urls: list[str], secret: Optional[str], delivery: Delivery):
+        self.urls = urls
+        self.secret = secret
+        self.delivery = delivery
+
+    def poll_finished(self, plays: list[dict], milestones: list[dict]):
+        if not plays:
+            return
+        headers = (lambda body: {"X-Webhook-Signature": sign(body, self.secret)}) if self.secret else None
+        for url in self.urls:
+            self.delivery.post(self.name, url, {"plays": plays, "milestones": milestones}, headers)
+
+
+class ChatNotifier(Notifier):
+    """
+    Posts human-readable messages to a chat service's incoming webhook.
+    Subclasses define the payload and markup of the service.
+
+    Without a batch interval every poll that stored plays sends one
+    message. With one, the first play is sent right away and later plays
+    are held back until the interval since the last message is over, then
+    sent as one summary.
+    """
+
+    def __init__(self, url: str, delivery: Delivery, plays: bool = True, batch_seconds: float = 0,
+                 milestones: bool = True, daily_summary: bool = True):
+        self.url = url
+        self.delivery = delivery
+        self.send_plays = plays
+        self.batch_seconds = batch_seconds
+        self.send_milestones = milestones
+        self.send_daily_summary = daily_summary
+        self.pending: list[dict] = []
+        self.last_sent: Optional[float] = None
+
+    def payload(self, text: str) -> dict:
+        raise NotImplementedError
+
+    def bold(self, text: str) -> str:
+        raise NotImplementedError
+
+    def _post(self, text: str):
+        self.delivery.post(self.name, self.url, self.payload(text))
+
+    def poll_finished(self, plays: list[dict], milestones: list[dict]):
+        if self.send_plays:
+            self.pending.extend(plays)
+            now = time.monotonic()
+            if self.pending and (self.last_sent is None or now - self.last_sent >= self.batch_seconds):
+                window = now - self.last_sent if self.batch_seconds and self.last_sent is not None else None
+                self._post(self._plays_text(self.pending, window))
+                self.pending = []
+                self.last_sent = now
+
+        if self.send_milestones:
+            for milestone in milestones:
+                self._post(self._milestone_text(milestone))
+
+    def daily_summary(self, summary: dict):
+        if not self.send_daily_summary:
+            return
+        minutes = round(summary["duration_ms"] / 60_000)
+        text = (f"{self.bold('Yesterday')} ({summary['day'].isoformat()}): {summary['plays']} plays, "
+                f"{minutes // 60}h {minutes % 60:02d}m listened, {summary['skips']} skipped")
+        if summary["top_artist"]:
+            text += f". Top artist: {summary['top_artist']}"
+        self._post(text)
+
+    def _play_line(self, play: dict) -> str:
+        line = f"{play['artist']} — {play['track']}"
+        if play["context"]["device_name"]:
+            line += f" (on {play['context']['device_name']})"
+        return line
+
+    def _plays_text(self, plays: list[dict], window: Optional[float]) -> str:
+        if len(plays) == 1:
+            return f"{self.bold('Now logged:')} {self._play_line(plays[0])}"
+
+        heading = f"Logged {len(plays)} tracks"
+        if window is not None:
+            heading += f" in the last {_duration(window)}"
+        lines = [self.bold(heading)] + [self._play_line(play) for play in plays[:CHAT_PLAYS_LISTED]]
+        if len(plays) > CHAT_PLAYS_LISTED:
+            lines.append(f"… and {len(plays) - CHAT_PLAYS_LISTED} more")
+        return "\n".join(lines)
+
+    def _milestone_text(self, milestone: dict) -> str:
+        kind, subject, count = milestone["kind"], milestone["subject"], milestone["count"]
+        if kind == "new_artist":
+            return f"{self.bold('New artist:')} {subject}"
+        if kind == "total_plays":
+            return f"{self.bold('Milestone:')} {count} plays in total"
+        return f"{self.bold('Milestone:')} {count} plays of {subject}"
+
+
+class DiscordNotifier(ChatNotifier):
+    name = "discord"
+
+    def payload(self, text: str) -> dict:
+        return {"content": text}
+
+    def bold(self, text: str) -> str:
+        return f"**{text}**"
+
+
+class SlackNotifier(ChatNotifier):
+    name = "slack"
+
+    def payload(self, text: str) -> dict:
+        return {"text": text}
+
+    def bold(self, text: str) -> str:
+        return f"*{text}*"
+
+
+def _duration(seconds: float) -> str:
+    minutes = round(seconds / 60)
+    if minutes == 60:
+        return "hour"
+    if minutes <= 1:
+        return "minute"
+    if minutes < 60:
+        return f"{minutes} minutes"
+    return f"{minutes // 60}h {minutes % 60:02d}m"
+
+
+class Notifications:
+    """
+    Collects the plays and milestones of a poll and passes them on to the
+    notifiers. Without notifiers every call is a no-op.
+    """
+
+    def __init__(self, notifiers: list[Notifier]):
+        self.notifiers = notifiers
+        self.plays: list[dict] = []
+        self.milestones: list[dict] = []
+
+    @classmethod
+    def configured(cls) -> "Notifications":
+        """
+        :return: Notifications to the services set up in the configuration
+        :rtype: Notifications
+        """
+        delivery = Delivery()
+        notifiers: list[Notifier] = []
+        if WEBHOOK_URLS:
+            notifiers.append(WebhookNotifier(WEBHOOK_URLS, WEBHOOK_SECRET, delivery))
+        chat = {"plays": NOTIFY_PLAYS, "batch_seconds": NOTIFY_PLAYS_EVERY_MINUTES * 60,
+                "milestones": NOTIFY_MILESTONES, "daily_summary": NOTIFY_DAILY_SUMMARY}
+        if DISCORD_WEBHOOK_URL:
+            notifiers.append(DiscordNotifier(DISCORD_WEBHOOK_URL, delivery, **chat))
+        if SLACK_WEBHOOK_URL:
+            notifiers.append(SlackNotifier(SLACK_WEBHOOK_URL, delivery, **chat))
+        if notifiers:
+            log.info("Notifications enabled", notifiers=[notifier.name for notifier in notifiers])
+        return cls(notifiers)
+
+    def add_play(self, title: str, artist: str, album: str, played_at: datetime,
+                 user_id: str, device_name: Optional[str], skipped: bool):
+        if not self.notifiers:
+            return
+        self.plays.append({
+            "track": title,
+            "artist": artist,
+            "album": album,
+            "played_at": played_at,
+            "context": {"user": user_id, "device_name": device_name, "skipped": skipped},
+        })
+
+    def add_milestones(self, milestones: list[dict]):
+        if not self.notifiers:
+            return
+        self.milestones.extend({
+            "kind": row["kind"],
+            "subject": row["subject"],
+            "count": row["count"],
+            "played_at": row["played_at"],
+        } for row in milestones)
+
+    def flush(self):
+        """
+        Pass on what was collected since the last flush.
+        """
+        plays, milestones = self.plays, self.milestones
+        self.plays, self.milestones = [], []
+        for notifier in self.notifiers:
+            self._call(notifier, "poll_finished", plays, milestones)
+
+    def daily_summary(self, summary: dict):
+        for notifier in self.notifiers:
+            self._call(notifier, "daily_summary", summary)
+
+    def _call(self, notifier: Notifier, event: str, *args):
+        # A broken notifier must not end the poll.
+        try:
+            getattr(notifier, event)(*args)
+        except Exception as e:
+            log.error("Notifier failed", notifier=notifier.name, event=event, error=str(e), exc_info=True)
@@ -0,0 +1,34 @@
+"""
+Maintenance commands for the daily rollup tables.
+
+The tracker rolls up each finished day on its own. After imports, backfills
+or the initial migration, recompute the affected days instead.
+
+Usage: python rollups.py refresh [--since YYYY-MM-DD]
+"""
+import argparse
+import sys
+from contextlib import closing
+from datetime import date
+
+import psycopg2
+
+from config import DB_CONFIG
+from exit_codes import run
+from listener import DatabaseWriter
+
+
+if __name__ == "__main__":
+    parser = argparse.ArgumentParser(description="Maintain the daily rollup tables")
+    subparsers = parser.add_subparsers(dest="command", required=True)
+    refresh = subparsers.add_parser("refresh", help="recompute the rollups through yesterday")
+    refresh.add_argument("--since", type=date.fromisoformat,
+                         help="first local day to recompute (YYYY-MM-DD); the whole history when omitted")
+    args = parser.parse_args()
+
+    def refresh():
+        with closing(psycopg2.connect(**DB_CONFIG)) as conn:
+            DatabaseWriter(conn).refresh_rollups(since=args.since, full=args.since is None)
+
+    if args.command == "refresh":
+        sys.exit(run(refresh))
@@ -0,0 +1,82 @@
+"""
+Timing of the tracker's polls.
+
+The main loop asks PollScheduler how long to wait after each poll and tells
+it whether anything was playing, so that an idle Navidrome is polled less
+often and many trackers started at the same moment drift apart.
+"""
+import random
+from typing import Optional
+
+from logger import log
+
+
+def jittered(interval: float, jitter: float) -> float:
+    """
+    Randomize a sleep interval by up to jitter seconds in either direction.
+
+    :param interval: Base interval in seconds
+    :param jitter: Maximum deviation in seconds
+    :return: The interval to sleep, never negative
+    :rtype: float
+    """
+    if jitter <= 0:
+        return interval
+    return max(0.0, interval + random.uniform(-jitter, jitter))
+
+
+class PollScheduler:
+    """
+    Decides how long to wait before the next poll.
+
+    Polls run every base_interval seconds. With max_interval set, the
+    scheduler backs off while nothing is playing: after idle_polls empty
+    polls in a row the interval doubles with every further empty poll, up to
+    max_interval, and it returns to base_interval as soon as a poll sees a
+    playback. Each delay is moved randomly by up to jitter seconds.
+    """
+
+    # Empty polls in a row before the interval starts to grow.
+    IDLE_POLLS = 10
+
+    def __init__(self, base_interval: float, jitter: float = 0.0,
+                 max_interval: Optional[float] = None, idle_polls: int = IDLE_POLLS):
+        self.base_interval = base_interval
+        self.jitter = jitter
+        self.max_interval = max_interval
+        self.idle_polls = idle_polls
+        self.interval = base_interval
+        self.empty_polls = 0
+
+    @property
+    def longest_interval(self) -> float:
+        """
+        :return: The longest interval the scheduler waits for, before jitter
+        :rtype: float
+        """
+        return max(self.base_interval, self.max_interval or 0)
+
+    def record_poll(self, active: bool):
+        """
+        :param active: Whether the poll saw anything playing
+        """
+        if active:
+            if self.interval > self.base_interval:
+                log.info("Playback detected, back to the base poll interval", interval=self.base_interval)
+            self.interval = self.base_interval
+            self.empty_polls = 0
+            return
+
+        self.empty_polls += 1
+        if self.max_interval and self.empty_polls > self.idle_polls and self.interval < self.max_interval:
+            self.interval = min(self.interval * 2, self.max_interval)
+            log.debug("Nothing playing, polling less often", interval=self.interval, empty_polls=self.empty_polls)
+
+    def next_delay(self, minimum: float = 0.0) -> float:
+        """
+        :param minimum: Interval required by the caller, e.g. while backing
+            off from an outage; wins when it is longer
+        :return: Seconds to wait before the next poll
+        :rtype: float
+        """
+        return jittered(max(self.interval, minimum), self.jitter)
@@ -0,0 +1,267 @@
+"""
+Scrobbling of plays to Last.fm.
+
+With LASTFM_API_KEY, LASTFM_API_SECRET and LASTFM_SESSION_KEY set, the
+tracker submits the plays of LASTFM_SCROBBLE_USER that Last.fm counts as
+scrobbles with track.scrobble, up to 50 per request, after every poll that
+stored plays and every few minutes to retry failures. The outcome of each
+play is kept in the scrobbles table, so nothing is submitted twice.
+
+Usage: python scrobbler.py session --username NAME
+       python scrobbler.py backfill --since YYYY-MM-DD
+"""
+import argparse
+import getpass
+import hashlib
+import sys
+import time
+from contextlib import closing
+from datetime import date, datetime, timedelta, timezone
+from json import JSONDecodeError
+from typing import Optional
+
+import psycopg2
+import requests
+
+from config import (
+    DB_CONFIG,
+    LASTFM_API_KEY,
+    LASTFM_API_SECRET,
+    LASTFM_BASE,
+    LASTFM_SCROBBLE_USER,
+    LASTFM_SESSION_KEY,
+)
+from exit_codes import CONFIG, LastfmError, run
+from logger import log
+from metrics import SCROBBLES
+from version import USER_AGENT
+
+SCROBBLE_BATCH_SIZE = 50
+# Submissions of a play before it is given up.
+SCROBBLE_MAX_ATTEMPTS = 5
+# Last.fm ignores scrobbles older than this, so live scrobbling looks no further back.
+SCROBBLE_MAX_AGE = timedelta(days=14)
+# Seconds between scrobble runs while no new plays are stored, to retry failures.
+SCROBBLE_RETRY_INTERVAL = 300
+
+
+def api_signature(params: dict, secret: str) -> str:
+    """
+    :param params: Request parameters, without format
+    :param secret: Shared secret of the API account
+    :return: The api_sig parameter: MD5 of the sorted name/value pairs and the secret
+    :rtype: str
+    """
+    text = "".join(f"{name}{params[name]}" for name in sorted(params)) + secret
+    return hashlib.md5(text.encode("utf-8")).hexdigest()
+
+
+class LastfmClient:
+    def __init__(self, api_key: str, secret: str, session_key: Optional[str] = None, base: str = LASTFM_BASE):
+        self.api_key = api_key
+        self.secret = secret
+        self.session_key = session_key
+        # Authentication calls must use HTTPS; scrobbles go the same way.
+        self.base = base.replace("http://", "https://", 1)
+
+    def call(self, method: str, params: dict) -> dict:
+        """
+        POST a signed API call.
+
+        :param method: API method, e.g. "track.scrobble"
+        :param params: Method parameters; api_key and sk are added
+        :return: The decoded response
+        :rtype: dict
+        :raises LastfmError: If the request fails or Last.fm answers with an error
+        """
+        params = {**params, "method": method, "api_key": self.api_key}
+        if self.session_key:
+            params["sk"] = self.session_key
+        params = {name: str(value) for name, value in params.items() if value is not None}
+        params["api_sig"] = api_signature(params, self.secret)
+        params["format"] = "json"
+
+        try:
+            response = requests.post(self.base, data=params, headers={"User-Agent": USER_AGENT}, timeout=10)
+            data = response.json()
+        except requests.RequestException as e:
+            raise LastfmError(None, f"request failed: {e}") from e
+        except JSONDecodeError:
+            raise LastfmError(None, f"invalid JSON in HTTP {response.status_code} response")
+        if "error" in data:
+            raise LastfmError(data["error"], data.get("message", ""))
+        if not response.ok:
+            raise LastfmError(None, f"HTTP {response.status_code}")
+        return data
+
+    def session(self, username: str, password: str) -> str:
+        """
+        :return: A session key for the account, valid until it is revoked
+        :rtype: str
+        """
+        return self.call("auth.getMobileSession", {"username": username, "password": password})["session"]["key"]
+
+    def scrobble(self, plays: list[dict]) -> list[tuple[str, Optional[str]]]:
+        """
+        Submit up to 50 plays in one request.
+
+        :param plays: Rows of DatabaseWriter.scrobble_candidates
+        :return: Per play "accepted", or "ignored" with Last.fm's reason
+        :rtype: list[tuple[str, Optional[str]]]
+        """
+        params = {}
+        for i, play in enumerate(plays):
+            params[f"artist[{i}]"] = play["artist"]
+            params[f"track[{i}]"] = play["title"]
+            params[f"timestamp[{i}]"] = int(play["played_at"].timestamp())
+            params[f"album[{i}]"] = play["album"]
+            params[f"duration[{i}]"] = play["duration_ms"] // 1000
+            params[f"mbid[{i}]"] = play["mbid"]
+
+        results = self.call("track.scrobble", params)["scrobbles"]["scrobble"]
+        # A single scrobble comes back as an object instead of a list.
+        if isinstance(results, dict):
+            results = [results]
+
+        outcomes = []
+        for result in results:
+            ignored = result.get("ignoredMessage") or {}
+            if str(ignored.get("code", "0")) == "0":
+                outcomes.append(("accepted", None))
+            else:
+                outcomes.append(("ignored", ignored.get("#text") or f"ignored, code {ignored.get('code')}"))
+        return outcomes
+
+
+class Scrobbler:
+    """
+    Submits a user's new scrobbles through a DatabaseWriter. Every play is
+    marked pending, and committed, before its request is sent: if the
+    tracker dies before the answer is stored the play stays pending and is
+    not sent again. Failed plays are retried up to SCROBBLE_MAX_ATTEMPTS
+    times; accepted and ignored ones are final.
+    """
+
+    def __init__(self, db, client: LastfmClient, username: str):
+        self.db = db
+        self.client = client
+        self.username = username
+        self.last_run: Optional[float] = None
+
+    @classmethod
+    def configured(cls, db) -> Optional["Scrobbler"]:
+        """
+        :return: A scrobbler for the configured Last.fm account, None if
+            scrobbling is not set up
+        :rtype: Optional[Scrobbler]
+        """
+        if not (LASTFM_API_KEY and LASTFM_API_SECRET and LASTFM_SESSION_KEY):
+            return None
+        return cls(db, LastfmClient(LASTFM_API_KEY, LASTFM_API_SECRET, LASTFM_SESSION_KEY), LASTFM_SCROBBLE_USER)
+
+    def submit_batch(self, since: datetime) -> int:
+        """
+        Submit the oldest plays since a point in time that still need to go.
+
+        :return: Number of plays submitted, 0 once there are none left
+        :rtype: int
+        """
+        plays = self.db.scrobble_candidates(self.username, since, SCROBBLE_BATCH_SIZE, SCROBBLE_MAX_ATTEMPTS)
+        if not plays:
+            return 0
+
+        self.db.mark_scrobbles_pending([play["track_play_id"] for play in plays])
+        try:
+            outcomes = self.client.scrobble(plays)
+        except LastfmError as e:
+            log.error("Scrobbling failed", plays=len(plays), error=str(e))
+            for play in plays:
+                self.db.update_scrobble(play["track_play_id"], "failed", str(e))
+            SCROBBLES.labels(status="failed").inc(len(plays))
+            raise
+
+        for play, (status, error) in zip(plays, outcomes):
+            self.db.update_scrobble(play["track_play_id"], status, error)
+            SCROBBLES.labels(status=status).inc()
+            if error:
+                log.info("Last.fm ignored scrobble", track_play_id=play["track_play_id"], reason=error)
+        log.info("Scrobbled plays to Last.fm",
+                 plays=len(plays),
+                 accepted=sum(status == "accepted" for status, _ in outcomes))
+        return len(plays)
+
+    def run_if_due(self, new_plays: bool):
+        """
+        Submit waiting plays after a poll that stored some, or to retry
+        failures once SCROBBLE_RETRY_INTERVAL has passed. Errors are logged
+        and left for the next run.
+        """
+        now = time.monotonic()
+        if not new_plays and self.last_run is not None and now - self.last_run < SCROBBLE_RETRY_INTERVAL:
+            return
+        self.last_run = now
+
+        since = datetime.now(timezone.utc) - SCROBBLE_MAX_AGE
+        try:
+            while self.submit_batch(since) == SCROBBLE_BATCH_SIZE:
+                pass
+        except LastfmError:
+            # Logged and recorded by submit_batch.
+            pass
+        except psycopg2.Error as e:
+            log.error("Scrobbling failed", error=str(e))
+            self.db.conn.rollback()
+
+    def backfill(self, since: date) -> int:
+        """
+        Submit every waiting play since a local day, batch by batch.
+
+        :return: Number of plays submitted
+        :rtype: int
+        """
+        start = datetime.combine(since, datetime.min.time()).astimezone()
+        if datetime.now(timezone.utc) - start > SCROBBLE_MAX_AGE:
+            log.warning("Last.fm ignores scrobbles older than 14 days; they are recorded as ignored",
+                        since=since.isoformat())
+        total = 0
+        while True:
+            submitted = self.submit_batch(start)
+            total += submitted
+            if submitted < SCROBBLE_BATCH_SIZE:
+                break
+            # Stay well below Last.fm's rate limit.
+            time.sleep(1)
+        log.info("Scrobble backfill finished", since=since.isoformat(), plays=total)
+        return total
+
+
+if __name__ == "__main__":
+    parser = argparse.ArgumentParser(description="Scrobble plays to Last.fm")
+    subparsers = parser.add_subparsers(dest="command", required=True)
+    session = subparsers.add_parser("session", help="log in to Last.fm and print a LASTFM_SESSION_KEY")
+    session.add_argument("--username", required=True, help="Last.fm username; the password is prompted for")
+    backfill = subparsers.add_parser("backfill", help="submit the plays since a day that were not scrobbled yet")
+    backfill.add_argument("--since", type=date.fromisoformat, required=True,
+                          help="first local day to submit (YYYY-MM-DD)")
+    args = parser.parse_args()
+
+    if not (LASTFM_API_KEY and LASTFM_API_SECRET):
+        parser.exit(CONFIG, "LASTFM_API_KEY and LASTFM_API_SECRET must be set\n")
+    if args.command == "backfill" and not LASTFM_SESSION_KEY:
+        parser.exit(CONFIG, "LASTFM_SESSION_KEY must be set, see: python scrobbler.py session\n")
+
+    def print_session():
+        client = LastfmClient(LASTFM_API_KEY, LASTFM_API_SECRET)
+        print(client.session(args.username, getpass.getpass("Last.fm password: ")))
+
+    def run_backfill():
+        # listener imports this module, so it is only imported when needed.
+        from listener import DatabaseWriter
+
+        with closing(psycopg2.connect(**DB_CONFIG)) as conn:
+            Scrobbler.configured(DatabaseWriter(conn)).backfill(args.since)
+
+    if args.command == "session":
+        sys.exit(run(print_session))
+    if args.command == "backfill":
+        sys.exit(run(run_backfill))
@@ -0,0 +1,94 @@
+"""
+systemd service notifications for running the tracker with Type=notify.
+
+systemd passes NOTIFY_SOCKET (and WATCHDOG_USEC when WatchdogSec= is set)
+to the service; without them every call here is a no-op, so the tracker
+runs unchanged under Docker or by hand.
+"""
+import os
+import socket
+import threading
+from typing import Callable, Optional
+
+from logger import log
+
+
+def notify(state: str) -> bool:
+    """
+    Send a state such as "READY=1" to the systemd notification socket.
+
+    :param state: Newline-separated assignments, see sd_notify(3)
+    :return: Whether the message was sent
+    :rtype: bool
+    """
+    path = os.getenv("NOTIFY_SOCKET")
+    if not path:
+        return False
+    # A leading "@" stands for an abstract socket.
+    if path.startswith("@"):
+        path = "\0" + path[1:]
+    try:
+        with socket.socket(socket.AF_UNIX, socket.SOCK_DGRAM) as sock:
+            sock.sendto(state.encode(), path)
+        return True
+    except OSError as e:
+        log.warning("Could not notify systemd", state=state, error=str(e))
+        return False
+
+
+def watchdog_interval() -> Optional[float]:
+    """
+    :return: Seconds within which systemd expects WATCHDOG=1, or None if the
+        watchdog is off or meant for another process
+    :rtype: Optional[float]
+    """
+    usec = os.getenv("WATCHDOG_USEC")
+    pid = os.getenv("WATCHDOG_PID")
+    if not usec or (pid and pid != str(os.getpid())):
+        return None
+    try:
+        return int(usec) / 1_000_000 or None
+    except ValueError:
+        return None
+
+
+class ServiceNotifier:
+    """
+    Tells systemd when the tracker is ready, alive and stopping.
+
+    READY=1 is sent once, after the first poll with a database connection
+    and a successful Navidrome request; every later successful poll sends
+    WATCHDOG=1. If polls are further apart than half the watchdog interval,
+    a background thread sends the keepalives instead, as long as `healthy`
+    says the tracker still is.
+    """
+
+    def __init__(self, poll_interval: float, healthy: Callable[[], bool]):
+        self.enabled = bool(os.getenv("NOTIFY_SOCKET"))
+        self.ready = False
+        self.watchdog = watchdog_interval() if self.enabled else None
+        self.healthy = healthy
+        self._stopped = threading.Event()
+
+        if self.watchdog and self.watchdog / 2 < poll_interval:
+            thread = threading.Thread(target=self._keepalive, name="systemd-watchdog", daemon=True)
+            thread.start()
+
+    def poll_succeeded(self):
+        if not self.enabled:
+            return
+        if not self.ready:
+            self.ready = notify("READY=1\nSTATUS=Tracking playback")
+            log.info("Notified systemd that the tracker is ready", watchdog_seconds=self.watchdog)
+        if self.watchdog:
+            notify("WATCHDOG=1")
+
+    def stopping(self):
+        self._stopped.set()
+        if self.enabled:
+            notify("STOPPING=1")
+
+    def _keepalive(self):
+        while not self._stopped.wait(self.watchdog / 2):
+            if self.healthy():
+                notify("WATCHDOG=1")
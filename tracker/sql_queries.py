@@ -16,15 +16,533 @@ INSERT INTO track_plays (
     track_id,
     played_at,
     user_id,
-    skipped
+    skipped,
+    skip_score,
+    device_name,
+    days_since_last_play,
+    rediscovery
 )
 SELECT
     t.id,
     %(played_at)s,
     u.id,
-    %(skipped)s
+    %(skipped)s,
+    %(skip_score)s,
+    %(device_name)s,
+    prev.days,
+    COALESCE(prev.days >= %(rediscovery_days)s, false)
 FROM track_row t
 CROSS JOIN inserted_user u
+LEFT JOIN LATERAL (
+    SELECT FLOOR(EXTRACT(EPOCH FROM %(played_at)s::timestamptz - MAX(p.played_at)) / 86400)::int AS days
+    FROM track_plays p
+    WHERE p.track_id = t.id
+    AND p.user_id = u.id
+    AND p.played_at < %(played_at)s::timestamptz
+) prev ON true
 ON CONFLICT (user_id, track_id, played_at)
-DO NOTHING;
-"""
\ No newline at end of file
+DO NOTHING
+RETURNING id, days_since_last_play, rediscovery;
+"""
+
+# Keeps the first Navidrome entry seen for a track, so fields that are not
+# parsed yet can be backfilled from it later.
+STORE_RAW_JSON_SQL = """
+UPDATE tracks
+SET raw_json = %(raw_json)s::jsonb
+WHERE mbid = %(mbid)s
+AND raw_json IS NULL;
+"""
+
+NOTIFY_REDISCOVERY_SQL = """
+SELECT pg_notify('track_rediscovered', %(payload)s);
+"""
+
+# The latest unskipped plays of the same user as the given play, up to and
+# including it, newest first.
+RECENT_USER_PLAYS_SQL = """
+SELECT p.id, p.played_at, p.binge_session_id
+FROM track_plays tp
+JOIN track_plays p ON p.user_id = tp.user_id
+    AND p.played_at <= tp.played_at
+    AND p.skipped IS NOT TRUE
+WHERE tp.id = %(track_play_id)s
+ORDER BY p.played_at DESC
+LIMIT %(count)s;
+"""
+
+CREATE_BINGE_SESSION_SQL = """
+WITH session AS (
+    INSERT INTO binge_sessions (user_id, started_at, ended_at)
+    SELECT user_id, MIN(played_at), MAX(played_at)
+    FROM track_plays
+    WHERE id = ANY(%(play_ids)s)
+    GROUP BY user_id
+    RETURNING id
+),
+
+tagged AS (
+    UPDATE track_plays
+    SET binge_session_id = (SELECT id FROM session)
+    WHERE id = ANY(%(play_ids)s)
+)
+
+SELECT id::text FROM session;
+"""
+
+EXTEND_BINGE_SESSION_SQL = """
+WITH tagged AS (
+    UPDATE track_plays
+    SET binge_session_id = %(session_id)s
+    WHERE id = ANY(%(play_ids)s)
+    AND binge_session_id IS NULL
+    RETURNING played_at
+)
+
+UPDATE binge_sessions
+SET ended_at = GREATEST(ended_at, (SELECT MAX(played_at) FROM tagged))
+WHERE id = %(session_id)s;
+"""
+
+NOTIFY_BINGE_SQL = """
+SELECT pg_notify('binge_detected', %(payload)s);
+"""
+
+# The latest unskipped plays of the same user as the given play, up to and
+# including it, newest first, with the ids of their artists.
+RECENT_USER_ARTIST_PLAYS_SQL = """
+SELECT
+    p.id,
+    p.user_id,
+    p.track_id,
+    p.played_at,
+    COALESCE(ARRAY_AGG(at.artist_id ORDER BY at.artist_id) FILTER (WHERE at.artist_id IS NOT NULL), '{}')
+        AS artist_ids
+FROM track_plays tp
+JOIN track_plays p ON p.user_id = tp.user_id
+    AND p.played_at <= tp.played_at
+    AND p.skipped IS NOT TRUE
+LEFT JOIN artist_tracks at ON at.track_id = p.track_id
+WHERE tp.id = %(track_play_id)s
+GROUP BY p.id
+ORDER BY p.played_at DESC
+LIMIT %(count)s;
+"""
+
+ACTIVE_RABBIT_HOLES_SQL = """
+SELECT id::text, artist_id, ended_at
+FROM rabbit_holes
+WHERE user_id = %(user_id)s
+AND still_active;
+"""
+
+CREATE_RABBIT_HOLE_SQL = """
+INSERT INTO rabbit_holes (user_id, artist_id, started_at, ended_at, plays, tracks)
+SELECT user_id, %(artist_id)s, MIN(played_at), MAX(played_at), COUNT(*), COUNT(DISTINCT track_id)
+FROM track_plays
+WHERE id = ANY(%(play_ids)s)
+GROUP BY user_id
+RETURNING id::text, started_at, plays, tracks;
+"""
+
+# Moves the end of a rabbit hole to the given play and recounts it. Every
+# unskipped play of the artist in between belongs to the run, as anything
+# that broke it off would have ended the rabbit hole.
+EXTEND_RABBIT_HOLE_SQL = """
+UPDATE rabbit_holes rh
+SET ended_at = %(ended_at)s,
+    (plays, tracks) = (
+        SELECT COUNT(*), COUNT(DISTINCT tp.track_id)
+        FROM track_plays tp
+        JOIN artist_tracks at ON at.track_id = tp.track_id
+        WHERE at.artist_id = rh.artist_id
+        AND tp.user_id = rh.user_id
+        AND tp.skipped IS NOT TRUE
+        AND tp.played_at BETWEEN rh.started_at AND %(ended_at)s
+    )
+WHERE rh.id = %(rabbit_hole_id)s;
+"""
+
+END_RABBIT_HOLES_SQL = """
+UPDATE rabbit_holes
+SET still_active = false
+WHERE id = ANY(%(rabbit_hole_ids)s::uuid[]);
+"""
+
+INSERT_SKIP_EVENT_SQL = """
+INSERT INTO skip_events (track_play_id, rule, expected_ms, played_ms, ratio, threshold, min_skip_ms, skipped)
+VALUES (%(track_play_id)s, %(rule)s, %(expected_ms)s, %(played_ms)s, %(ratio)s, %(threshold)s, %(min_skip_ms)s, %(skipped)s)
+ON CONFLICT (track_play_id) DO NOTHING;
+"""
+
+# Creates the track row for a local file that has no MusicBrainz entry, so
+# INSERT_SQL can find it by its synthetic mbid. Existing rows are kept.
+UPSERT_LOCAL_TRACK_SQL = """
+WITH inserted_artists AS (
+    INSERT INTO artists (name)
+    SELECT UNNEST(%(artist_names)s::text[])
+    ON CONFLICT (name) DO UPDATE
+        SET name = EXCLUDED.name
+    RETURNING id
+),
+
+track AS (
+    INSERT INTO tracks (title, duration_ms, mbid, is_local)
+    VALUES (%(title)s, %(duration_ms)s, %(mbid)s, true)
+    ON CONFLICT (mbid) DO NOTHING
+    RETURNING id
+)
+
+INSERT INTO artist_tracks (artist_id, track_id)
+SELECT inserted_artists.id, track.id
+FROM inserted_artists, track
+ON CONFLICT DO NOTHING;
+"""
+
+# Genres already known for a track's artists. Only read, so it is also used
+# in dry-run mode.
+TRACK_GENRES_SQL = """
+SELECT g.name
+FROM tracks t
+JOIN track_genres tg ON tg.track_id = t.id
+JOIN genres g ON g.id = tg.genre_id
+WHERE t.mbid = %(mbid)s
+ORDER BY g.name;
+"""
+
+# Counts artists, tracks and genres whose first ever play falls into the
+# local calendar month starting at %(month)s.
+COMPUTE_MONTHLY_DISCOVERIES_SQL = """
+WITH month_bounds AS (
+    SELECT
+        %(month)s::date::timestamp AT TIME ZONE %(tz)s AS starts_at,
+        (%(month)s::date + interval '1 month')::timestamp AT TIME ZONE %(tz)s AS ends_at
+),
+
+first_track_plays AS (
+    SELECT MIN(tp.played_at) AS first_played
+    FROM track_plays tp
+    GROUP BY tp.track_id
+),
+
+first_artist_plays AS (
+    SELECT MIN(tp.played_at) AS first_played
+    FROM track_plays tp
+    JOIN artist_tracks at ON at.track_id = tp.track_id
+    GROUP BY at.artist_id
+),
+
+first_genre_plays AS (
+    SELECT MIN(tp.played_at) AS first_played
+    FROM track_plays tp
+    JOIN track_genres tg ON tg.track_id = tp.track_id
+    GROUP BY tg.genre_id
+)
+
+INSERT INTO monthly_discoveries (month, new_artists, new_tracks, new_genres)
+SELECT
+    %(month)s::date,
+    (SELECT COUNT(*) FROM first_artist_plays f
+     WHERE f.first_played >= m.starts_at AND f.first_played < m.ends_at),
+    (SELECT COUNT(*) FROM first_track_plays f
+     WHERE f.first_played >= m.starts_at AND f.first_played < m.ends_at),
+    (SELECT COUNT(*) FROM first_genre_plays f
+     WHERE f.first_played >= m.starts_at AND f.first_played < m.ends_at)
+FROM month_bounds m
+ON CONFLICT (month)
+DO UPDATE SET
+    new_artists = EXCLUDED.new_artists,
+    new_tracks = EXCLUDED.new_tracks,
+    new_genres = EXCLUDED.new_genres,
+    computed_at = now();
+"""
+
+# Plays are numbered per scope in (played_at, id) order, so the same history
+# always yields the same milestones. With %(mbid)s set only the track and
+# artists of that track are checked; NULL checks everything. Milestones that
+# already exist are kept as they are.
+RECORD_MILESTONES_SQL = """
+WITH scope_tracks AS (
+    SELECT id
+    FROM tracks
+    WHERE %(mbid)s::uuid IS NULL OR mbid = %(mbid)s::uuid
+),
+
+scope_artists AS (
+    SELECT DISTINCT at.artist_id
+    FROM artist_tracks at
+    WHERE at.track_id IN (SELECT id FROM scope_tracks)
+),
+
+total_plays AS (
+    SELECT
+        tp.id,
+        tp.played_at,
+        ROW_NUMBER() OVER (ORDER BY tp.played_at, tp.id) AS n
+    FROM track_plays tp
+),
+
+track_plays_numbered AS (
+    SELECT
+        tp.id,
+        tp.played_at,
+        tp.track_id AS subject_id,
+        ROW_NUMBER() OVER (PARTITION BY tp.track_id ORDER BY tp.played_at, tp.id) AS n
+    FROM track_plays tp
+    WHERE tp.track_id IN (SELECT id FROM scope_tracks)
+),
+
+artist_plays_numbered AS (
+    SELECT
+        tp.id,
+        tp.played_at,
+        at.artist_id AS subject_id,
+        ROW_NUMBER() OVER (PARTITION BY at.artist_id ORDER BY tp.played_at, tp.id) AS n
+    FROM track_plays tp
+    JOIN artist_tracks at ON at.track_id = tp.track_id
+    WHERE at.artist_id IN (SELECT artist_id FROM scope_artists)
+),
+
+candidates AS (
+    SELECT 'total_plays' AS kind, 0 AS subject_id, 'all tracks' AS subject, n, played_at, id
+    FROM total_plays
+    WHERE n = ANY(%(counts)s)
+
+    UNION ALL
+
+    SELECT 'track_plays', p.subject_id, t.title, p.n, p.played_at, p.id
+    FROM track_plays_numbered p
+    JOIN tracks t ON t.id = p.subject_id
+    WHERE p.n = ANY(%(counts)s)
+
+    UNION ALL
+
+    SELECT 'artist_plays', p.subject_id, a.name, p.n, p.played_at, p.id
+    FROM artist_plays_numbered p
+    JOIN artists a ON a.id = p.subject_id
+    WHERE p.n = ANY(%(counts)s)
+
+    UNION ALL
+
+    SELECT 'new_artist', p.subject_id, a.name, p.n, p.played_at, p.id
+    FROM artist_plays_numbered p
+    JOIN artists a ON a.id = p.subject_id
+    WHERE p.n = 1
+)
+
+INSERT INTO milestones (kind, subject_id, subject, count, played_at, track_play_id)
+SELECT kind, subject_id, subject, n, played_at, id
+FROM candidates
+ON CONFLICT (kind, subject_id, count) DO NOTHING
+RETURNING kind, subject, count, played_at, track_play_id;
+"""
+
+CLEAR_MILESTONES_SQL = """
+DELETE FROM milestones;
+"""
+
+ROLLUP_STATE_SQL = """
+SELECT refreshed_through, tz FROM rollup_state;
+"""
+
+# Plays from local day %(since)s (NULL = the beginning) through %(through)s.
+ROLLUP_PLAYS = """
+    (%(since)s::date IS NULL
+        OR tp.played_at >= %(since)s::date::timestamp AT TIME ZONE %(tz)s)
+    AND tp.played_at < (%(through)s::date + 1)::timestamp AT TIME ZONE %(tz)s
+"""
+
+# Replaces the rollups of the refreshed days in a single transaction. Artist
+# and genre rollups leave out skipped plays; a play of an artist with several
+# genres counts once per genre in plays and is split evenly between them in
+# the weighted columns.
+REFRESH_ROLLUPS_SQL = f"""
+DELETE FROM daily_listening
+WHERE %(since)s::date IS NULL OR day >= %(since)s::date;
+
+DELETE FROM daily_artist_listening
+WHERE %(since)s::date IS NULL OR day >= %(since)s::date;
+
+DELETE FROM daily_genre_listening
+WHERE %(since)s::date IS NULL OR day >= %(since)s::date;
+
+INSERT INTO daily_listening (day, plays, skips, duration_ms)
+SELECT
+    (tp.played_at AT TIME ZONE %(tz)s)::date,
+    COUNT(*),
+    COUNT(*) FILTER (WHERE tp.skipped),
+    COALESCE(SUM(t.duration_ms) FILTER (WHERE tp.skipped IS NOT TRUE), 0)
+FROM track_plays tp
+JOIN tracks t ON t.id = tp.track_id
+WHERE {ROLLUP_PLAYS}
+GROUP BY 1;
+
+INSERT INTO daily_artist_listening (day, artist_id, plays, duration_ms)
+SELECT
+    (tp.played_at AT TIME ZONE %(tz)s)::date,
+    at.artist_id,
+    COUNT(*),
+    COALESCE(SUM(t.duration_ms), 0)
+FROM track_plays tp
+JOIN tracks t ON t.id = tp.track_id
+JOIN artist_tracks at ON at.track_id = tp.track_id
+WHERE tp.skipped IS NOT TRUE
+AND {ROLLUP_PLAYS}
+GROUP BY 1, 2;
+
+INSERT INTO daily_genre_listening (day, genre_id, plays, weighted_plays, weighted_duration_ms)
+WITH play_genres AS (
+    SELECT
+        tp.id,
+        (tp.played_at AT TIME ZONE %(tz)s)::date AS day,
+        COALESCE(t.duration_ms, 0) AS duration_ms,
+        tg.genre_id
+    FROM track_plays tp
+    JOIN tracks t ON t.id = tp.track_id
+    JOIN track_genres tg ON tg.track_id = tp.track_id
+    WHERE tp.skipped IS NOT TRUE
+    AND {ROLLUP_PLAYS}
+),
+
+weighted AS (
+    SELECT
+        day,
+        genre_id,
+        1.0 / COUNT(*) OVER (PARTITION BY id) AS weight,
+        duration_ms::float8 / COUNT(*) OVER (PARTITION BY id) AS duration_ms
+    FROM play_genres
+)
+
+SELECT day, genre_id, COUNT(*), SUM(weight), SUM(duration_ms)
+FROM weighted
+GROUP BY day, genre_id;
+
+INSERT INTO rollup_state (id, refreshed_through, refreshed_at, tz)
+VALUES (true, %(through)s, now(), %(tz)s)
+ON CONFLICT (id) DO UPDATE
+    SET refreshed_through = EXCLUDED.refreshed_through,
+        refreshed_at = EXCLUDED.refreshed_at,
+        tz = EXCLUDED.tz;
+"""
+
+# Totals of one local day from the rollups; no row if nothing was played.
+DAILY_SUMMARY_SQL = """
+SELECT
+    dl.day,
+    dl.plays,
+    dl.skips,
+    dl.duration_ms,
+    (
+        SELECT a.name
+        FROM daily_artist_listening dal
+        JOIN artists a ON a.id = dal.artist_id
+        WHERE dal.day = dl.day
+        ORDER BY dal.plays DESC, dal.duration_ms DESC, a.name
+        LIMIT 1
+    ) AS top_artist
+FROM daily_listening dl
+WHERE dl.day = %(day)s;
+"""
+
+# Stores one entry of a library export. The entry is matched to a local
+# track by ISRC, or else by normalized title and one of its artists; later
+# imports of the same entry replace its counts and match.
+UPSERT_EXTERNAL_TRACK_SQL = """
+WITH isrc_match AS (
+    SELECT t.id
+    FROM tracks t
+    WHERE %(isrc)s::text IS NOT NULL
+    AND t.isrc = %(isrc)s
+    ORDER BY t.id
+    LIMIT 1
+),
+
+name_match AS (
+    SELECT t.id
+    FROM tracks t
+    JOIN artist_tracks at ON at.track_id = t.id
+    JOIN artists a ON a.id = at.artist_id
+    WHERE normalize_track_title(t.title) = normalize_track_title(%(title)s)
+    AND a.normalized_name IN (SELECT normalize_artist_name(name) FROM unnest(%(artists)s::text[]) AS name)
+    ORDER BY t.id
+    LIMIT 1
+)
+
+INSERT INTO external_tracks (
+    source, external_id, title, artist, album, duration_ms, isrc, play_count, last_played_at, track_id
+)
+VALUES (
+    %(source)s, %(external_id)s, %(title)s, %(artist)s, %(album)s, %(duration_ms)s, %(isrc)s,
+    %(play_count)s, %(last_played_at)s,
+    COALESCE((SELECT id FROM isrc_match), (SELECT id FROM name_match))
+)
+ON CONFLICT (source, external_id) DO UPDATE
+    SET title = EXCLUDED.title,
+        artist = EXCLUDED.artist,
+        album = EXCLUDED.album,
+        duration_ms = EXCLUDED.duration_ms,
+        isrc = EXCLUDED.isrc,
+        play_count = EXCLUDED.play_count,
+        last_played_at = EXCLUDED.last_played_at,
+        track_id = EXCLUDED.track_id,
+        imported_at = now()
+RETURNING track_id;
+"""
+
+# Plays of %(username)s since %(since)s that Last.fm counts as scrobbles and
+# that have not been submitted yet, or failed fewer than %(max_attempts)s
+# times. Last.fm's rule: the track is longer than 30 seconds and was
+# listened to for half its length or 4 minutes, whichever comes first.
+# Listened time is the duration minus the unplayed share in skip_score, so
+# plays without a score (unknown duration) are never submitted.
+SCROBBLE_CANDIDATES_SQL = """
+SELECT
+    tp.id AS track_play_id,
+    tp.played_at,
+    t.title,
+    (SELECT STRING_AGG(a.name, ' & ' ORDER BY a.name)
+     FROM artist_tracks at
+     JOIN artists a ON a.id = at.artist_id
+     WHERE at.track_id = t.id) AS artist,
+    (SELECT al.title
+     FROM album_tracks alt
+     JOIN albums al ON al.id = alt.album_id
+     WHERE alt.track_id = t.id
+     ORDER BY al.id
+     LIMIT 1) AS album,
+    t.duration_ms,
+    -- Local tracks have a made-up MusicBrainz id.
+    CASE WHEN t.is_local THEN NULL ELSE t.mbid END AS mbid
+FROM track_plays tp
+JOIN tracks t ON t.id = tp.track_id
+JOIN users u ON u.id = tp.user_id
+LEFT JOIN scrobbles s ON s.track_play_id = tp.id
+WHERE u.username = %(username)s
+AND tp.played_at >= %(since)s
+AND tp.skipped IS NOT TRUE
+AND EXISTS (SELECT 1 FROM artist_tracks at WHERE at.track_id = t.id)
+AND t.duration_ms > 30000
+AND tp.skip_score IS NOT NULL
+AND t.duration_ms * (1 - tp.skip_score) >= LEAST(t.duration_ms / 2, 240000)
+AND (s.track_play_id IS NULL OR (s.status = 'failed' AND s.attempts < %(max_attempts)s))
+ORDER BY tp.played_at, tp.id
+LIMIT %(limit)s;
+"""
+
+# Marks plays as being submitted, committed before the request is sent.
+MARK_SCROBBLES_PENDING_SQL = """
+INSERT INTO scrobbles (track_play_id, status, attempts)
+SELECT id, 'pending', 1
+FROM unnest(%(track_play_ids)s::int[]) AS id
+ON CONFLICT (track_play_id) DO UPDATE
+    SET status = 'pending',
+        attempts = scrobbles.attempts + 1,
+        error = NULL,
+        updated_at = now();
+"""
+
+UPDATE_SCROBBLE_SQL = """
+UPDATE scrobbles
+SET status = %(status)s, error = %(error)s, updated_at = now()
+WHERE track_play_id = %(track_play_id)s;
+"""
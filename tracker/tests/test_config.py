@@ -0,0 +1,133 @@
+import pytest
+
+import config
+
+
+@pytest.fixture
+def settings(monkeypatch):
+    """Give _setting a fresh config file, ERRORS and SETTINGS."""
+    monkeypatch.setattr(config, "ERRORS", [])
+    monkeypatch.setattr(config, "SETTINGS", {})
+    monkeypatch.setattr(config, "_file", {})
+    return config
+
+
+def test_env_wins_over_file_and_default(settings, monkeypatch):
+    monkeypatch.setattr(config, "_file", {"poll_interval": 5})
+    monkeypatch.setenv("POLL_INTERVAL", "3")
+
+    assert settings._setting("POLL_INTERVAL", 2, float) == 3.0
+    assert settings.SETTINGS["POLL_INTERVAL"] == (3.0, "env")
+
+
+def test_file_wins_over_default(settings, monkeypatch):
+    monkeypatch.setattr(config, "_file", {"poll_interval": 5})
+    monkeypatch.delenv("POLL_INTERVAL", raising=False)
+
+    assert settings._setting("POLL_INTERVAL", 2, float) == 5.0
+    assert settings.SETTINGS["POLL_INTERVAL"] == (5.0, "file")
+
+
+def test_empty_env_counts_as_unset(settings, monkeypatch):
+    monkeypatch.setattr(config, "_file", {"poll_interval": 5})
+    monkeypatch.setenv("POLL_INTERVAL", "")
+    monkeypatch.setenv("POLL_MAX_INTERVAL", "")
+
+    assert settings._setting("POLL_INTERVAL", 2, float) == 5.0
+    assert settings._setting("POLL_MAX_INTERVAL", None, float, config._at_least(0.1), "must be at least 0.1") is None
+    assert settings.SETTINGS["POLL_MAX_INTERVAL"] == (None, "default")
+    assert settings.ERRORS == []
+
+
+@pytest.mark.parametrize("name", ["POLL_MAX_INTERVAL", "STATSD_ADDR", "DISCORD_WEBHOOK_URL", "SLACK_WEBHOOK_URL"])
+def test_blank_template_values_are_valid(settings, monkeypatch, name):
+    monkeypatch.setenv(name, "")
+
+    assert settings._setting(name, None, check=lambda value: False, requirement="is never valid") is None
+    assert settings.ERRORS == []
+
+
+def test_every_invalid_setting_is_reported(settings, monkeypatch):
+    monkeypatch.setenv("POLL_INTERVAL", "soon")
+    monkeypatch.setenv("SKIP_THRESHOLD", "2")
+
+    assert settings._setting("POLL_INTERVAL", 2, float) == 2.0
+    assert settings._setting("SKIP_THRESHOLD", 0.9, float, lambda value: 0 < value <= 1, "must be in (0, 1]") == 0.9
+    assert settings.ERRORS == ["POLL_INTERVAL='soon' cannot be parsed", "SKIP_THRESHOLD='2' must be in (0, 1]"]
+    assert settings.SETTINGS["SKIP_THRESHOLD"] == (0.9, "default")
+
+
+def test_describe_redacts_secrets(settings, monkeypatch):
+    monkeypatch.setenv("NAVIDROME_PASSWORD", "hunter2")
+    settings._setting("NAVIDROME_PASSWORD", "admin")
+    settings._setting("MILESTONE_COUNTS", "100,500", config._int_list)
+
+    assert settings.describe() == ["NAVIDROME_PASSWORD=<redacted>  # env", "MILESTONE_COUNTS=100,500  # default"]
+
+
+def write_env(tmp_path, text: str) -> str:
+    path = tmp_path / ".env"
+    path.write_text(text, encoding="utf-8")
+    return str(path)
+
+
+def test_env_file_comments_and_blank_lines(tmp_path):
+    path = write_env(tmp_path, "# Database\n\nPOSTGRES_DB=music  # trailing comment\n   # indented comment\n")
+
+    assert config.parse_env_file(path) == ({"POSTGRES_DB": "music"}, [])
+
+
+def test_env_file_quoted_values(tmp_path):
+    path = write_env(tmp_path, "\n".join([
+        "SINGLE='literal \\n # not a comment'",
+        'DOUBLE="two\\nlines \\"quoted\\""  # comment',
+        "EMPTY=''",
+        "export EXPORTED=yes",
+    ]))
+
+    assert config.parse_env_file(path) == ({
+        "SINGLE": "literal \\n # not a comment",
+        "DOUBLE": 'two\nlines "quoted"',
+        "EMPTY": "",
+        "EXPORTED": "yes",
+    }, [])
+
+
+def test_env_file_values_containing_equals(tmp_path):
+    path = write_env(tmp_path, "STATS_DATABASE_URL=host=db port=5432 dbname=music\n"
+                               "URL=https://example.com/?a=1&b=2#frag\n"
+                               "QUOTED='a=b'\n")
+
+    assert config.parse_env_file(path)[0] == {
+        "STATS_DATABASE_URL": "host=db port=5432 dbname=music",
+        "URL": "https://example.com/?a=1&b=2#frag",
+        "QUOTED": "a=b",
+    }
+
+
+def test_env_file_malformed_lines_name_path_and_line(tmp_path):
+    path = write_env(tmp_path, "\n".join([
+        "POSTGRES_DB=music",
+        "POLL_INTERVAL 5",
+        "NAVIDROME_PASSWORD=\"unterminated",
+        "2FA=on",
+        "LOG_LEVEL='debug' info",
+        "LOG_FORMAT=text",
+    ]))
+
+    variables, problems = config.parse_env_file(path)
+
+    assert variables == {"POSTGRES_DB": "music", "LOG_FORMAT": "text"}
+    assert problems == [
+        f"{path}:2: expected KEY=value, got 'POLL_INTERVAL 5'",
+        f"{path}:3: NAVIDROME_PASSWORD: unterminated double quote",
+        f"{path}:4: expected KEY=value, got '2FA=on'",
+        f"{path}:5: LOG_LEVEL: unexpected text after the closing quote",
+    ]
+
+
+def test_env_flag_is_not_abbreviated():
+    args = config._config_parser.parse_known_args(["--env", "other.env", "--env-file", "local.env"])[0]
+
+    assert args.env_file == "local.env"
+    assert config._config_parser.parse_known_args(["--env", "other.env"])[0].env_file is None
@@ -0,0 +1,53 @@
+from unittest import mock
+
+import psycopg2
+import psycopg2.errors
+import pytest
+
+import listener
+from config import DB_RETRY_ATTEMPTS, DB_RETRY_DELAY
+from listener import DatabaseWriter
+
+
+@pytest.fixture
+def sleeps(monkeypatch):
+    sleeps = []
+    monkeypatch.setattr(listener.time, "sleep", sleeps.append)
+    return sleeps
+
+
+def writer_with(*outcomes) -> tuple[DatabaseWriter, mock.MagicMock]:
+    """A writer whose cursor raises or returns the outcomes in turn."""
+    conn = mock.MagicMock()
+    cur = conn.cursor.return_value.__enter__.return_value
+    cur.execute.side_effect = outcomes
+    cur.description = None
+    return DatabaseWriter(conn), conn
+
+
+def test_transient_error_is_retried(sleeps):
+    writer, conn = writer_with(psycopg2.errors.SerializationFailure("could not serialize access"), None)
+
+    assert writer._execute("UPDATE users SET username = %(name)s;", {"name": "admin"}) == []
+    assert conn.cursor.return_value.__enter__.return_value.execute.call_count == 2
+    conn.rollback.assert_called_once()
+    conn.commit.assert_called_once()
+    assert sleeps == [DB_RETRY_DELAY]
+
+
+def test_retries_give_up_after_last_attempt(sleeps):
+    writer, conn = writer_with(*[psycopg2.errors.DeadlockDetected("deadlock detected")] * DB_RETRY_ATTEMPTS)
+
+    with pytest.raises(psycopg2.errors.DeadlockDetected):
+        writer._execute("UPDATE users SET username = %(name)s;", {"name": "admin"})
+    assert conn.rollback.call_count == DB_RETRY_ATTEMPTS
+    conn.commit.assert_not_called()
+    assert len(sleeps) == DB_RETRY_ATTEMPTS - 1
+
+
+def test_connection_errors_are_left_to_the_main_loop(sleeps):
+    writer, conn = writer_with(psycopg2.OperationalError("server closed the connection"))
+
+    with pytest.raises(psycopg2.OperationalError):
+        writer._execute("UPDATE users SET username = %(name)s;", {"name": "admin"})
+    assert sleeps == []
@@ -0,0 +1,59 @@
+import json
+from unittest import mock
+
+import listener
+from listener import DatabaseWriter, FixtureClient, HealthStatus, SongProcessor
+from sql_queries import INSERT_SQL, TRACK_GENRES_SQL
+
+NOW_PLAYING = {"subsonic-response": {"status": "ok", "nowPlaying": {"entry": [{
+    "username": "admin",
+    "playerName": "Feishin",
+    "title": "Teardrop",
+    "artist": "Massive Attack",
+    "album": "Mezzanine",
+    "duration": 330,
+    "musicBrainzId": "2f4b4e2c-5a1e-4d2b-9d6f-1f6f0e6a7c11",
+}]}}}
+
+
+def play_one_track(tmp_path, clock, dry_run: bool) -> mock.MagicMock:
+    """Replay five minutes of a playing track and an empty poll, and return the writer's connection."""
+    fixture = tmp_path / "now_playing.json"
+    fixture.write_text(json.dumps([{"polls": 2, "response": NOW_PLAYING}]))
+    conn = mock.MagicMock()
+    cur = conn.cursor.return_value.__enter__.return_value
+    cur.fetchall.return_value = [("trip hop",)]
+    cur.description = None
+    client = FixtureClient(HealthStatus(poll_interval=1.0, last_health_log=0), str(fixture))
+    processor = SongProcessor(DatabaseWriter(conn, dry_run=dry_run))
+
+    for elapsed in (0, 300_000, 1_000):
+        clock[0] += elapsed
+        client.fetch_songs()
+        processor.process()
+    return conn
+
+
+def executed(conn: mock.MagicMock) -> list[str]:
+    return [call.args[0] for call in conn.cursor.return_value.__enter__.return_value.execute.call_args_list]
+
+
+def test_dry_run_parses_and_decides_but_writes_nothing(tmp_path, clock):
+    with mock.patch.object(listener.log, "info") as info:
+        conn = play_one_track(tmp_path, clock, dry_run=True)
+
+    # The only statement is the read-only genre lookup, and nothing is committed.
+    assert executed(conn) == [TRACK_GENRES_SQL]
+    conn.commit.assert_not_called()
+    play = next(call.kwargs for call in info.call_args_list if call.args == ("(DRY RUN) Would record track play",))
+    assert play["title"] == "Teardrop"
+    assert play["genres"] == ["trip hop"]
+    assert play["skipped"] is False
+    assert listener.lastPlaybacks == {}
+
+
+def test_same_pipeline_writes_without_dry_run(tmp_path, clock):
+    conn = play_one_track(tmp_path, clock, dry_run=False)
+
+    assert INSERT_SQL in executed(conn)
+    conn.commit.assert_called()
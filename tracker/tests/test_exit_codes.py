@@ -0,0 +1,46 @@
+from unittest import mock
+
+import psycopg2
+import pytest
+import requests
+
+import exit_codes
+from exit_codes import LastfmError, NavidromeAuthError, NavidromeError
+
+
+@pytest.mark.parametrize("error, code", [
+    (NavidromeAuthError("wrong username or password"), exit_codes.AUTH),
+    (NavidromeError("Navidrome request failed"), exit_codes.NAVIDROME),
+    (psycopg2.OperationalError("connection refused"), exit_codes.DATABASE),
+    (LastfmError(9, "Invalid session key"), exit_codes.LASTFM),
+    (requests.RequestException("timeout"), exit_codes.FAILURE),
+    (ValueError("anything else"), exit_codes.FAILURE),
+])
+def test_exit_code(error, code):
+    assert exit_codes.exit_code(error) == code
+
+
+def test_codes_are_distinct():
+    codes = [exit_codes.OK, exit_codes.FAILURE, exit_codes.CONFIG, exit_codes.AUTH,
+             exit_codes.DATABASE, exit_codes.NAVIDROME, exit_codes.LASTFM]
+    assert len(set(codes)) == len(codes)
+
+
+def test_run_returns_code_of_raised_error():
+    def main():
+        raise LastfmError(None, "request failed")
+
+    assert exit_codes.run(main) == exit_codes.LASTFM
+    assert exit_codes.run(lambda: None) == exit_codes.OK
+
+
+def test_failed_navidrome_request_raises_navidrome_error():
+    import listener
+
+    client = listener.MusicStreamClient(health_status=listener.HealthStatus(
+        poll_interval=2, last_health_log=0, base_poll_interval=2))
+    with mock.patch.object(listener, "timed_get", side_effect=requests.ConnectionError("refused")):
+        with pytest.raises(NavidromeError, match="refused"):
+            client._fetch()
+        # The poll itself carries on.
+        client.fetch_songs()
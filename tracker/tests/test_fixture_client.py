@@ -0,0 +1,81 @@
+import json
+import os
+from unittest import mock
+
+import pytest
+
+import listener
+from listener import DatabaseWriter, FixtureClient, HealthStatus, MusicStreamClient, SongProcessor, load_fixture
+from sql_queries import INSERT_SQL
+
+FIXTURE = os.path.join(os.path.dirname(os.path.dirname(os.path.abspath(__file__))), "fixtures", "now_playing.json")
+POLL_MS = 10_000
+
+
+class RecordingWriter(DatabaseWriter):
+    """Records statements instead of running them; every play insert returns play 42."""
+
+    def __init__(self):
+        super().__init__(conn=None)
+        self.statements = []
+
+    def _execute(self, sql: str, params: dict) -> list[dict]:
+        self.statements.append((sql, params))
+        if sql == INSERT_SQL:
+            return [{"id": 42, "rediscovery": False, "days_since_last_play": None}]
+        return []
+
+    def detect_binge_session(self, track_play_id: int):
+        pass
+
+
+def replay(client: MusicStreamClient, clock, polls: int) -> list[tuple]:
+    """Poll every POLL_MS from a fresh start, and return the statements the writer ran."""
+    clock[0] = 1_700_000_000_000
+    listener.currentPlaybacks.clear()
+    listener.lastPlaybacks.clear()
+    writer = RecordingWriter()
+    processor = SongProcessor(writer)
+    for _ in range(polls):
+        client.fetch_songs()
+        processor.process()
+        clock[0] += POLL_MS
+    return writer.statements
+
+
+@pytest.fixture
+def health_status():
+    return HealthStatus(poll_interval=1.0, last_health_log=0)
+
+
+def test_fixture_replay_stores_the_same_plays_as_navidrome(clock, health_status, monkeypatch):
+    responses = load_fixture(FIXTURE)
+    # One poll past the end, so the songs still playing are finalized.
+    polls = len(responses) + 1
+
+    replayed = replay(FixtureClient(health_status, FIXTURE), clock, polls)
+
+    served = iter(responses + [FixtureClient.EMPTY_RESPONSE])
+    monkeypatch.setattr(listener, "timed_get", lambda *args, **kwargs: mock.Mock(
+        **{"json.return_value": next(served)}))
+    fetched = replay(MusicStreamClient(health_status), clock, polls)
+
+    assert [params for sql, params in replayed if sql == INSERT_SQL]
+    assert replayed == fetched
+
+
+def test_repeated_steps_are_served_once_per_poll(tmp_path):
+    playing = {"subsonic-response": {"status": "ok", "nowPlaying": {"entry": []}}}
+    stopped = {"subsonic-response": {"status": "ok", "nowPlaying": {}}}
+    path = tmp_path / "now_playing.json"
+    path.write_text(json.dumps([{"polls": 3, "response": playing}, stopped]))
+
+    assert load_fixture(str(path)) == [playing, playing, playing, stopped]
+
+
+def test_step_without_polls_is_rejected(tmp_path):
+    path = tmp_path / "broken.json"
+    path.write_text('[{"polls": 0, "response": {"subsonic-response": {}}}]')
+
+    with pytest.raises(ValueError, match="step 1"):
+        load_fixture(str(path))
@@ -0,0 +1,64 @@
+from datetime import datetime, timedelta, timezone
+
+from config import HEALTH_STALE_SECONDS
+from health import TrackerHealth
+
+
+def test_alive_while_loop_makes_progress():
+    health = TrackerHealth(ping=lambda: None)
+    health.loop_progressed(next_in=60)
+
+    assert health.liveness()[0]
+
+
+def test_stale_when_loop_is_overdue():
+    health = TrackerHealth(ping=lambda: None)
+    health.next_progress_by = datetime.now(timezone.utc) - timedelta(seconds=HEALTH_STALE_SECONDS + 1)
+
+    alive, body = health.liveness()
+
+    assert not alive
+    assert body["status"] == "stale"
+
+
+def test_failing_polls_do_not_make_tracker_stale():
+    health = TrackerHealth(ping=lambda: None)
+    health.loop_progressed(next_in=2)
+    health.poll_failed("Navidrome request failed: connection refused")
+
+    alive, body = health.liveness()
+
+    assert alive
+    assert body["last_error"] == "Navidrome request failed: connection refused"
+
+
+def test_ready_when_database_answers_and_navidrome_polled():
+    health = TrackerHealth(ping=lambda: None)
+    health.poll_succeeded()
+
+    ready, body = health.readiness()
+
+    assert ready
+    assert body["database"] == {"ok": True, "error": None}
+    assert body["navidrome"]["ok"]
+
+
+def test_not_ready_when_database_ping_fails():
+    health = TrackerHealth(ping=lambda: "connection refused")
+    health.poll_succeeded()
+
+    ready, body = health.readiness()
+
+    assert not ready
+    assert body["database"] == {"ok": False, "error": "connection refused"}
+
+
+def test_not_ready_before_first_navidrome_poll():
+    pings = []
+    health = TrackerHealth(ping=lambda: pings.append(1))
+
+    ready, body = health.readiness()
+
+    assert not ready
+    assert body["navidrome"] == {"ok": False, "last_poll_at": None}
+    assert pings == [1]
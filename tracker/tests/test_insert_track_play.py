@@ -0,0 +1,89 @@
+from datetime import datetime, timezone
+
+import pytest
+
+import listener
+from listener import PlaybackState, Song, SongProcessor
+
+AIRBAG = Song(title="Airbag", artist="Radiohead", album="OK Computer", duration=200000,
+              mbid="5d8e1c1a-0f55-4b59-9a8f-2b5c1a1f0d01")
+PARANOID_ANDROID = Song(title="Paranoid Android", artist="Radiohead", album="OK Computer", duration=380000,
+                        mbid="5d8e1c1a-0f55-4b59-9a8f-2b5c1a1f0d02")
+
+
+@pytest.fixture
+def tracks(db):
+    """The two songs as the genre-reader would have stored them."""
+    with db.cursor() as cur:
+        for song in (AIRBAG, PARANOID_ANDROID):
+            cur.execute("INSERT INTO tracks (title, duration_ms, mbid) VALUES (%s, %s, %s);",
+                        (song.title, song.duration, song.mbid))
+    db.commit()
+
+
+def rows(conn, sql: str) -> list[tuple]:
+    """Read in a transaction of its own, so what the writer committed is visible."""
+    with conn.cursor() as cur:
+        cur.execute(sql)
+        result = cur.fetchall()
+    conn.rollback()
+    return result
+
+
+def poll(processor: SongProcessor, song: Song = None):
+    listener.currentPlaybacks.clear()
+    if song:
+        listener.currentPlaybacks[("admin", "Feishin")] = PlaybackState(user_id="admin", client_id="Feishin",
+                                                                        song=song)
+    processor.process()
+
+
+def test_finalized_plays_are_stored_with_their_skip_events(db, db_writer, tracks, clock):
+    processor = SongProcessor(db_writer)
+
+    # Half of Airbag, then all of Paranoid Android, then nothing plays.
+    poll(processor, AIRBAG)
+    clock[0] += 100000
+    poll(processor, AIRBAG)
+    poll(processor, PARANOID_ANDROID)
+    clock[0] += 380000
+    poll(processor, PARANOID_ANDROID)
+    poll(processor)
+
+    assert rows(db, """
+        SELECT t.title, tp.skipped, tp.skip_score, tp.device_name
+        FROM track_plays tp JOIN tracks t ON t.id = tp.track_id
+        ORDER BY tp.played_at;
+    """) == [("Airbag", True, 0.5, "Feishin"), ("Paranoid Android", False, 0.0, "Feishin")]
+    assert rows(db, """
+        SELECT t.title, se.rule, se.expected_ms, se.played_ms, se.ratio, se.skipped
+        FROM skip_events se
+        JOIN track_plays tp ON tp.id = se.track_play_id
+        JOIN tracks t ON t.id = tp.track_id
+        ORDER BY tp.played_at;
+    """) == [
+        ("Airbag", "ratio", 200000, 100000, 0.5, True),
+        ("Paranoid Android", "ratio", 380000, 380000, 1.0, False),
+    ]
+    assert listener.lastPlaybacks == {}
+
+
+def test_finalizing_a_play_again_stores_it_once(db, db_writer, tracks):
+    played_at = datetime(2024, 3, 1, 20, 0, tzinfo=timezone.utc)
+    decision = SongProcessor(db_writer)._decide_skip(PlaybackState(song=AIRBAG, accumulated_playtime=100000))
+
+    # As after reconnecting in the middle of finalizing.
+    for _ in range(2):
+        db_writer.insert_track_play(AIRBAG, played_at, "admin", skipped=True, skip_score=0.5,
+                                    skip_decision=decision)
+
+    assert rows(db, "SELECT COUNT(*) FROM track_plays;") == [(1,)]
+    assert rows(db, "SELECT rule, skipped FROM skip_events;") == [("ratio", True)]
+    assert db_writer.plays_inserted == 1
+
+
+def test_play_of_an_unknown_track_is_not_stored(db, db_writer):
+    db_writer.insert_track_play(AIRBAG, datetime(2024, 3, 1, 20, 0, tzinfo=timezone.utc), "admin", skipped=False)
+
+    assert rows(db, "SELECT COUNT(*) FROM track_plays;") == [(0,)]
+    assert db_writer.plays_inserted == 0
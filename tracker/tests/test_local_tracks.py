@@ -0,0 +1,78 @@
+from datetime import datetime, timedelta, timezone
+
+import listener
+from listener import HealthStatus, MusicStreamClient
+
+# A file without MusicBrainz tags, as Navidrome lists it.
+LOCAL_ENTRY = {
+    "username": "admin",
+    "playerName": "Feishin",
+    "title": "Bedroom Demo",
+    "artist": "Home Recordings",
+    "album": "",
+    "duration": 185,
+    "path": "Home Recordings/Bedroom Demo.flac",
+}
+
+PLAYED_AT = datetime(2024, 3, 1, 20, 0, tzinfo=timezone.utc)
+
+
+def handle(entry: dict) -> listener.Song:
+    """Parse one now-playing entry and return the song it is tracked as."""
+    client = MusicStreamClient(HealthStatus(poll_interval=1.0, last_health_log=0))
+    listener.currentPlaybacks.clear()
+    client._handle_entry(entry)
+    return listener.currentPlaybacks[("admin", "Feishin")].song
+
+
+def rows(conn, sql: str, params: tuple = ()) -> list[tuple]:
+    """Read in a transaction of its own, so what the writer committed is visible."""
+    with conn.cursor() as cur:
+        cur.execute(sql, params)
+        result = cur.fetchall()
+    conn.rollback()
+    return result
+
+
+def test_local_file_gets_a_stable_synthetic_mbid(clock):
+    first = handle(LOCAL_ENTRY)
+    again = handle(dict(LOCAL_ENTRY))
+    other = handle({**LOCAL_ENTRY, "title": "Bedroom Demo 2"})
+
+    assert first.is_local
+    assert first.mbid == again.mbid
+    assert first.mbid != other.mbid
+    assert first.duration == 185000
+
+
+def test_tagged_file_keeps_its_mbid(clock):
+    song = handle({**LOCAL_ENTRY, "musicBrainzId": "2f4b4e2c-5a1e-4d2b-9d6f-1f6f0e6a7c11"})
+
+    assert not song.is_local
+    assert song.mbid == "2f4b4e2c-5a1e-4d2b-9d6f-1f6f0e6a7c11"
+
+
+def test_local_play_is_stored_as_a_local_track(db, db_writer, clock):
+    song = handle(LOCAL_ENTRY)
+
+    db_writer.insert_track_play(song, PLAYED_AT, "admin", skipped=False, skip_score=0.0)
+    db_writer.insert_track_play(handle(LOCAL_ENTRY), PLAYED_AT + timedelta(hours=1), "admin", skipped=False)
+
+    # Both plays land on one track, created without an artist lookup.
+    assert rows(db, "SELECT title, duration_ms, mbid::text, is_local FROM tracks;") == [
+        ("Bedroom Demo", 185000, song.mbid, True),
+    ]
+    assert rows(db, """
+        SELECT a.name FROM artist_tracks at JOIN artists a ON a.id = at.artist_id;
+    """) == [("Home Recordings",)]
+    assert rows(db, "SELECT COUNT(*) FROM track_plays;") == [(2,)]
+
+
+def test_scrobble_candidates_leave_out_the_synthetic_mbid(db, db_writer, clock):
+    db_writer.insert_track_play(handle(LOCAL_ENTRY), PLAYED_AT, "admin", skipped=False, skip_score=0.0)
+
+    candidates = db_writer.scrobble_candidates("admin", PLAYED_AT - timedelta(days=1), limit=10, max_attempts=3)
+
+    assert [(row["title"], row["artist"], row["mbid"]) for row in candidates] == [
+        ("Bedroom Demo", "Home Recordings", None),
+    ]
@@ -0,0 +1,75 @@
+import threading
+import urllib.request
+from http.server import ThreadingHTTPServer
+from unittest import mock
+
+import pytest
+
+import metrics
+from health import HealthHandler
+from listener import DatabaseWriter, HealthStatus, MusicStreamClient
+from sql_queries import TRACK_GENRES_SQL
+
+NOW_PLAYING = {"subsonic-response": {"status": "ok", "nowPlaying": {"entry": [{
+    "username": "admin",
+    "playerName": "Feishin",
+    "title": "Teardrop",
+    "artist": "Massive Attack",
+    "album": "Mezzanine",
+    "duration": 330,
+    "musicBrainzId": "2f4b4e2c-5a1e-4d2b-9d6f-1f6f0e6a7c11",
+}]}}}
+
+EXPECTED = {
+    "navidrome_requests_total",
+    "navidrome_request_duration_seconds_count",
+    "plays_inserted_total",
+    "plays_skipped_total",
+    "poll_errors_total",
+    "db_write_duration_seconds_count",
+    "last_successful_poll_timestamp_seconds",
+    "poll_interval_seconds",
+}
+
+
+@pytest.fixture
+def server():
+    server = ThreadingHTTPServer(("127.0.0.1", 0), HealthHandler)
+    threading.Thread(target=server.serve_forever, daemon=True).start()
+    yield f"http://127.0.0.1:{server.server_address[1]}"
+    server.shutdown()
+    server.server_close()
+
+
+def simulate_poll(monkeypatch):
+    """One getNowPlaying request against a fake Navidrome and one database statement."""
+    response = mock.Mock(status_code=200)
+    response.json.return_value = NOW_PLAYING
+    monkeypatch.setattr(metrics.requests, "get", mock.Mock(return_value=response))
+    MusicStreamClient(HealthStatus(poll_interval=1.0, last_health_log=0)).fetch_songs()
+    DatabaseWriter(mock.MagicMock())._execute(TRACK_GENRES_SQL, {"mbid": "2f4b4e2c-5a1e-4d2b-9d6f-1f6f0e6a7c11"})
+
+
+def scrape(server: str) -> dict:
+    """Samples of /metrics by name and labels."""
+    with urllib.request.urlopen(f"{server}/metrics") as response:
+        assert response.status == 200
+        text = response.read().decode()
+    samples = {}
+    for line in text.splitlines():
+        if line and not line.startswith("#"):
+            series, value = line.rsplit(" ", 1)
+            samples[series] = float(value)
+    return samples
+
+
+def test_scrape_lists_metrics_after_a_poll(server, monkeypatch, clock):
+    # clock keeps the poll's playback out of the other tests.
+    simulate_poll(monkeypatch)
+
+    samples = scrape(server)
+
+    names = {series.split("{", 1)[0] for series in samples}
+    assert EXPECTED <= names
+    assert samples['navidrome_requests_total{endpoint="getNowPlaying",status="200"}'] >= 1
+    assert samples["last_successful_poll_timestamp_seconds"] > 0
@@ -0,0 +1,98 @@
+import hashlib
+import hmac
+import json
+from datetime import datetime, timezone
+from unittest import mock
+
+import pytest
+import requests
+
+import notifiers
+from notifiers import Delivery, WebhookNotifier, sign
+
+PLAY = {
+    "track": "Teardrop",
+    "artist": "Massive Attack",
+    "album": "Mezzanine",
+    "played_at": datetime(2024, 3, 1, 18, 0, tzinfo=timezone.utc),
+    "context": {"user": "admin", "device_name": "Feishin", "skipped": False},
+}
+
+
+class RecordingDelivery(Delivery):
+    """Keeps the requests that would be sent, with their headers computed."""
+
+    def __init__(self):
+        super().__init__()
+        self.sent = []
+
+    def post(self, service, url, payload, headers=None):
+        body = json.dumps(payload, default=notifiers._json_default).encode()
+        self.sent.append((url, body, headers(body) if callable(headers) else headers or {}))
+
+
+@pytest.fixture
+def sleeps(monkeypatch):
+    sleeps = []
+    monkeypatch.setattr(notifiers.time, "sleep", sleeps.append)
+    return sleeps
+
+
+def http_error(status: int) -> requests.HTTPError:
+    error = requests.HTTPError(f"{status} Server Error")
+    error.response = mock.Mock(status_code=status)
+    return error
+
+
+def test_sign_is_hmac_sha256_of_the_body():
+    body = b"The quick brown fox jumps over the lazy dog"
+
+    assert sign(body, "key") == "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+
+
+def test_webhook_posts_signed_body_to_every_url():
+    delivery = RecordingDelivery()
+    notifier = WebhookNotifier(["https://a.example.com/hook", "https://b.example.com/hook"], "s3cret", delivery)
+
+    notifier.poll_finished([PLAY], [])
+
+    assert [url for url, _, _ in delivery.sent] == ["https://a.example.com/hook", "https://b.example.com/hook"]
+    url, body, headers = delivery.sent[0]
+    expected = hmac.new(b"s3cret", body, hashlib.sha256).hexdigest()
+    assert headers == {"X-Webhook-Signature": f"sha256={expected}"}
+    assert json.loads(body) == {"plays": [{**PLAY, "played_at": "2024-03-01T18:00:00+00:00"}], "milestones": []}
+
+
+def test_webhook_without_secret_is_unsigned():
+    delivery = RecordingDelivery()
+
+    WebhookNotifier(["https://a.example.com/hook"], None, delivery).poll_finished([PLAY], [])
+
+    assert delivery.sent[0][2] == {}
+
+
+def test_webhook_skips_polls_without_plays():
+    delivery = RecordingDelivery()
+
+    WebhookNotifier(["https://a.example.com/hook"], "s3cret", delivery).poll_finished([], [])
+
+    assert delivery.sent == []
+
+
+def test_delivery_retries_with_backoff(monkeypatch, sleeps):
+    post = mock.Mock(side_effect=[requests.ConnectionError("refused"), http_error(503), mock.Mock()])
+    monkeypatch.setattr(notifiers.requests, "post", post)
+
+    assert Delivery()._deliver("webhook", "https://a.example.com/hook", b"{}", {})
+    assert post.call_count == 3
+    assert sleeps == [notifiers.WEBHOOK_BACKOFF, notifiers.WEBHOOK_BACKOFF * 2]
+    assert post.call_args.kwargs["timeout"] == notifiers.WEBHOOK_TIMEOUT
+
+
+def test_failed_delivery_is_dropped_without_raising(monkeypatch, sleeps):
+    post = mock.Mock(side_effect=requests.Timeout("timed out"))
+    monkeypatch.setattr(notifiers.requests, "post", post)
+
+    assert not Delivery()._deliver("webhook", "https://a.example.com/hook", b"{}", {})
+    assert post.call_count == notifiers.WEBHOOK_RETRIES + 1
+    assert len(sleeps) == notifiers.WEBHOOK_RETRIES
@@ -0,0 +1,53 @@
+from unittest import mock
+
+import listener
+from listener import HealthStatus, MusicStreamClient
+
+
+def fetch_with(client: MusicStreamClient, monkeypatch) -> mock.Mock:
+    """Run one getNowPlaying request against a fake Navidrome and return the request mock."""
+    timed_get = mock.Mock()
+    timed_get.return_value.json.return_value = {"subsonic-response": {"status": "ok", "nowPlaying": {}}}
+    monkeypatch.setattr(listener, "timed_get", timed_get)
+    client._fetch()
+    return timed_get
+
+
+def new_client(password_file=None) -> MusicStreamClient:
+    return MusicStreamClient(HealthStatus(poll_interval=1.0, last_health_log=0), password_file=password_file)
+
+
+def test_password_is_read_from_file(tmp_path, monkeypatch):
+    password_file = tmp_path / "navidrome_password"
+    password_file.write_text("s3cret\n")
+    monkeypatch.setattr(listener, "NAVIDROME_PASSWORD", "from-env")
+
+    timed_get = fetch_with(new_client(str(password_file)), monkeypatch)
+
+    assert timed_get.call_args.kwargs["params"]["p"] == "s3cret"
+
+
+def test_password_file_is_reread_on_every_request(tmp_path, monkeypatch):
+    password_file = tmp_path / "navidrome_password"
+    password_file.write_text("old\n")
+    client = new_client(str(password_file))
+    fetch_with(client, monkeypatch)
+
+    password_file.write_text("rotated\n")
+    timed_get = fetch_with(client, monkeypatch)
+
+    assert timed_get.call_args.kwargs["params"]["p"] == "rotated"
+
+
+def test_env_password_is_used_without_a_file(monkeypatch):
+    monkeypatch.setattr(listener, "NAVIDROME_PASSWORD", "from-env")
+
+    timed_get = fetch_with(new_client(), monkeypatch)
+
+    assert timed_get.call_args.kwargs["params"]["p"] == "from-env"
+
+
+def test_unreadable_file_skips_the_request(tmp_path, monkeypatch):
+    timed_get = fetch_with(new_client(str(tmp_path / "missing")), monkeypatch)
+
+    timed_get.assert_not_called()
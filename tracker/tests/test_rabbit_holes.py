@@ -0,0 +1,126 @@
+from datetime import datetime, timedelta, timezone
+
+import pytest
+
+from listener import Song, rabbit_hole_run
+
+START = datetime(2024, 6, 1, 20, 0, tzinfo=timezone.utc)
+GAP = timedelta(minutes=30)
+RADIOHEAD, PORTISHEAD = 1, 2
+
+
+def newest_first(*entries) -> list[dict]:
+    """(track_id, minutes after START, artist ids) per play, oldest first as listened."""
+    plays = [{"id": index, "track_id": track_id, "played_at": START + timedelta(minutes=minutes),
+              "artist_ids": artist_ids}
+             for index, (track_id, minutes, artist_ids) in enumerate(entries)]
+    return plays[::-1]
+
+
+def run_ids(plays: list[dict], artist_id: int, max_detour: int = 1) -> list[int]:
+    return [play["id"] for play in rabbit_hole_run(plays, artist_id, max_detour, GAP)]
+
+
+def test_run_goes_back_over_short_detours():
+    plays = newest_first((1, 0, [RADIOHEAD]), (10, 4, [PORTISHEAD]), (2, 8, [RADIOHEAD]), (3, 12, [RADIOHEAD]))
+
+    assert run_ids(plays, RADIOHEAD) == [3, 2, 0]
+
+
+def test_run_stops_at_a_longer_detour():
+    plays = newest_first((1, 0, [RADIOHEAD]), (10, 4, [PORTISHEAD]), (11, 8, [PORTISHEAD]), (2, 12, [RADIOHEAD]))
+
+    assert run_ids(plays, RADIOHEAD) == [3]
+    assert run_ids(plays, RADIOHEAD, max_detour=2) == [3, 0]
+
+
+def test_run_stops_at_a_pause():
+    plays = newest_first((1, 0, [RADIOHEAD]), (2, 40, [RADIOHEAD]), (3, 44, [RADIOHEAD]))
+
+    assert run_ids(plays, RADIOHEAD) == [2, 1]
+
+
+def test_collaborations_belong_to_each_artist():
+    plays = newest_first((1, 0, [RADIOHEAD]), (5, 4, [RADIOHEAD, PORTISHEAD]), (10, 8, [PORTISHEAD]))
+
+    assert run_ids(plays, PORTISHEAD) == [2, 1]
+    assert run_ids(plays[1:], RADIOHEAD) == [1, 0]
+
+
+AIRBAG, LUCKY, RECKONER = (
+    Song(title=title, artist="Radiohead", album="OK Computer", duration=240000,
+         mbid=f"5d8e1c1a-0f55-4b59-9a8f-2b5c1a1f0d0{number}")
+    for number, title in enumerate(("Airbag", "Lucky", "Reckoner"), 1))
+ROADS, GLORY_BOX = (
+    Song(title=title, artist="Portishead", album="Dummy", duration=300000,
+         mbid=f"7a3c2e1b-4d5f-4a6b-8c7d-9e0f1a2b3c0{number}")
+    for number, title in enumerate(("Roads", "Glory Box"), 1))
+
+
+@pytest.fixture
+def tracks(db):
+    with db.cursor() as cur:
+        for song in (AIRBAG, LUCKY, RECKONER, ROADS, GLORY_BOX):
+            cur.execute("INSERT INTO artists (name) VALUES (%s) ON CONFLICT (name) DO NOTHING;", (song.artist,))
+            cur.execute("""
+                WITH track AS (
+                    INSERT INTO tracks (title, duration_ms, mbid) VALUES (%s, %s, %s) RETURNING id
+                )
+                INSERT INTO artist_tracks (artist_id, track_id)
+                SELECT a.id, track.id FROM artists a, track WHERE a.name = %s;
+            """, (song.title, song.duration, song.mbid, song.artist))
+    db.commit()
+
+
+def holes(conn) -> list[tuple]:
+    with conn.cursor() as cur:
+        cur.execute("""
+            SELECT rh.id::text, a.name, rh.plays, rh.tracks, rh.still_active
+            FROM rabbit_holes rh JOIN artists a ON a.id = rh.artist_id
+            ORDER BY rh.started_at;
+        """)
+        rows = cur.fetchall()
+    conn.rollback()
+    return rows
+
+
+def listen(writer, minutes: float, *songs: Song):
+    """Store unskipped plays four minutes apart, starting minutes after START."""
+    for index, song in enumerate(songs):
+        writer.insert_track_play(song, START + timedelta(minutes=minutes + 4 * index), "admin",
+                                 skipped=False, skip_score=0.0)
+
+
+def test_rabbit_hole_stays_active_until_the_run_breaks_off(db, db_writer, tracks):
+    listen(db_writer, 0, AIRBAG, LUCKY, ROADS, RECKONER, AIRBAG)
+    assert holes(db) == []
+
+    listen(db_writer, 20, LUCKY)
+    [(hole_id, artist, plays, distinct, active)] = holes(db)
+    assert (artist, plays, distinct, active) == ("Radiohead", 5, 3, True)
+
+    # The next play of the artist extends the same rabbit hole.
+    listen(db_writer, 24, RECKONER)
+    assert holes(db) == [(hole_id, "Radiohead", 6, 3, True)]
+
+    # One detour keeps it open, the second ends it.
+    listen(db_writer, 28, ROADS)
+    assert holes(db)[0][4] is True
+    listen(db_writer, 32, GLORY_BOX)
+    assert holes(db) == [(hole_id, "Radiohead", 6, 3, False)]
+
+
+def test_pause_ends_a_rabbit_hole(db, db_writer, tracks):
+    listen(db_writer, 0, AIRBAG, LUCKY, RECKONER, AIRBAG, LUCKY)
+    assert [hole[4] for hole in holes(db)] == [True]
+
+    listen(db_writer, 120, RECKONER)
+
+    assert [(hole[2], hole[4]) for hole in holes(db)] == [(5, False)]
+
+
+def test_skipped_plays_are_not_part_of_a_rabbit_hole(db, db_writer, tracks):
+    listen(db_writer, 0, AIRBAG, LUCKY, RECKONER, AIRBAG)
+    db_writer.insert_track_play(LUCKY, START + timedelta(minutes=16), "admin", skipped=True, skip_score=0.9)
+
+    assert holes(db) == []
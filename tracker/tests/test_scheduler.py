@@ -0,0 +1,86 @@
+import random
+
+import pytest
+
+from scheduler import PollScheduler, jittered
+
+
+@pytest.fixture(autouse=True)
+def seeded_random():
+    random.seed(83)
+
+
+def test_jittered_sleeps_vary_within_bounds():
+    delays = [jittered(60.0, 5.0) for _ in range(200)]
+
+    assert all(55.0 <= delay <= 65.0 for delay in delays)
+    assert len(set(delays)) > 100
+    assert min(delays) < 58.0 and max(delays) > 62.0
+
+
+def test_no_jitter_keeps_the_interval():
+    assert [jittered(60.0, 0.0) for _ in range(3)] == [60.0, 60.0, 60.0]
+
+
+def test_jitter_never_sleeps_a_negative_time():
+    assert all(jittered(1.0, 5.0) >= 0.0 for _ in range(200))
+
+
+def test_scheduler_delays_vary_around_the_base_interval():
+    scheduler = PollScheduler(base_interval=10.0, jitter=2.0)
+
+    delays = []
+    for _ in range(50):
+        scheduler.record_poll(active=True)
+        delays.append(scheduler.next_delay())
+
+    assert all(8.0 <= delay <= 12.0 for delay in delays)
+    assert len(set(delays)) == len(delays)
+
+
+def idle(scheduler: PollScheduler, polls: int) -> list[float]:
+    delays = []
+    for _ in range(polls):
+        scheduler.record_poll(active=False)
+        delays.append(scheduler.next_delay())
+    return delays
+
+
+def test_backs_off_after_idle_polls_up_to_the_maximum():
+    scheduler = PollScheduler(base_interval=10.0, max_interval=60.0, idle_polls=3)
+
+    assert idle(scheduler, 7) == [10.0, 10.0, 10.0, 20.0, 40.0, 60.0, 60.0]
+
+
+def test_snaps_back_when_something_plays():
+    scheduler = PollScheduler(base_interval=10.0, max_interval=60.0, idle_polls=1)
+    idle(scheduler, 4)
+
+    scheduler.record_poll(active=True)
+
+    assert scheduler.next_delay() == 10.0
+    assert idle(scheduler, 2) == [10.0, 20.0]
+
+
+def test_fixed_interval_without_maximum():
+    scheduler = PollScheduler(base_interval=10.0, idle_polls=1)
+
+    assert idle(scheduler, 5) == [10.0] * 5
+    assert scheduler.longest_interval == 10.0
+
+
+def test_minimum_wins_when_longer():
+    scheduler = PollScheduler(base_interval=10.0, max_interval=60.0)
+
+    assert scheduler.next_delay(minimum=30.0) == 30.0
+    assert scheduler.next_delay(minimum=5.0) == 10.0
+    assert scheduler.longest_interval == 60.0
+
+
+def test_backed_off_delay_is_jittered():
+    scheduler = PollScheduler(base_interval=10.0, jitter=1.0, max_interval=40.0, idle_polls=0)
+    idle(scheduler, 3)
+
+    delays = [scheduler.next_delay() for _ in range(20)]
+
+    assert all(39.0 <= delay <= 41.0 for delay in delays)
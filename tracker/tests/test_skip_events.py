@@ -0,0 +1,74 @@
+import pytest
+
+import listener
+from listener import DatabaseWriter, PlaybackState, Song, SongProcessor
+from sql_queries import INSERT_SKIP_EVENT_SQL, INSERT_SQL
+
+FIRST = Song(title="Airbag", artist="Radiohead", album="OK Computer", duration=200000,
+             mbid="5d8e1c1a-0f55-4b59-9a8f-2b5c1a1f0d01")
+SECOND = Song(title="Paranoid Android", artist="Radiohead", album="OK Computer", duration=380000,
+              mbid="5d8e1c1a-0f55-4b59-9a8f-2b5c1a1f0d02")
+
+
+class RecordingWriter(DatabaseWriter):
+    """Records statements instead of running them; the play insert returns play 42."""
+
+    def __init__(self):
+        super().__init__(conn=None)
+        self.statements = []
+
+    def _execute(self, sql: str, params: dict) -> list[dict]:
+        self.statements.append((sql, params))
+        if sql == INSERT_SQL:
+            return [{"id": 42, "rediscovery": False, "days_since_last_play": None}]
+        return []
+
+    def detect_binge_session(self, track_play_id: int):
+        pass
+
+
+@pytest.fixture
+def writer():
+    return RecordingWriter()
+
+
+def play(processor: SongProcessor, clock, song: Song, ms: int):
+    """Poll once with song playing, then let ms pass and poll again."""
+    listener.currentPlaybacks.clear()
+    listener.currentPlaybacks[("admin", "Feishin")] = PlaybackState(user_id="admin", client_id="Feishin", song=song)
+    processor.process()
+    clock[0] += ms
+    processor.process()
+
+
+def skip_events(writer: RecordingWriter) -> list[dict]:
+    return [params for sql, params in writer.statements if sql == INSERT_SKIP_EVENT_SQL]
+
+
+def test_half_played_track_writes_a_skip_event(writer, clock):
+    processor = SongProcessor(writer)
+    play(processor, clock, FIRST, 100000)
+
+    play(processor, clock, SECOND, 1000)
+
+    assert skip_events(writer) == [{
+        "track_play_id": 42,
+        "rule": "ratio",
+        "expected_ms": 200000,
+        "played_ms": 100000,
+        "ratio": 0.5,
+        "threshold": SongProcessor.SKIP_THRESHOLD,
+        "min_skip_ms": SongProcessor.MIN_SKIP_MS,
+        "skipped": True,
+    }]
+
+
+def test_track_without_duration_records_unknown_rule(writer, clock):
+    processor = SongProcessor(writer)
+    play(processor, clock, Song(title="Untitled", artist="", album="", duration=0, mbid="local"), 60000)
+
+    play(processor, clock, SECOND, 1000)
+
+    event = skip_events(writer)[0]
+    assert (event["rule"], event["expected_ms"], event["ratio"], event["skipped"]) == (
+        "unknown_duration", None, None, False)
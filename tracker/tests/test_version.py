@@ -0,0 +1,24 @@
+import importlib
+
+import version
+
+
+def test_version_string_assembles_from_build_info(monkeypatch):
+    monkeypatch.setattr(version, "VERSION", "1.4.0")
+    monkeypatch.setattr(version, "GIT_COMMIT", "3f2c1ab")
+    monkeypatch.setattr(version, "BUILD_DATE", "2024-06-01T12:00:00Z")
+    monkeypatch.setattr(version, "PYTHON_VERSION", "3.12.3")
+
+    assert version.version_string() == "tracker 1.4.0 (commit 3f2c1ab, built 2024-06-01T12:00:00Z, Python 3.12.3)"
+
+
+def test_build_info_defaults_to_dev(monkeypatch):
+    for name in ("APP_VERSION", "GIT_COMMIT", "BUILD_DATE"):
+        monkeypatch.delenv(name, raising=False)
+    try:
+        info = importlib.reload(version).build_info()
+    finally:
+        monkeypatch.undo()
+        importlib.reload(version)
+
+    assert (info["version"], info["commit"], info["build_date"]) == ("dev", "dev", "dev")
@@ -0,0 +1,47 @@
+"""
+OpenTelemetry tracing for the tracker.
+
+Each poll is a root span with child spans for the Navidrome request, the
+processing of playbacks and every database statement. Spans are only
+exported when OTEL_EXPORTER_OTLP_ENDPOINT is set; otherwise `tracer` stays
+the no-op tracer of the OpenTelemetry API.
+"""
+from opentelemetry import trace
+
+from config import OTEL_EXPORTER_OTLP_ENDPOINT
+from logger import log
+from version import VERSION
+
+tracer = trace.get_tracer("tracker")
+
+
+def setup_tracing() -> bool:
+    """
+    Export spans over OTLP/HTTP to OTEL_EXPORTER_OTLP_ENDPOINT, if set.
+
+    The endpoint may also come from the config file; the other standard
+    OTEL_EXPORTER_OTLP_* settings are read by the exporter from the
+    environment. OTEL_SERVICE_NAME overrides the service name "tracker".
+
+    :return: Whether tracing was enabled
+    :rtype: bool
+    """
+    if not OTEL_EXPORTER_OTLP_ENDPOINT:
+        return False
+
+    # Only needed when exporting; without an endpoint the API alone is used.
+    from opentelemetry.exporter.otlp.proto.http.trace_exporter import OTLPSpanExporter
+    from opentelemetry.sdk.resources import OTELResourceDetector, Resource
+    from opentelemetry.sdk.trace import TracerProvider
+    from opentelemetry.sdk.trace.export import BatchSpanProcessor
+
+    resource = Resource({"service.name": "tracker", "service.version": VERSION}).merge(
+        OTELResourceDetector().detect())
+    provider = TracerProvider(resource=resource)
+    # Given explicitly, the endpoint is used as is, so add the traces path the
+    # exporter would append to OTEL_EXPORTER_OTLP_ENDPOINT from the environment.
+    exporter = OTLPSpanExporter(endpoint=f"{OTEL_EXPORTER_OTLP_ENDPOINT.rstrip('/')}/v1/traces")
+    provider.add_span_processor(BatchSpanProcessor(exporter))
+    trace.set_tracer_provider(provider)
+    log.info("Exporting traces", endpoint=OTEL_EXPORTER_OTLP_ENDPOINT)
+    return True
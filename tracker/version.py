@@ -0,0 +1,35 @@
+"""
+Build information for the tracker.
+
+Values are baked into the image as build args (see tracker/Dockerfile)
+and default to "dev" for local runs.
+
+Usage: python version.py
+"""
+import os
+import platform
+
+VERSION = os.getenv("APP_VERSION", "dev")
+GIT_COMMIT = os.getenv("GIT_COMMIT", "dev")
+BUILD_DATE = os.getenv("BUILD_DATE", "dev")
+PYTHON_VERSION = platform.python_version()
+
+# Sent with every request to Navidrome.
+USER_AGENT = f"music-analytics-tracker/{VERSION} (commit {GIT_COMMIT}; Python {PYTHON_VERSION})"
+
+
+def version_string() -> str:
+    return f"tracker {VERSION} (commit {GIT_COMMIT}, built {BUILD_DATE}, Python {PYTHON_VERSION})"
+
+
+def build_info() -> dict:
+    return {
+        "version": VERSION,
+        "commit": GIT_COMMIT,
+        "build_date": BUILD_DATE,
+        "python": PYTHON_VERSION,
+    }
+
+
+if __name__ == "__main__":
+    print(version_string())
@@ -163,6 +163,7 @@ class DatabaseReader:
                 JOIN artist_tracks at ON at.track_id = t.id
                 JOIN artists a ON a.id = at.artist_id
                 WHERE t.youtube_code IS NULL
+                AND NOT t.is_local
                 GROUP BY t.id, t.title, t.created_at
                 ORDER BY t.created_at ASC
                 LIMIT 1;